@@ -0,0 +1,110 @@
+package token_bucket
+
+import "sync"
+
+const (
+	defaultIncrease = 1   // default additive increase of refill rate
+	defaultDecrease = 0.5 // default multiplicative decrease of refill rate
+	defaultFloor    = 1   // default minimum refill rate
+)
+
+// AdaptiveBucket
+//
+//	adjusts the bucket refill rate by feedback like TCP congestion control (AIMD):
+//	success additively increases the rate, failure multiplicatively decreases it
+//
+//	Fields:
+//
+//	[TokenBucket]   adjusted bucket
+//	[lock]          mutex for atomic rate adjustments
+//
+//	For Options:
+//
+//	[increase] refill rate added on success. default: 1
+//	[decrease] factor refill rate is multiplied by on failure. default: 0.5
+//	[floor] minimum refill rate. default: 1
+//	[ceiling] maximum refill rate. default: initial bucket refill rate
+type AdaptiveBucket struct {
+	*TokenBucket
+	lock sync.Mutex
+
+	increase int
+	decrease float64
+	floor    int
+	ceiling  int
+}
+
+// NewAdaptiveBucket returns new AdaptiveBucket entity instance
+func NewAdaptiveBucket(tb *TokenBucket, options ...AdaptiveOption) *AdaptiveBucket {
+	ab := &AdaptiveBucket{
+		TokenBucket: tb,
+
+		increase: defaultIncrease,
+		decrease: defaultDecrease,
+		floor:    defaultFloor,
+		ceiling:  tb.RefillRate(),
+	}
+
+	for _, opt := range options {
+		opt(ab)
+	}
+
+	return ab
+}
+
+// AdaptiveOption for AdaptiveBucket entity
+type AdaptiveOption func(*AdaptiveBucket)
+
+// SetIncrease set refill rate added on success
+func SetIncrease(step int) AdaptiveOption {
+	return func(ab *AdaptiveBucket) {
+		ab.increase = step
+	}
+}
+
+// SetDecrease set factor in (0, 1) refill rate is multiplied by on failure
+func SetDecrease(factor float64) AdaptiveOption {
+	return func(ab *AdaptiveBucket) {
+		ab.decrease = factor
+	}
+}
+
+// SetFloor set minimum refill rate
+func SetFloor(rate int) AdaptiveOption {
+	return func(ab *AdaptiveBucket) {
+		ab.floor = rate
+	}
+}
+
+// SetCeiling set maximum refill rate
+func SetCeiling(rate int) AdaptiveOption {
+	return func(ab *AdaptiveBucket) {
+		ab.ceiling = rate
+	}
+}
+
+// ReportSuccess increases the refill rate by the increase step up to the ceiling
+func (ab *AdaptiveBucket) ReportSuccess() {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	rate := ab.RefillRate() + ab.increase
+
+	if rate > ab.ceiling {
+		rate = ab.ceiling
+	}
+	ab.SetRefillRate(rate)
+}
+
+// ReportFailure decreases the refill rate by the decrease factor down to the floor
+func (ab *AdaptiveBucket) ReportFailure() {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	rate := int(float64(ab.RefillRate()) * ab.decrease)
+
+	if rate < ab.floor {
+		rate = ab.floor
+	}
+	ab.SetRefillRate(rate)
+}
@@ -0,0 +1,44 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveBucket(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	ab := NewAdaptiveBucket(NewTokenBucket(100, 20, SetClock(clock)), SetIncrease(5), SetFloor(4))
+	defer ab.Close()
+
+	ab.ReportFailure()
+
+	if got := ab.RefillRate(); got != 10 {
+		t.Fatalf("failure must halve the rate: got %d, want 10", got)
+	}
+	ab.ReportFailure()
+	ab.ReportFailure()
+
+	if got := ab.RefillRate(); got != 4 {
+		t.Fatalf("rate must not go under the floor: got %d, want 4", got)
+	}
+	ab.ReportSuccess()
+
+	if got := ab.RefillRate(); got != 9 {
+		t.Fatalf("success must add the increase: got %d, want 9", got)
+	}
+	for i := 0; i < 10; i++ {
+		ab.ReportSuccess()
+	}
+	if got := ab.RefillRate(); got != 20 {
+		t.Fatalf("rate must not go over the initial rate: got %d, want 20", got)
+	}
+	// the adjusted rate refills the bucket
+	ab.AllowN(100)
+	ab.ReportFailure()
+	clock.Advance(time.Second)
+
+	if got := ab.Tokens(); got != 10 {
+		t.Fatalf("got %d tokens, want 10", got)
+	}
+}
@@ -0,0 +1,139 @@
+package token_bucket
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// AtomicTokenBucket
+//
+//	implement the token bucket algorithm without locks.
+//	the state is a single theoretical arrival updated by CAS loop (GCRA),
+//	so tokens are refilled continuously one by one instead of per refill duration.
+//	the arrival is counted in tokens refilled since 'baseT', which is computed exactly
+//	in 128-bit arithmetic, so the rate does not drift when the refill duration is not divisible by the rate
+//
+//	Fields:
+//
+//	[tat]        number of tokens refilled since 'baseT' at which the bucket is full again
+//	[baseT]      time of the bucket creation
+//	[maxTokens]  maximum number of tokens in bucket
+//	[refillRate] number of tokens to be added in bucket per refill duration, zero if the bucket never refills
+//	[refillDur]  bucket refill duration in nanoseconds
+//
+//	For Options:
+//
+//	[tokenN] weight for one request or operation. default: 1
+//	[refillDur] bucket refill duration. default: 1 second
+//	[clock] source of current time. default: wall clock with monotonic reading
+//	[currTokens] initial token number in bucket, see SetInitialTokens. default: 'maxTokens'
+type AtomicTokenBucket struct {
+	tat        atomic.Int64
+	baseT      time.Time
+	maxTokens  int64
+	refillRate uint64
+	refillDur  uint64
+
+	tokenN int
+	clock  Clock
+}
+
+var _ Limiter = (*AtomicTokenBucket)(nil)
+
+// NewAtomicTokenBucket returns new AtomicTokenBucket entity instance.
+// zero 'refillRate' makes the bucket one-time quota of 'maxTokens' as in TokenBucket.
+// the options are applied as to TokenBucket, and only the resulting capacity, refill rate and duration,
+// weight, clock and initial tokens are used, e.g. of SetCapacity, SetRate, SetInitialTokens and SetClock.
+// other options are ignored, the surplus of SetBurst as well
+func NewAtomicTokenBucket(maxTokens, refillRate int, options ...Option) *AtomicTokenBucket {
+	cfg := NewTokenBucket(maxTokens, refillRate, options...)
+	cfg.Close()
+
+	ab := &AtomicTokenBucket{
+		maxTokens: cfg.maxTokens,
+		refillDur: uint64(cfg.refillDur),
+		tokenN:    cfg.Weight(),
+		clock:     cfg.clock,
+	}
+	if cfg.refillRate > 0 {
+		ab.refillRate = uint64(cfg.refillRate)
+	}
+	ab.baseT = ab.clock.Now()
+
+	// the bucket starts with debt of the tokens missing up to 'maxTokens'
+	if missing := ab.maxTokens - cfg.currTokens; missing > 0 {
+		ab.tat.Store(missing)
+	}
+
+	return ab
+}
+
+// nowK returns number of tokens refilled since the bucket creation, floor of elapsed * rate / duration.
+// the product is 128-bit, so it does not overflow for any elapsed time and rate
+func (ab *AtomicTokenBucket) nowK() int64 {
+	if ab.refillRate == 0 {
+		return 0
+	}
+	elapsed := ab.clock.Now().Sub(ab.baseT)
+
+	if elapsed <= 0 {
+		return 0
+	}
+	hi, lo := bits.Mul64(uint64(elapsed), ab.refillRate)
+
+	if hi >= ab.refillDur {
+		return math.MaxInt64
+	}
+	k, _ := bits.Div64(hi, lo, ab.refillDur)
+
+	if k > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(k)
+}
+
+// AllowN return 'true' if there are 'n' tokens in the bucket.
+// zero 'n' is allowed and negative 'n' is denied.
+// concurrent callers never consume more tokens than available
+func (ab *AtomicTokenBucket) AllowN(n int) bool {
+	if n <= 0 {
+		return n == 0
+	}
+	if int64(n) > ab.maxTokens {
+		return false
+	}
+	for {
+		tat := ab.tat.Load()
+		nowK := ab.nowK()
+
+		next := tat
+		if next < nowK {
+			next = nowK
+		}
+		// compared without adding 'n', so the arrival can not overflow
+		if int64(n) > ab.maxTokens-(next-nowK) {
+			return false
+		}
+		if ab.tat.CompareAndSwap(tat, next+int64(n)) {
+			return true
+		}
+	}
+}
+
+// Allow returns 'true' if there are enough tokens in the bucket
+func (ab *AtomicTokenBucket) Allow() bool {
+	return ab.AllowN(ab.tokenN)
+}
+
+// Tokens returns current token number in the bucket
+func (ab *AtomicTokenBucket) Tokens() int {
+	nowK := ab.nowK()
+	tat := ab.tat.Load()
+
+	if tat < nowK {
+		tat = nowK
+	}
+	return int(ab.maxTokens - (tat - nowK))
+}
@@ -0,0 +1,139 @@
+package token_bucket
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAtomicTokenBucketExactRate(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	// one token per 3.33ns, truncated emission of 3ns would allow 100 tokens in 300ns instead of 90
+	ab := NewAtomicTokenBucket(1, 3, SetClock(clock), SetRefillDuration(10*time.Nanosecond))
+	ab.Allow()
+
+	allowed := 0
+
+	for i := 0; i < 300; i++ {
+		clock.Advance(time.Nanosecond)
+
+		if ab.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 90 {
+		t.Fatalf("got %d allowed, want 90", allowed)
+	}
+}
+
+func TestAtomicTokenBucketInitialTokens(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	ab := NewAtomicTokenBucket(5, 1, SetClock(clock), SetInitialTokens(2))
+
+	if got := ab.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+	if !ab.AllowN(2) || ab.Allow() {
+		t.Fatal("only the initial tokens must be available")
+	}
+	clock.Advance(3 * time.Second)
+
+	if got := ab.Tokens(); got != 3 {
+		t.Fatalf("got %d tokens after refill, want 3", got)
+	}
+}
+
+func TestAtomicTokenBucketCapacityOptions(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	ab := NewAtomicTokenBucket(5, 1, SetClock(clock), SetCapacity(8), SetRate(4, time.Second, false))
+
+	if got := ab.Tokens(); got != 8 {
+		t.Fatalf("got %d tokens, want the capacity 8 of SetCapacity", got)
+	}
+	if !ab.AllowN(8) || ab.Allow() {
+		t.Fatal("exactly the 8 tokens of SetCapacity must be available")
+	}
+	clock.Advance(time.Second)
+
+	if got := ab.Tokens(); got != 4 {
+		t.Fatalf("got %d tokens after refill, want the rate 4 of SetRate", got)
+	}
+}
+
+func TestAtomicTokenBucketQuota(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	ab := NewAtomicTokenBucket(2, 0, SetClock(clock))
+
+	if !ab.Allow() || !ab.Allow() || ab.Allow() {
+		t.Fatal("quota of two tokens must be allowed once")
+	}
+	clock.Advance(time.Hour)
+
+	if ab.Allow() {
+		t.Fatal("quota must never be refilled")
+	}
+}
+
+func TestAtomicTokenBucketInvalidN(t *testing.T) {
+	ab := NewAtomicTokenBucket(2, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+
+	if ab.AllowN(-1) || ab.AllowN(3) || !ab.AllowN(0) {
+		t.Fatal("negative and oversize requests must be denied, zero allowed")
+	}
+	if got := ab.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+}
+
+func TestAtomicTokenBucketConcurrent(t *testing.T) {
+	ab := NewAtomicTokenBucket(1000, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int64
+	)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 500; i++ {
+				if ab.Allow() {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 1000 {
+		t.Fatalf("got %d allowed, want exactly the capacity", got)
+	}
+}
+
+func BenchmarkAtomicTokenBucket(b *testing.B) {
+	b.Run("atomic", func(b *testing.B) {
+		ab := NewAtomicTokenBucket(1<<30, 1<<30)
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ab.Allow()
+			}
+		})
+	})
+	b.Run("locked", func(b *testing.B) {
+		tb := NewTokenBucket(1<<30, 1<<30)
+		defer tb.Close()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				tb.Allow()
+			}
+		})
+	})
+}
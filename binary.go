@@ -0,0 +1,69 @@
+package token_bucket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	binaryVersion = 1  // version of the binary layout
+	binaryLen     = 58 // length of the binary layout in bytes
+)
+
+// ErrInvalidBinary returned when the binary state of the bucket is truncated or has unknown version
+var ErrInvalidBinary = errors.New("token_bucket: invalid binary state")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+// the layout is fixed: version and flags bytes followed by big-endian max tokens, refill rate,
+// current tokens, fractional tokens, last filling time in unix nanoseconds, refill duration and token weight
+func (tb *TokenBucket) MarshalBinary() ([]byte, error) {
+	return encodeBinary(tb.Snapshot()), nil
+}
+
+// encodeBinary returns binary layout of the snapshot
+func encodeBinary(s Snapshot) []byte {
+	data := make([]byte, binaryLen)
+	data[0] = binaryVersion
+
+	if s.Continuous {
+		data[1] = 1
+	}
+	be := binary.BigEndian
+
+	be.PutUint64(data[2:], uint64(s.MaxTokens))
+	be.PutUint64(data[10:], uint64(s.RefillRate))
+	be.PutUint64(data[18:], uint64(s.CurrTokens))
+	be.PutUint64(data[26:], math.Float64bits(s.PartTokens))
+	be.PutUint64(data[34:], uint64(s.LastFillT.UnixNano()))
+	be.PutUint64(data[42:], uint64(s.RefillDur))
+	be.PutUint64(data[50:], uint64(s.TokenN))
+
+	return data
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// returns ErrInvalidBinary if the data is truncated or has unknown version
+// and ErrInvalidSnapshot if the state has out of range fields, the bucket is not changed then
+func (tb *TokenBucket) UnmarshalBinary(data []byte) error {
+	if len(data) != binaryLen {
+		return fmt.Errorf("%w: length %d, expected %d", ErrInvalidBinary, len(data), binaryLen)
+	}
+	if data[0] != binaryVersion {
+		return fmt.Errorf("%w: version %d", ErrInvalidBinary, data[0])
+	}
+	be := binary.BigEndian
+
+	return tb.apply(Snapshot{
+		MaxTokens:  int(int64(be.Uint64(data[2:]))),
+		RefillRate: int(int64(be.Uint64(data[10:]))),
+		CurrTokens: int(int64(be.Uint64(data[18:]))),
+		PartTokens: math.Float64frombits(be.Uint64(data[26:])),
+		LastFillT:  time.Unix(0, int64(be.Uint64(data[34:]))),
+		RefillDur:  time.Duration(be.Uint64(data[42:])),
+		TokenN:     int(int64(be.Uint64(data[50:]))),
+		Continuous: data[1]&1 != 0,
+	})
+}
@@ -0,0 +1,68 @@
+package token_bucket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalBinaryRoundTrip(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 2, SetClock(clock), SetContinuousRefill(true))
+	defer tb.Close()
+
+	tb.AllowN(7)
+
+	data, err := tb.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	restored := NewTokenBucket(1, 1, SetClock(clock))
+	defer restored.Close()
+
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if restored.Snapshot() != tb.Snapshot() {
+		t.Fatalf("got snapshot %+v, want %+v", restored.Snapshot(), tb.Snapshot())
+	}
+}
+
+func TestUnmarshalBinaryDebtAndSurplus(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	for name, tb := range debtAndSurplusBuckets(clock) {
+		data, err := tb.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", name, err)
+		}
+		restored := NewTokenBucket(1, 1, SetClock(clock))
+
+		if err := restored.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: unmarshal own state: %v", name, err)
+		}
+		if !EqualState(restored, tb) {
+			t.Fatalf("%s: got snapshot %+v, want %+v", name, restored.Snapshot(), tb.Snapshot())
+		}
+		restored.Close()
+		tb.Close()
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	tb := NewTokenBucket(3, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	if err := tb.UnmarshalBinary(make([]byte, binaryLen-1)); !errors.Is(err, ErrInvalidBinary) {
+		t.Fatalf("truncated data: got error %v, want ErrInvalidBinary", err)
+	}
+	for name, s := range invalidSnapshots() {
+		if err := tb.UnmarshalBinary(encodeBinary(s)); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Fatalf("%s: got error %v, want ErrInvalidSnapshot", name, err)
+		}
+		if tb.Capacity() != 3 || tb.Tokens() != 3 {
+			t.Fatalf("%s: bucket must not be changed by invalid state", name)
+		}
+	}
+}
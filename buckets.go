@@ -0,0 +1,27 @@
+package token_bucket
+
+// NewBuckets returns 'n' independent buckets with the same parameters and options,
+// e.g. one bucket per worker or shard
+func NewBuckets(n, maxTokens, refillRate int, options ...Option) []*TokenBucket {
+	buckets := make([]*TokenBucket, n)
+
+	for i := range buckets {
+		buckets[i] = NewTokenBucket(maxTokens, refillRate, options...)
+	}
+
+	return buckets
+}
+
+// NewBucketsStaggered works as NewBuckets but the buckets start with staggered tokens
+// to avoid synchronized bursts: bucket 'i' starts with maxTokens*(n-i)/n tokens,
+// so the first bucket is full and the last one has 1/n of 'maxTokens'
+func NewBucketsStaggered(n, maxTokens, refillRate int, options ...Option) []*TokenBucket {
+	buckets := make([]*TokenBucket, n)
+
+	for i := range buckets {
+		initial := SetInitialTokens(int(int64(maxTokens) * int64(n-i) / int64(n)))
+		buckets[i] = NewTokenBucket(maxTokens, refillRate, append(options[:len(options):len(options)], initial)...)
+	}
+
+	return buckets
+}
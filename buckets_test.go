@@ -0,0 +1,47 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBuckets(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	buckets := NewBuckets(3, 2, 1, SetClock(clock))
+
+	if len(buckets) != 3 {
+		t.Fatalf("got %d buckets, want 3", len(buckets))
+	}
+	buckets[0].AllowN(2)
+
+	// the buckets are independent
+	for i, tb := range buckets[1:] {
+		if got := tb.Tokens(); got != 2 {
+			t.Fatalf("bucket %d: got %d tokens, want 2", i+1, got)
+		}
+	}
+	for _, tb := range buckets {
+		tb.Close()
+	}
+}
+
+func TestNewBucketsStaggered(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	options := make([]Option, 1, 4)
+	options[0] = SetClock(clock)
+
+	buckets := NewBucketsStaggered(4, 8, 1, options...)
+
+	for i, want := range []int{8, 6, 4, 2} {
+		if got := buckets[i].Tokens(); got != want {
+			t.Fatalf("bucket %d: got %d tokens, want %d", i, got, want)
+		}
+		buckets[i].Close()
+	}
+	// the staggered option is not appended in the spare capacity of the caller options
+	if options[:2][1] != nil {
+		t.Fatal("staggered option leaked to the caller options")
+	}
+}
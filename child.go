@@ -0,0 +1,62 @@
+package token_bucket
+
+// ChildBucket
+//
+//	bucket capped both by its own limit and by the limit shared with other children of the parent,
+//	e.g. every tenant is limited to 10 per second and all tenants together to 100 per second
+//
+//	Fields:
+//
+//	[own]       bucket of the child limit
+//	[parent]    bucket of the shared limit
+//	[buckets]   own and parent buckets in order of consuming
+//	[ordered]   unique buckets ordered by address to lock without deadlocks
+type ChildBucket struct {
+	own     *TokenBucket
+	parent  *TokenBucket
+	buckets []*TokenBucket
+	ordered []*TokenBucket
+}
+
+var _ Limiter = (*ChildBucket)(nil)
+
+// NewChildBucket returns new ChildBucket entity instance with own bucket
+// created by NewTokenBucket and consuming from the parent bucket as well
+func NewChildBucket(parent *TokenBucket, maxTokens, refillRate int, options ...Option) *ChildBucket {
+	own := NewTokenBucket(maxTokens, refillRate, options...)
+	buckets := []*TokenBucket{own, parent}
+
+	return &ChildBucket{
+		own:     own,
+		parent:  parent,
+		buckets: buckets,
+		ordered: lockOrder(buckets),
+	}
+}
+
+// AllowN return 'true' if there are 'n' tokens both in the child and in the parent bucket.
+// tokens are consumed from both buckets atomically or not consumed at all, negative 'n' is always denied
+func (cb *ChildBucket) AllowN(n int) bool {
+	if n < 0 {
+		return false
+	}
+	lockAll(cb.ordered)
+	defer unlockAll(cb.ordered)
+
+	return allowAll(cb.buckets, n) < 0
+}
+
+// Allow returns 'true' if there are tokens for weight of one request of the child in both buckets
+func (cb *ChildBucket) Allow() bool {
+	return cb.AllowN(cb.own.Weight())
+}
+
+// Bucket returns bucket of the child limit
+func (cb *ChildBucket) Bucket() *TokenBucket {
+	return cb.own
+}
+
+// Parent returns bucket of the shared limit
+func (cb *ChildBucket) Parent() *TokenBucket {
+	return cb.parent
+}
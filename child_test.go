@@ -0,0 +1,38 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChildBucket(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	parent := NewTokenBucket(5, 5, SetClock(clock))
+	defer parent.Close()
+
+	a := NewChildBucket(parent, 3, 3, SetClock(clock))
+	defer a.Bucket().Close()
+
+	b := NewChildBucket(parent, 3, 3, SetClock(clock))
+	defer b.Bucket().Close()
+
+	if !a.AllowN(3) || a.Allow() {
+		t.Fatal("child must be capped by its own limit")
+	}
+	if !b.AllowN(2) || b.Allow() {
+		t.Fatal("children together must be capped by the parent limit")
+	}
+	// the denied request did not consume the child tokens
+	if got := b.Bucket().Tokens(); got != 1 {
+		t.Fatalf("got %d child tokens, want 1", got)
+	}
+	if a.Parent() != parent || b.Parent() != parent {
+		t.Fatal("children must share the parent")
+	}
+	clock.Advance(time.Second)
+
+	if !b.AllowN(3) {
+		t.Fatal("refilled child denied")
+	}
+}
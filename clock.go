@@ -0,0 +1,77 @@
+package token_bucket
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides current time for TokenBucket.
+// only Now is required, so existing clocks of other packages, e.g. clockwork.Clock, are accepted without adapter
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock returns current wall clock time
+type realClock struct{}
+
+// Now returns current time with monotonic clock reading
+func (realClock) Now() time.Time {
+	return nowT()
+}
+
+// TestClock
+//
+//	clock which time is moved only manually, for tests of the package and of its users.
+//	safe for concurrent use
+//
+//	Fields:
+//
+//	[t]      current clock time
+//	[lock]   mutex for atomic operations
+type TestClock struct {
+	t    time.Time
+	lock sync.Mutex
+}
+
+// NewTestClock returns new TestClock entity instance set to 't'
+func NewTestClock(t time.Time) *TestClock {
+	return &TestClock{
+		t: t,
+	}
+}
+
+// Now returns current clock time
+func (c *TestClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.t
+}
+
+// Advance moves the clock forward by 'd'
+func (c *TestClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.t = c.t.Add(d)
+}
+
+// Set moves the clock to 't', backward moves are allowed to test clock steps
+func (c *TestClock) Set(t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.t = t
+}
+
+// ManualClock is the former name of TestClock.
+//
+// Deprecated: use TestClock
+type ManualClock = TestClock
+
+// NewManualClock returns new TestClock entity instance set to 't'.
+//
+// Deprecated: use NewTestClock
+func NewManualClock(t time.Time) *ManualClock {
+	return NewTestClock(t)
+}
@@ -0,0 +1,133 @@
+package token_bucket
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// foreignClock mimics clock of another package, e.g. clockwork.FakeClock, with more methods than Now
+type foreignClock struct {
+	t time.Time
+}
+
+func (c *foreignClock) Now() time.Time                         { return c.t }
+func (c *foreignClock) Since(t time.Time) time.Duration        { return c.t.Sub(t) }
+func (c *foreignClock) Advance(d time.Duration)                { c.t = c.t.Add(d) }
+func (c *foreignClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func TestSetClockForeignClock(t *testing.T) {
+	clock := &foreignClock{t: time.Unix(0, 0)}
+	tb := NewTokenBucket(1, 1, SetClock(clock))
+
+	if !tb.Allow() || tb.Allow() {
+		t.Fatal("full bucket of one token")
+	}
+	clock.Advance(time.Second)
+
+	if !tb.Allow() {
+		t.Fatal("bucket not refilled by the foreign clock")
+	}
+}
+
+func TestTestClock(t *testing.T) {
+	startT := time.Unix(100, 0)
+	clock := NewTestClock(startT)
+
+	clock.Advance(time.Second)
+
+	if got := clock.Now(); !got.Equal(startT.Add(time.Second)) {
+		t.Fatalf("advanced clock: %s", got)
+	}
+	clock.Set(startT)
+
+	if got := clock.Now(); !got.Equal(startT) {
+		t.Fatalf("set clock: %s", got)
+	}
+}
+
+func TestTestClockConcurrent(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(100, 1, SetClock(clock))
+	defer tb.Close()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				clock.Advance(time.Millisecond)
+				tb.Allow()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := clock.Now(); !got.Equal(time.Unix(0, 0).Add(400 * time.Millisecond)) {
+		t.Fatalf("got clock %s, want 400ms advanced", got)
+	}
+}
+
+func TestRefillClockBackward(t *testing.T) {
+	clock := NewTestClock(time.Unix(100, 0))
+	tb := NewTokenBucket(2, 1, SetClock(clock))
+	tb.AllowN(2)
+
+	clock.Set(time.Unix(50, 0))
+
+	if tb.Allow() {
+		t.Fatal("clock going backward credited tokens")
+	}
+	clock.Advance(time.Second)
+
+	if !tb.Allow() {
+		t.Fatal("refill does not continue after the clock went backward")
+	}
+}
+
+func TestRealClockMonotonic(t *testing.T) {
+	// the monotonic reading is printed as "m=+..." and is stripped by UTC or Round(0)
+	if got := (realClock{}).Now().String(); !strings.Contains(got, "m=") {
+		t.Fatalf("real clock reading has no monotonic part: %s", got)
+	}
+	tb := NewTokenBucket(1, 1)
+	defer tb.Close()
+
+	tb.lock.Lock()
+	lastFillT := tb.lastFillT
+	tb.unlock()
+
+	if !strings.Contains(lastFillT.String(), "m=") {
+		t.Fatalf("bucket filling time has no monotonic part: %s", lastFillT)
+	}
+}
+
+func TestSetClockReservation(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(time.Minute))
+	defer tb.Close()
+
+	tb.Allow()
+	r := tb.Reserve()
+
+	if !r.OK() || r.Delay() != time.Minute {
+		t.Fatalf("got reservation delay %s, want 1m by the injected clock", r.Delay())
+	}
+	clock.Advance(40 * time.Second)
+
+	if got := r.Delay(); got != 20*time.Second {
+		t.Fatalf("got delay %s after the clock advance, want 20s", got)
+	}
+	clock.Advance(20 * time.Second)
+
+	if got := r.Delay(); got != 0 {
+		t.Fatalf("got delay %s at the refill, want 0", got)
+	}
+}
@@ -0,0 +1,57 @@
+package token_bucket
+
+// Clone returns new bucket with the same configuration and current state.
+// the clone is fully independent after creation, counters of the clone start from zero
+func (tb *TokenBucket) Clone() *TokenBucket {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	clone := &TokenBucket{
+		refillRate: tb.refillRate,
+		maxTokens:  tb.maxTokens,
+		currTokens: tb.currTokens,
+		lastFillT:  tb.lastFillT,
+		refillT:    tb.refillT,
+		partTokens: tb.partTokens,
+		paused:     tb.paused,
+		pausedT:    tb.pausedT,
+		draining:   tb.draining,
+		early:      tb.early,
+		earlyN:     tb.earlyN,
+		done:       make(chan struct{}),
+
+		refillDur:    tb.refillDur,
+		clock:        tb.clock,
+		continuous:   tb.continuous,
+		background:   tb.background,
+		maxJitter:    tb.maxJitter,
+		onThrottle:   tb.onThrottle,
+		onRefill:     tb.onRefill,
+		fifo:         tb.fifo,
+		maxWait:      tb.maxWait,
+		name:         tb.name,
+		logger:       tb.logger,
+		costFn:       tb.costFn,
+		warmup:       tb.warmup,
+		warmupT:      tb.warmupT,
+		strictFloor:  tb.strictFloor,
+		reserve:      tb.reserve,
+		rateWindow:   tb.rateWindow,
+		reserveGrace: tb.reserveGrace,
+		dryRun:       tb.dryRun,
+		traceHook:    tb.traceHook,
+		oversize:     tb.oversize,
+		softLimit:    tb.softLimit,
+		onSoftLimit:  tb.onSoftLimit,
+		minInterval:  tb.minInterval,
+		firstProp:    tb.firstProp,
+		lateStats:    tb.lateStats,
+	}
+	clone.tokenN.Store(tb.tokenN.Load())
+
+	if clone.background {
+		go clone.refiller()
+	}
+
+	return clone
+}
@@ -0,0 +1,39 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClone(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 2, SetRefillDuration(time.Minute), SetName("api"), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(3)
+	tb.Allow()
+
+	clone := tb.Clone()
+	defer clone.Close()
+
+	if !EqualState(tb, clone) {
+		t.Fatalf("clone state differs: %s and %s", tb, clone)
+	}
+	if clone.Name() != "api" || clone.RefillDuration() != time.Minute {
+		t.Fatalf("clone configuration differs: %s", clone)
+	}
+	if s := clone.Stats(); s.Allowed != 0 || s.Denied != 0 {
+		t.Fatalf("clone counters must start from zero: got %+v", s)
+	}
+	clone.AllowN(1)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("clone consumption changed the original: got %d tokens, want 1", got)
+	}
+	clock.Advance(time.Minute)
+
+	if got := clone.Tokens(); got != 2 {
+		t.Fatalf("clone must refill with the same clock: got %d tokens, want 2", got)
+	}
+}
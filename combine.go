@@ -0,0 +1,29 @@
+package token_bucket
+
+// Combine moves current tokens of 'src' into 'dst' and drains 'src' to zero.
+// 'dst' is not filled over its 'maxTokens', tokens over it are dropped.
+// both buckets are locked in order of their addresses, so concurrent Combine
+// of the same buckets in opposite directions does not deadlock.
+// returns number of tokens added to 'dst'
+func Combine(dst, src *TokenBucket) int {
+	if dst == src {
+		return 0
+	}
+	ordered := lockOrder([]*TokenBucket{dst, src})
+
+	lockAll(ordered)
+	defer unlockAll(ordered)
+
+	dst.refill()
+	src.refill()
+
+	if src.currTokens <= 0 {
+		return 0
+	}
+	prevTokens := dst.currTokens
+
+	dst.currTokens = fill(dst.currTokens, src.currTokens, dst.maxTokens)
+	src.currTokens = 0
+
+	return int(dst.currTokens - prevTokens)
+}
@@ -0,0 +1,50 @@
+package token_bucket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCombine(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	dst := NewTokenBucket(10, 1, SetInitialTokens(6), SetClock(clock))
+	defer dst.Close()
+
+	src := NewTokenBucket(10, 1, SetInitialTokens(7), SetClock(clock))
+	defer src.Close()
+
+	if got := Combine(dst, src); got != 4 {
+		t.Fatalf("got %d added tokens, want 4 up to max tokens", got)
+	}
+	if dst.Tokens() != 10 || src.Tokens() != 0 {
+		t.Fatalf("got %d and %d tokens, want 10 and 0", dst.Tokens(), src.Tokens())
+	}
+	if got := Combine(dst, dst); got != 0 || dst.Tokens() != 10 {
+		t.Fatalf("combining the bucket with itself: got %d added", got)
+	}
+}
+
+func TestCombineOppositeDirections(t *testing.T) {
+	a := NewTokenBucket(10, 1)
+	defer a.Close()
+
+	b := NewTokenBucket(10, 1)
+	defer b.Close()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			Combine(a, b)
+		}()
+		go func() {
+			defer wg.Done()
+			Combine(b, a)
+		}()
+	}
+	wg.Wait()
+}
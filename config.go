@@ -0,0 +1,33 @@
+package token_bucket
+
+import "time"
+
+// Config
+//
+//	effective configuration of the bucket
+//
+//	Fields:
+//
+//	[MaxTokens]    maximum number of tokens in bucket
+//	[RefillRate]   number of tokens to be added in bucket per refill duration
+//	[RefillDur]    bucket refill duration
+//	[TokenN]       weight for one request or operation
+type Config struct {
+	MaxTokens  int
+	RefillRate int
+	RefillDur  time.Duration
+	TokenN     int
+}
+
+// Config returns copy of the bucket configuration
+func (tb *TokenBucket) Config() Config {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return Config{
+		MaxTokens:  int(tb.maxTokens),
+		RefillRate: int(tb.refillRate),
+		RefillDur:  tb.refillDur,
+		TokenN:     tb.Weight(),
+	}
+}
@@ -0,0 +1,28 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	tb := NewTokenBucket(10, 5, SetRefillDuration(time.Minute), SetTokenN(2))
+	defer tb.Close()
+
+	want := Config{MaxTokens: 10, RefillRate: 5, RefillDur: time.Minute, TokenN: 2}
+
+	if got := tb.Config(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	cfg := tb.Config()
+	cfg.MaxTokens = 100
+
+	if got := tb.Capacity(); got != 10 {
+		t.Fatalf("config must be a copy: got capacity %d", got)
+	}
+	tb.SetRefillRate(7)
+
+	if got := tb.Config().RefillRate; got != 7 {
+		t.Fatalf("config must reflect runtime changes: got refill rate %d", got)
+	}
+}
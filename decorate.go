@@ -0,0 +1,254 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrWaitUnsupported returned by Wait of decorator wrapping Limiter which is not WaitLimiter
+var ErrWaitUnsupported = errors.New("token_bucket: wrapped limiter does not support wait")
+
+// forwardWaitN forwards WaitN to the limiter if it is WaitLimiter
+func forwardWaitN(ctx context.Context, l Limiter, n int) error {
+	wl, ok := l.(WaitLimiter)
+	if !ok {
+		return ErrWaitUnsupported
+	}
+	return wl.WaitN(ctx, n)
+}
+
+// forwardWait forwards Wait to the limiter if it is WaitLimiter
+func forwardWait(ctx context.Context, l Limiter) error {
+	wl, ok := l.(WaitLimiter)
+	if !ok {
+		return ErrWaitUnsupported
+	}
+	return wl.Wait(ctx)
+}
+
+// MetricsLimiter
+//
+//	decorator counting decisions of the wrapped limiter
+//
+//	Fields:
+//
+//	[l]         wrapped limiter
+//	[allowed]   number of allowed requests or operations
+//	[denied]    number of denied requests or operations and failed waits
+type MetricsLimiter struct {
+	l       Limiter
+	allowed atomic.Int64
+	denied  atomic.Int64
+}
+
+var _ WaitLimiter = (*MetricsLimiter)(nil)
+
+// WithMetrics returns decorator counting decisions of the limiter
+func WithMetrics(l Limiter) *MetricsLimiter {
+	return &MetricsLimiter{
+		l: l,
+	}
+}
+
+// count counts the decision
+func (ml *MetricsLimiter) count(ok bool) bool {
+	if ok {
+		ml.allowed.Add(1)
+	} else {
+		ml.denied.Add(1)
+	}
+	return ok
+}
+
+// Allow forwards Allow to the wrapped limiter and counts the decision
+func (ml *MetricsLimiter) Allow() bool {
+	return ml.count(ml.l.Allow())
+}
+
+// AllowN forwards AllowN to the wrapped limiter and counts the decision
+func (ml *MetricsLimiter) AllowN(n int) bool {
+	return ml.count(ml.l.AllowN(n))
+}
+
+// Wait forwards Wait to the wrapped limiter and counts the result
+func (ml *MetricsLimiter) Wait(ctx context.Context) error {
+	err := forwardWait(ctx, ml.l)
+	ml.count(err == nil)
+
+	return err
+}
+
+// WaitN forwards WaitN to the wrapped limiter and counts the result
+func (ml *MetricsLimiter) WaitN(ctx context.Context, n int) error {
+	err := forwardWaitN(ctx, ml.l, n)
+	ml.count(err == nil)
+
+	return err
+}
+
+// Allowed returns number of allowed requests or operations
+func (ml *MetricsLimiter) Allowed() int64 {
+	return ml.allowed.Load()
+}
+
+// Denied returns number of denied requests or operations and failed waits
+func (ml *MetricsLimiter) Denied() int64 {
+	return ml.denied.Load()
+}
+
+// Unwrap returns the wrapped limiter
+func (ml *MetricsLimiter) Unwrap() Limiter {
+	return ml.l
+}
+
+// LoggingLimiter
+//
+//	decorator logging denials of the wrapped limiter
+//
+//	Fields:
+//
+//	[l]        wrapped limiter
+//	[logger]   receives "deny" events with requested tokens and "wait" events with the wait error
+type LoggingLimiter struct {
+	l      Limiter
+	logger Logger
+}
+
+var _ WaitLimiter = (*LoggingLimiter)(nil)
+
+// WithLogging returns decorator logging denials of the limiter
+func WithLogging(l Limiter, logger Logger) *LoggingLimiter {
+	return &LoggingLimiter{
+		l:      l,
+		logger: logger,
+	}
+}
+
+// Allow forwards Allow to the wrapped limiter and logs the denial
+func (ll *LoggingLimiter) Allow() bool {
+	ok := ll.l.Allow()
+
+	if !ok {
+		ll.logger.Log("deny", map[string]any{})
+	}
+	return ok
+}
+
+// AllowN forwards AllowN to the wrapped limiter and logs the denial
+func (ll *LoggingLimiter) AllowN(n int) bool {
+	ok := ll.l.AllowN(n)
+
+	if !ok {
+		ll.logger.Log("deny", map[string]any{"requested": n})
+	}
+	return ok
+}
+
+// Wait forwards Wait to the wrapped limiter and logs the failed wait
+func (ll *LoggingLimiter) Wait(ctx context.Context) error {
+	err := forwardWait(ctx, ll.l)
+
+	if err != nil {
+		ll.logger.Log("wait", map[string]any{"error": err})
+	}
+	return err
+}
+
+// WaitN forwards WaitN to the wrapped limiter and logs the failed wait
+func (ll *LoggingLimiter) WaitN(ctx context.Context, n int) error {
+	err := forwardWaitN(ctx, ll.l, n)
+
+	if err != nil {
+		ll.logger.Log("wait", map[string]any{"error": err, "requested": n})
+	}
+	return err
+}
+
+// Unwrap returns the wrapped limiter
+func (ll *LoggingLimiter) Unwrap() Limiter {
+	return ll.l
+}
+
+// AdaptiveLimiter
+//
+//	decorator adjusting refill rate of the bucket at the bottom of the decorator chain
+//	by feedback, see AdaptiveBucket
+//
+//	Fields:
+//
+//	[l]          wrapped limiter
+//	[adaptive]   adjusts the bucket rate, nil if the chain has no TokenBucket
+type AdaptiveLimiter struct {
+	l        Limiter
+	adaptive *AdaptiveBucket
+}
+
+var _ WaitLimiter = (*AdaptiveLimiter)(nil)
+
+// WithAdaptive returns decorator adjusting refill rate of the TokenBucket found by unwrapping the limiter.
+// the feedback is ignored if the limiter does not wrap TokenBucket
+func WithAdaptive(l Limiter, options ...AdaptiveOption) *AdaptiveLimiter {
+	al := &AdaptiveLimiter{
+		l: l,
+	}
+	if tb := unwrapBucket(l); tb != nil {
+		al.adaptive = NewAdaptiveBucket(tb, options...)
+	}
+	return al
+}
+
+// unwrapBucket returns TokenBucket at the bottom of the decorator chain or nil
+func unwrapBucket(l Limiter) *TokenBucket {
+	for {
+		switch v := l.(type) {
+		case *TokenBucket:
+			return v
+		case *AdaptiveBucket:
+			return v.TokenBucket
+		case interface{ Unwrap() Limiter }:
+			l = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// Allow forwards Allow to the wrapped limiter
+func (al *AdaptiveLimiter) Allow() bool {
+	return al.l.Allow()
+}
+
+// AllowN forwards AllowN to the wrapped limiter
+func (al *AdaptiveLimiter) AllowN(n int) bool {
+	return al.l.AllowN(n)
+}
+
+// Wait forwards Wait to the wrapped limiter
+func (al *AdaptiveLimiter) Wait(ctx context.Context) error {
+	return forwardWait(ctx, al.l)
+}
+
+// WaitN forwards WaitN to the wrapped limiter
+func (al *AdaptiveLimiter) WaitN(ctx context.Context, n int) error {
+	return forwardWaitN(ctx, al.l, n)
+}
+
+// ReportSuccess increases the bucket refill rate, see AdaptiveBucket.ReportSuccess
+func (al *AdaptiveLimiter) ReportSuccess() {
+	if al.adaptive != nil {
+		al.adaptive.ReportSuccess()
+	}
+}
+
+// ReportFailure decreases the bucket refill rate, see AdaptiveBucket.ReportFailure
+func (al *AdaptiveLimiter) ReportFailure() {
+	if al.adaptive != nil {
+		al.adaptive.ReportFailure()
+	}
+}
+
+// Unwrap returns the wrapped limiter
+func (al *AdaptiveLimiter) Unwrap() Limiter {
+	return al.l
+}
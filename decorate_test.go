@@ -0,0 +1,78 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// allowOnly is Limiter which does not support waiting
+type allowOnly struct{}
+
+func (allowOnly) Allow() bool     { return true }
+func (allowOnly) AllowN(int) bool { return true }
+
+func TestDecorators(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 4, SetClock(clock), SetRefillDuration(time.Hour))
+	defer tb.Close()
+
+	var events []string
+
+	ml := WithMetrics(tb)
+	al := WithAdaptive(WithLogging(ml, LoggerFunc(func(name string, fields map[string]any) {
+		events = append(events, name)
+	})))
+
+	al.Allow()
+	al.AllowN(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := al.Wait(ctx); err == nil {
+		t.Fatal("wait with canceled context must fail")
+	}
+	if err := al.WaitN(context.Background(), 1); err != nil {
+		t.Fatalf("wait for the last token: %v", err)
+	}
+	if ml.Allowed() != 2 || ml.Denied() != 2 {
+		t.Fatalf("got %d allowed and %d denied, want 2 and 2", ml.Allowed(), ml.Denied())
+	}
+	if len(events) != 2 || events[0] != "deny" || events[1] != "wait" {
+		t.Fatalf("got events %v, want deny and wait", events)
+	}
+	// the adaptive decorator adjusts the bucket at the bottom of the chain
+	al.ReportFailure()
+
+	if got := tb.RefillRate(); got != 2 {
+		t.Fatalf("got refill rate %d, want halved 2", got)
+	}
+	al.ReportSuccess()
+
+	if got := tb.RefillRate(); got != 3 {
+		t.Fatalf("got refill rate %d, want 3", got)
+	}
+}
+
+func TestDecoratorsWaitUnsupported(t *testing.T) {
+	ml := WithMetrics(allowOnly{})
+
+	if err := ml.Wait(context.Background()); !errors.Is(err, ErrWaitUnsupported) {
+		t.Fatalf("got error %v, want ErrWaitUnsupported", err)
+	}
+	if err := WithLogging(ml, LoggerFunc(func(string, map[string]any) {})).WaitN(context.Background(), 1); !errors.Is(err, ErrWaitUnsupported) {
+		t.Fatalf("got error %v, want ErrWaitUnsupported forwarded by the chain", err)
+	}
+	al := WithAdaptive(ml)
+
+	// the chain has no bucket, so the feedback is ignored
+	al.ReportFailure()
+	al.ReportSuccess()
+
+	if !al.Allow() || ml.Denied() != 2 {
+		t.Fatalf("got %d denied, want 2 failed waits", ml.Denied())
+	}
+}
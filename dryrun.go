@@ -0,0 +1,27 @@
+package token_bucket
+
+// SetDryRun set dry run mode for rolling out a new limit: AllowN and Allow always allow
+// and still consume tokens, requests the bucket would deny are counted in Stats.WouldDeny.
+// tokens go negative under saturation down to minus 'maxTokens', so the bucket denies for a while after the dry run is turned off.
+// paused and draining buckets deny as usual
+func SetDryRun(dryRun bool) Option {
+	return func(tb *TokenBucket) {
+		tb.dryRun = dryRun
+	}
+}
+
+// SetDryRunNow turns the dry run mode on or off at runtime, see SetDryRun
+func (tb *TokenBucket) SetDryRunNow(dryRun bool) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.dryRun = dryRun
+}
+
+// DryRun returns 'true' if the bucket is in the dry run mode
+func (tb *TokenBucket) DryRun() bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.dryRun
+}
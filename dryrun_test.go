@@ -0,0 +1,48 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetDryRun(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock), SetDryRun(true))
+	defer tb.Close()
+
+	for i := 0; i < 5; i++ {
+		if !tb.Allow() {
+			t.Fatalf("request %d denied in the dry run", i)
+		}
+	}
+	if s := tb.Stats(); s.Allowed != 5 || s.Denied != 0 || s.WouldDeny != 3 {
+		t.Fatalf("got stats %+v, want 5 allowed and 3 would deny", s)
+	}
+	// the debt is bounded by max tokens
+	if got := storedTokens(tb); got != -2 {
+		t.Fatalf("got %d tokens, want -2", got)
+	}
+	tb.SetDryRunNow(false)
+
+	if tb.DryRun() || tb.Allow() {
+		t.Fatal("bucket in debt must deny after the dry run")
+	}
+	clock.Advance(3 * time.Second)
+
+	if !tb.Allow() {
+		t.Fatal("bucket must allow after the debt is refilled")
+	}
+	tb.SetDryRunNow(true)
+	tb.Pause()
+
+	if tb.Allow() {
+		t.Fatal("paused bucket must deny in the dry run")
+	}
+	clone := tb.Clone()
+	defer clone.Close()
+
+	if !clone.DryRun() {
+		t.Fatal("clone must keep the dry run")
+	}
+}
@@ -0,0 +1,165 @@
+package token_bucket
+
+import "time"
+
+const rateSlotsN = 10 // number of slots of the rate window
+
+// rateSlot
+//
+//	counters of one slot of the rate window
+//
+//	Fields:
+//
+//	[consumed]   number of consumed tokens
+//	[allowed]    number of allowed requests or operations
+//	[denied]     number of denied requests or operations
+type rateSlot struct {
+	consumed int64
+	allowed  int64
+	denied   int64
+}
+
+// SetRateWindow set recent window over which EffectiveRate averages consumed tokens
+// and WindowedStats counts decisions. counters are kept in 10 slots of the window, so memory is constant.
+// positive window under 10 nanoseconds is rounded up to one nanosecond per slot
+func SetRateWindow(window time.Duration) Option {
+	return func(tb *TokenBucket) {
+		if window > 0 && window < rateSlotsN {
+			window = rateSlotsN
+		}
+		tb.rateWindow = window
+	}
+}
+
+// consumed counts 'n' consumed tokens, negative for refunded ones.
+// must be called under the lock
+func (tb *TokenBucket) consumed(n int64) {
+	tb.consumedN += n
+
+	if tb.rateWindow <= 0 {
+		return
+	}
+	tb.advanceRate(tb.now())
+	tb.rateSlots[tb.rateSlotI].consumed += n
+}
+
+// countAllowed counts allowed request or operation which consumed 'n' tokens.
+// the rate slot is advanced by one time reading for both counters.
+// must be called under the lock
+func (tb *TokenBucket) countAllowed(n int64) {
+	tb.allowedN++
+	tb.consumedN += n
+	tb.streakN = 0
+
+	if tb.rateWindow <= 0 {
+		return
+	}
+	tb.advanceRate(tb.now())
+
+	slot := &tb.rateSlots[tb.rateSlotI]
+	slot.allowed++
+	slot.consumed += n
+}
+
+// countDenied counts denied request or operation.
+// must be called under the lock
+func (tb *TokenBucket) countDenied() {
+	tb.deniedN++
+	tb.streakN++
+
+	if tb.streakN > tb.streakMax {
+		tb.streakMax = tb.streakN
+	}
+
+	if tb.rateWindow <= 0 {
+		return
+	}
+	tb.advanceRate(tb.now())
+	tb.rateSlots[tb.rateSlotI].denied++
+}
+
+// advanceRate moves the current rate slot to 'nowT' clearing the slots passed.
+// must be called under the lock
+func (tb *TokenBucket) advanceRate(nowT time.Time) {
+	slotDur := tb.rateWindow / rateSlotsN
+
+	if tb.rateSlots == nil {
+		tb.rateSlots = make([]rateSlot, rateSlotsN)
+		tb.rateSlotT = nowT
+		return
+	}
+	passed := int64(nowT.Sub(tb.rateSlotT) / slotDur)
+
+	if passed <= 0 {
+		return
+	}
+	cleared := passed
+	if cleared > rateSlotsN {
+		cleared = rateSlotsN
+	}
+	for i := int64(0); i < cleared; i++ {
+		tb.rateSlotI = (tb.rateSlotI + 1) % rateSlotsN
+		tb.rateSlots[tb.rateSlotI] = rateSlot{}
+	}
+	tb.rateSlotT = tb.rateSlotT.Add(time.Duration(passed) * slotDur)
+}
+
+// EffectiveRate returns tokens consumed per second over the recent window set by SetRateWindow.
+// it is the actual throughput, lower than the refill rate if traffic is below the limit.
+// the bucket younger than the window reports lower rate. returns zero if the window is not set
+func (tb *TokenBucket) EffectiveRate() float64 {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.effectiveRate()
+}
+
+// effectiveRate returns tokens consumed per second over the recent window.
+// must be called under the lock
+func (tb *TokenBucket) effectiveRate() float64 {
+	if tb.rateWindow <= 0 {
+		return 0
+	}
+	nowT := tb.now()
+	tb.advanceRate(nowT)
+
+	var sum int64
+
+	for _, slot := range tb.rateSlots {
+		sum += slot.consumed
+	}
+	// the current slot is only partially elapsed, the oldest slot is already cleared
+	covered := (rateSlotsN-1)*(tb.rateWindow/rateSlotsN) + nowT.Sub(tb.rateSlotT)
+
+	if covered <= 0 {
+		return 0
+	}
+	return float64(sum) / covered.Seconds()
+}
+
+// WindowedStats returns numbers of allowed and denied requests or operations within the trailing 'window'.
+// the window is rounded up to the slots of the window set by SetRateWindow and is capped to it.
+// returns zeros if the rate window is not set
+func (tb *TokenBucket) WindowedStats(window time.Duration) (allowed, denied int64) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	if tb.rateWindow <= 0 || window <= 0 {
+		return 0, 0
+	}
+	tb.advanceRate(tb.now())
+
+	slotDur := tb.rateWindow / rateSlotsN
+	slotsN := int((window + slotDur - 1) / slotDur)
+
+	if slotsN > rateSlotsN {
+		slotsN = rateSlotsN
+	}
+	for i := 0; i < slotsN; i++ {
+		slot := tb.rateSlots[(tb.rateSlotI-i+rateSlotsN)%rateSlotsN]
+
+		allowed += slot.allowed
+		denied += slot.denied
+	}
+	return allowed, denied
+}
@@ -0,0 +1,131 @@
+package token_bucket
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEffectiveRate(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(100, 100, SetClock(clock), SetRateWindow(10*time.Second))
+	defer tb.Close()
+
+	for i := 0; i < 10; i++ {
+		tb.AllowN(5)
+		clock.Advance(time.Second)
+	}
+	if got := tb.EffectiveRate(); got < 4.9 || got > 5.1 {
+		t.Fatalf("got rate %v, want 5", got)
+	}
+	// the current slot has no decisions yet, so three slots cover the last two requests
+	if allowed, denied := tb.WindowedStats(3 * time.Second); allowed != 2 || denied != 0 {
+		t.Fatalf("got %d allowed and %d denied, want 2 and 0", allowed, denied)
+	}
+}
+
+func TestRateWindowTiny(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	for _, window := range []time.Duration{1, 5, 9} {
+		tb := NewTokenBucket(10, 1, SetClock(clock), SetRateWindow(window))
+
+		tb.Allow()
+		clock.Advance(time.Nanosecond)
+		tb.Allow()
+		tb.EffectiveRate()
+		tb.WindowedStats(time.Second)
+		tb.Close()
+	}
+}
+
+func TestWindowedStats(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock), SetRefillDuration(time.Hour), SetRateWindow(10*time.Second))
+	defer tb.Close()
+
+	if allowed, denied := tb.WindowedStats(time.Second); allowed != 0 || denied != 0 {
+		t.Fatalf("got %d allowed and %d denied of unused bucket", allowed, denied)
+	}
+	tb.AllowN(2)
+	clock.Advance(5 * time.Second)
+	tb.Allow()
+	tb.Allow()
+
+	if allowed, denied := tb.WindowedStats(time.Second); allowed != 0 || denied != 2 {
+		t.Fatalf("last second: got %d allowed and %d denied, want 0 and 2", allowed, denied)
+	}
+	// the window over the rate window is capped to it
+	if allowed, denied := tb.WindowedStats(time.Minute); allowed != 1 || denied != 2 {
+		t.Fatalf("whole window: got %d allowed and %d denied, want 1 and 2", allowed, denied)
+	}
+	clock.Advance(6 * time.Second)
+
+	if allowed, denied := tb.WindowedStats(time.Minute); allowed != 0 || denied != 2 {
+		t.Fatalf("the allowed request must leave the window: got %d allowed and %d denied", allowed, denied)
+	}
+	tb.ResetStats()
+
+	if allowed, denied := tb.WindowedStats(time.Minute); allowed != 0 || denied != 0 {
+		t.Fatalf("got %d allowed and %d denied after ResetStats", allowed, denied)
+	}
+	plain := NewTokenBucket(1, 1, SetClock(clock))
+	defer plain.Close()
+
+	plain.Allow()
+
+	if allowed, denied := plain.WindowedStats(time.Minute); allowed != 0 || denied != 0 {
+		t.Fatal("bucket without rate window must report zeros")
+	}
+}
+
+// countingClock counts readings of the wrapped clock
+type countingClock struct {
+	*TestClock
+	reads int
+}
+
+func (c *countingClock) Now() time.Time {
+	c.reads++
+	return c.TestClock.Now()
+}
+
+func TestCountAllowedClockReads(t *testing.T) {
+	clock := &countingClock{TestClock: NewTestClock(time.Unix(0, 0))}
+
+	tb := NewTokenBucket(100, 1, SetClock(clock), SetRateWindow(10*time.Second))
+	defer tb.Close()
+
+	clock.reads = 0
+	tb.AllowN(2)
+
+	// one reading by the refill and one by the rate window for both allowed and consumed counters
+	if clock.reads != 2 {
+		t.Fatalf("got %d clock readings, want 2", clock.reads)
+	}
+	if s := tb.Stats(); s.Allowed != 1 || s.Consumed != 2 {
+		t.Fatalf("got stats %+v", s)
+	}
+	if allowed, _ := tb.WindowedStats(time.Second); allowed != 1 {
+		t.Fatalf("got %d allowed in the window, want 1", allowed)
+	}
+}
+
+func BenchmarkAllowRateWindow(b *testing.B) {
+	for _, window := range []time.Duration{0, 10 * time.Second} {
+		b.Run(fmt.Sprint("window-", window), func(b *testing.B) {
+			tb := NewTokenBucket(math.MaxInt32, 1, SetRefillDuration(time.Hour), SetRateWindow(window))
+			defer tb.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				tb.AllowN(1)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package token_bucket
+
+// EqualState returns 'true' if the buckets have the same configuration and current state.
+// it compares state, not identity: a clone is equal to the source until one of them is used.
+// counters, callbacks and clock are not compared
+func EqualState(a, b *TokenBucket) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	ordered := lockOrder([]*TokenBucket{a, b})
+
+	lockAll(ordered)
+	defer unlockAll(ordered)
+
+	return a.refillRate == b.refillRate &&
+		a.maxTokens == b.maxTokens &&
+		a.currTokens == b.currTokens &&
+		a.lastFillT.Equal(b.lastFillT) &&
+		a.refillT.Equal(b.refillT) &&
+		a.partTokens == b.partTokens &&
+		a.paused == b.paused &&
+		a.pausedT.Equal(b.pausedT) &&
+		a.Weight() == b.Weight() &&
+		a.refillDur == b.refillDur &&
+		a.continuous == b.continuous &&
+		a.background == b.background &&
+		a.maxJitter == b.maxJitter &&
+		a.fifo == b.fifo &&
+		a.maxWait == b.maxWait &&
+		a.name == b.name
+}
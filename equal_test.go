@@ -0,0 +1,34 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEqualState(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	a := NewTokenBucket(5, 1, SetClock(clock))
+	defer a.Close()
+
+	b := NewTokenBucket(5, 1, SetClock(clock))
+	defer b.Close()
+
+	if !EqualState(a, b) || !EqualState(a, a) {
+		t.Fatal("buckets with the same configuration and state differ")
+	}
+	b.Allow()
+
+	if EqualState(a, b) {
+		t.Fatal("buckets with different tokens are equal")
+	}
+	if EqualState(a, nil) || !EqualState(nil, nil) {
+		t.Fatal("nil buckets must be equal only to nil")
+	}
+	c := NewTokenBucket(5, 1, SetClock(clock), SetName("c"))
+	defer c.Close()
+
+	if EqualState(a, c) {
+		t.Fatal("buckets with different names are equal")
+	}
+}
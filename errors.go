@@ -0,0 +1,30 @@
+package token_bucket
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrRateLimited
+//
+//	returned when there are not enough tokens in the bucket
+//
+//	Fields:
+//
+//	[retryAfter]   duration until the tokens are available
+type ErrRateLimited struct {
+	retryAfter time.Duration
+}
+
+// Error implements error
+func (e *ErrRateLimited) Error() string {
+	if e.retryAfter == infDuration {
+		return "token_bucket: rate limited"
+	}
+	return fmt.Sprintf("token_bucket: rate limited, retry after %s", e.retryAfter)
+}
+
+// RetryAfter returns duration until the tokens are available
+func (e *ErrRateLimited) RetryAfter() time.Duration {
+	return e.retryAfter
+}
@@ -0,0 +1,36 @@
+package token_bucket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAllowNErr(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock))
+	defer tb.Close()
+
+	if err := tb.AllowNErr(2); err != nil {
+		t.Fatalf("allowed request: %v", err)
+	}
+	clock.Advance(250 * time.Millisecond)
+
+	err := tb.AllowErr()
+
+	var limited *ErrRateLimited
+
+	if !errors.As(err, &limited) {
+		t.Fatalf("got %v, want *ErrRateLimited", err)
+	}
+	if got := limited.RetryAfter(); got != 750*time.Millisecond {
+		t.Fatalf("got retry after %s, want 750ms", got)
+	}
+	if err := tb.AllowNErr(3); !errors.As(err, &limited) || limited.RetryAfter() != infDuration {
+		t.Fatalf("request over max tokens: got %v, want *ErrRateLimited which never retries", err)
+	}
+	if got := limited.Error(); got != "token_bucket: rate limited" {
+		t.Fatalf("got error %q", got)
+	}
+}
@@ -0,0 +1,58 @@
+package expvarlimit
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// ErrPublished returned when the name is already published, expvar names are global to the process
+var ErrPublished = errors.New("expvarlimit: name is already published")
+
+// publishLock serializes checking and publishing names, expvar.Publish panics on reused names
+var publishLock sync.Mutex
+
+// bucketVar
+//
+//	published state of the bucket
+//
+//	Fields:
+//
+//	[Tokens]      current token number in bucket
+//	[MaxTokens]   maximum number of tokens in bucket
+//	[Allowed]     number of allowed requests or operations
+//	[Denied]      number of denied requests or operations
+//	[Name]        label of the bucket, omitted if empty
+type bucketVar struct {
+	Tokens    int    `json:"tokens"`
+	MaxTokens int    `json:"max_tokens"`
+	Allowed   int64  `json:"allowed"`
+	Denied    int64  `json:"denied"`
+	Name      string `json:"name,omitempty"`
+}
+
+// PublishExpvar publishes the bucket state as JSON object with the name at /debug/vars.
+// returns ErrPublished if the name is already published instead of panicking as expvar.Publish does
+func PublishExpvar(name string, tb *token_bucket.TokenBucket) error {
+	publishLock.Lock()
+	defer publishLock.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("%w: %q", ErrPublished, name)
+	}
+	expvar.Publish(name, expvar.Func(func() any {
+		stats := tb.Stats()
+
+		return bucketVar{
+			Tokens:    stats.Tokens,
+			MaxTokens: tb.Capacity(),
+			Allowed:   stats.Allowed,
+			Denied:    stats.Denied,
+			Name:      stats.Name,
+		}
+	}))
+	return nil
+}
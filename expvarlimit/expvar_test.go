@@ -0,0 +1,67 @@
+package expvarlimit
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// runN makes published names unique per run, expvar names outlive the test with -count
+var runN atomic.Int64
+
+// uniqueName returns the name with suffix unique in the test process
+func uniqueName(name string) string {
+	return fmt.Sprintf("%s_%d", name, runN.Add(1))
+}
+
+func TestPublishExpvar(t *testing.T) {
+	tb := token_bucket.NewTokenBucket(3, 1,
+		token_bucket.SetName("api"),
+		token_bucket.SetClock(token_bucket.NewTestClock(time.Unix(0, 0))),
+	)
+	defer tb.Close()
+
+	name := uniqueName("test_bucket")
+
+	if err := PublishExpvar(name, tb); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	tb.AllowN(2)
+	tb.AllowN(2)
+
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatal("bucket is not published")
+	}
+	var got bucketVar
+
+	if err := json.Unmarshal([]byte(v.String()), &got); err != nil {
+		t.Fatalf("published value %q: %v", v.String(), err)
+	}
+	want := bucketVar{Tokens: 1, MaxTokens: 3, Allowed: 1, Denied: 1, Name: "api"}
+
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPublishExpvarPublished(t *testing.T) {
+	tb := token_bucket.NewTokenBucket(1, 1, token_bucket.SetClock(token_bucket.NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	name := uniqueName("test_published")
+
+	if err := PublishExpvar(name, tb); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := PublishExpvar(name, tb); !errors.Is(err, ErrPublished) {
+		t.Fatalf("got error %v, want ErrPublished instead of panic", err)
+	}
+}
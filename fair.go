@@ -0,0 +1,189 @@
+package token_bucket
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FairKeyedLimiter
+//
+//	shares the global limit equally among active keys, e.g. two active tenants get half
+//	of the global rate each and four get a quarter, so one key can not starve the others,
+//	while a single active key gets the whole global limit.
+//	every key has ChildBucket of the global bucket with capacity and rate of the current share,
+//	the shares are recomputed when a key becomes active or inactive.
+//	the share of active key which does not use it is not given to the others until the key is inactive
+//
+//	Fields:
+//
+//	[global]       bucket of the global limit
+//	[maxTokens]    maximum number of tokens in the global bucket
+//	[refillRate]   number of tokens added in the global bucket per refill duration
+//	[keys]         buckets of active keys
+//	[sweepT]       time of the last inactive keys sweep
+//	[lock]         mutex for atomic operations
+//	[keyedConfig]  limiter options, only SetBucketOptions and SetIdleTTL apply
+type FairKeyedLimiter[K comparable] struct {
+	global     *TokenBucket
+	maxTokens  int
+	refillRate int
+	keys       map[K]*fairKey
+	sweepT     time.Time
+	lock       sync.Mutex
+
+	keyedConfig
+}
+
+// fairKey
+//
+//	active key of FairKeyedLimiter
+//
+//	Fields:
+//
+//	[bucket]   bucket of the key share consuming from the global bucket as well
+//	[lastT]    time of the last request of the key
+type fairKey struct {
+	bucket *ChildBucket
+	lastT  time.Time
+}
+
+// NewFairKeyedLimiter returns new FairKeyedLimiter entity instance sharing the global limit among active keys.
+// key is active for the idle TTL after its last request, one refill duration if SetIdleTTL is not set
+func NewFairKeyedLimiter[K comparable](globalMax, globalRate int, options ...KeyedOption) *FairKeyedLimiter[K] {
+	fl := &FairKeyedLimiter[K]{
+		maxTokens:  globalMax,
+		refillRate: globalRate,
+		keys:       map[K]*fairKey{},
+	}
+
+	for _, opt := range options {
+		opt(&fl.keyedConfig)
+	}
+	fl.global = NewTokenBucket(globalMax, globalRate, fl.options...)
+
+	if fl.idleTTL <= 0 {
+		fl.idleTTL = fl.global.RefillDuration()
+	}
+
+	return fl
+}
+
+// Allow returns 'true' if there are enough tokens both in the key share and in the global bucket
+func (fl *FairKeyedLimiter[K]) Allow(key K) bool {
+	return fl.activate(key).Allow()
+}
+
+// AllowN return 'true' if there are 'n' tokens both in the key share and in the global bucket.
+// negative 'n' is always denied and does not make the key active
+func (fl *FairKeyedLimiter[K]) AllowN(key K, n int) bool {
+	if n < 0 {
+		return false
+	}
+	return fl.activate(key).AllowN(n)
+}
+
+// Active returns number of active keys
+func (fl *FairKeyedLimiter[K]) Active() int {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	fl.sweep(fl.global.now(), true)
+
+	return len(fl.keys)
+}
+
+// Share returns capacity and refill rate of every active key share
+func (fl *FairKeyedLimiter[K]) Share() (maxTokens, refillRate int) {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	return fl.share()
+}
+
+// Global returns bucket of the global limit
+func (fl *FairKeyedLimiter[K]) Global() *TokenBucket {
+	return fl.global
+}
+
+// activate marks the key active and returns its bucket, the shares are recomputed for new active key
+func (fl *FairKeyedLimiter[K]) activate(key K) *ChildBucket {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+
+	nowT := fl.global.now()
+
+	fl.sweep(nowT, false)
+
+	if fk, ok := fl.keys[key]; ok {
+		fk.lastT = nowT
+		return fk.bucket
+	}
+	fl.keys[key] = &fairKey{
+		lastT: nowT,
+	}
+	maxTokens, refillRate := fl.share()
+
+	fl.rescale(maxTokens, refillRate)
+
+	// the key is the default name, the bucket options may override it
+	options := append([]Option{SetName(fmt.Sprint(key))}, fl.options...)
+	cb := NewChildBucket(fl.global, maxTokens, refillRate, options...)
+
+	fl.keys[key].bucket = cb
+
+	return cb
+}
+
+// sweep forgets keys idle for the idle TTL and recomputes the shares if any is forgotten.
+// keys are swept at most once per idle TTL unless 'force' is set.
+// must be called under the lock
+func (fl *FairKeyedLimiter[K]) sweep(nowT time.Time, force bool) {
+	if !force && nowT.Sub(fl.sweepT) < fl.idleTTL {
+		return
+	}
+	fl.sweepT = nowT
+	swept := false
+
+	for key, fk := range fl.keys {
+		if nowT.Sub(fk.lastT) >= fl.idleTTL {
+			delete(fl.keys, key)
+			fk.bucket.own.Close()
+			swept = true
+		}
+	}
+	if swept {
+		fl.rescale(fl.share())
+	}
+}
+
+// share returns capacity and refill rate of the global limit divided by number of active keys,
+// at least one token each.
+// must be called under the lock
+func (fl *FairKeyedLimiter[K]) share() (maxTokens, refillRate int) {
+	active := len(fl.keys)
+
+	if active == 0 {
+		active = 1
+	}
+	maxTokens, refillRate = fl.maxTokens/active, fl.refillRate/active
+
+	if maxTokens < 1 {
+		maxTokens = 1
+	}
+	if refillRate < 1 && fl.refillRate > 0 {
+		refillRate = 1
+	}
+	return maxTokens, refillRate
+}
+
+// rescale set the share to buckets of active keys, tokens over the new capacity are dropped.
+// must be called under the lock
+func (fl *FairKeyedLimiter[K]) rescale(maxTokens, refillRate int) {
+	for _, fk := range fl.keys {
+		if fk.bucket == nil {
+			continue
+		}
+		fk.bucket.own.SetMaxTokens(maxTokens).SetRefillRate(refillRate)
+	}
+}
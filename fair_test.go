@@ -0,0 +1,114 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFairKeyedLimiter(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	fl := NewFairKeyedLimiter[string](10, 10, SetBucketOptions(SetClock(clock)))
+	defer fl.Global().Close()
+
+	// a single active key gets the whole global limit
+	if !fl.Allow("a") {
+		t.Fatal("first request denied")
+	}
+	if maxTokens, refillRate := fl.Share(); maxTokens != 10 || refillRate != 10 {
+		t.Fatalf("got share %d/%d, want 10/10 for one active key", maxTokens, refillRate)
+	}
+	if !fl.Allow("b") {
+		t.Fatal("first request of the second key denied")
+	}
+	if got := fl.Active(); got != 2 {
+		t.Fatalf("got %d active keys, want 2", got)
+	}
+	if maxTokens, refillRate := fl.Share(); maxTokens != 5 || refillRate != 5 {
+		t.Fatalf("got share %d/%d, want 5/5 for two active keys", maxTokens, refillRate)
+	}
+	// the first key is rescaled to its share and can not starve the second one
+	allowed := 0
+
+	for i := 0; i < 10; i++ {
+		if fl.Allow("a") {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Fatalf("got %d allowed requests of the first key, want its share of 5", allowed)
+	}
+	// the request consumed before the second key became active counts in the global bucket
+	if !fl.AllowN("b", 3) {
+		t.Fatal("second key denied within its share and the global tokens")
+	}
+	if got := fl.Global().Tokens(); got != 0 {
+		t.Fatalf("got %d tokens in the global bucket, want 0", got)
+	}
+}
+
+func TestFairKeyedLimiterSweep(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	fl := NewFairKeyedLimiter[int](8, 4, SetBucketOptions(SetClock(clock)), SetIdleTTL(2*time.Second))
+	defer fl.Global().Close()
+
+	for key := 0; key < 4; key++ {
+		fl.Allow(key)
+	}
+	if maxTokens, refillRate := fl.Share(); maxTokens != 2 || refillRate != 1 {
+		t.Fatalf("got share %d/%d, want 2/1 for four active keys", maxTokens, refillRate)
+	}
+	clock.Advance(time.Second)
+	fl.Allow(0)
+
+	// the other keys are idle for the TTL, the recent one keeps the global limit
+	clock.Advance(time.Second)
+
+	if got := fl.Active(); got != 1 {
+		t.Fatalf("got %d active keys, want 1 after the idle TTL", got)
+	}
+	if maxTokens, refillRate := fl.Share(); maxTokens != 8 || refillRate != 4 {
+		t.Fatalf("got share %d/%d, want 8/4 for one active key", maxTokens, refillRate)
+	}
+	clock.Advance(2 * time.Second)
+
+	if got := fl.Active(); got != 0 {
+		t.Fatalf("got %d active keys, want 0", got)
+	}
+}
+
+func TestFairKeyedLimiterMinShare(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	fl := NewFairKeyedLimiter[int](2, 1, SetBucketOptions(SetClock(clock)))
+	defer fl.Global().Close()
+
+	for key := 0; key < 3; key++ {
+		fl.Allow(key)
+	}
+	// the share is at least one token even if the global limit is smaller
+	if maxTokens, refillRate := fl.Share(); maxTokens != 1 || refillRate != 1 {
+		t.Fatalf("got share %d/%d, want 1/1", maxTokens, refillRate)
+	}
+	if fl.Allow(2) {
+		t.Fatal("request allowed over the global limit")
+	}
+}
+
+func TestFairKeyedLimiterNegativeN(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	fl := NewFairKeyedLimiter[string](10, 10, SetBucketOptions(SetClock(clock)))
+	defer fl.Global().Close()
+
+	if fl.AllowN("a", -1) {
+		t.Fatal("negative tokens number allowed")
+	}
+	if got := fl.Active(); got != 0 {
+		t.Fatalf("got %d active keys, want the denied negative request not activating the key", got)
+	}
+	if got := fl.Global().Tokens(); got != 10 {
+		t.Fatalf("got %d global tokens, want 10", got)
+	}
+}
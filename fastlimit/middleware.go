@@ -0,0 +1,269 @@
+package fastlimit
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+	"github.com/UshakovN/token-bucket/httplimit"
+)
+
+// middleware
+//
+//	limits fasthttp handler requests with token bucket or other limiter
+//
+//	Fields:
+//
+//	[bucket]   returns runner of 'fn' with the limiter consumed by the request, nil to skip limiting
+//	[next]     handler called for allowed requests
+//
+//	For Options:
+//
+//	[denied] handler called for throttled requests. default: 429 Too Many Requests
+//	[emptyKey] limiter for requests with empty key in keyed middleware. default: not limited
+//	[headers] style of rate limit headers set on every response. default: none
+type middleware struct {
+	bucket func(ctx *fasthttp.RequestCtx) bucketDo
+	next   fasthttp.RequestHandler
+
+	denied   fasthttp.RequestHandler
+	emptyKey token_bucket.Limiter
+	headers  HeaderStyle
+}
+
+// InfoLimiter is implemented by limiters which report the decision state for rate limit headers,
+// e.g. TokenBucket. headers are set only for such limiters
+type InfoLimiter interface {
+	token_bucket.Limiter
+	// AllowInfo returns 'true' if one request is allowed, remaining tokens and duration until retry on denial
+	AllowInfo() (ok bool, remaining int, retryAfter time.Duration)
+	// Capacity returns maximum number of tokens
+	Capacity() int
+}
+
+// resetLimiter is implemented by limiters which report when 'n' tokens are available again
+type resetLimiter interface {
+	NextAvailable(n int) time.Time
+	DelayN(n int) time.Duration
+}
+
+// drainLimiter is implemented by limiters which can be drained for shutdown
+type drainLimiter interface {
+	IsDraining() bool
+}
+
+var _ interface {
+	InfoLimiter
+	resetLimiter
+	drainLimiter
+} = (*token_bucket.TokenBucket)(nil)
+
+// bucketDo runs 'fn' with the limiter consumed by the request, returns error of the store.
+// the limiter is used only inside it, so the decision is saved by external store of keyed limiter
+type bucketDo func(fn func(l token_bucket.Limiter)) error
+
+// bucketOf returns runner with the single limiter, nil if the limiter is nil
+func bucketOf(l token_bucket.Limiter) bucketDo {
+	if l == nil {
+		return nil
+	}
+	return func(fn func(l token_bucket.Limiter)) error {
+		fn(l)
+		return nil
+	}
+}
+
+// HeaderStyle of rate limit response headers, same as in httplimit
+type HeaderStyle = httplimit.HeaderStyle
+
+const (
+	HeadersNone   = httplimit.HeadersNone   // only Retry-After on throttled responses
+	HeadersLegacy = httplimit.HeadersLegacy // X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset in unix seconds
+	HeadersDraft  = httplimit.HeadersDraft  // IETF draft RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset in seconds from now
+)
+
+// Option for Middleware
+type Option func(*middleware)
+
+// SetDeniedHandler set handler called for throttled requests
+func SetDeniedHandler(h fasthttp.RequestHandler) Option {
+	return func(m *middleware) {
+		m.denied = h
+	}
+}
+
+// SetEmptyKeyBucket set limiter shared by requests with empty key in MiddlewareKeyed,
+// such requests are not limited by default
+func SetEmptyKeyBucket(l token_bucket.Limiter) Option {
+	return func(m *middleware) {
+		m.emptyKey = l
+	}
+}
+
+// SetRateLimitHeaders set style of rate limit headers set on every response of InfoLimiter:
+// limit is the bucket capacity, remaining is current tokens and reset is the time the bucket is full again
+func SetRateLimitHeaders(style HeaderStyle) Option {
+	return func(m *middleware) {
+		m.headers = style
+	}
+}
+
+// newMiddleware returns handler wrapper limiting requests with buckets returned by 'bucket'
+func newMiddleware(
+	bucket func(m *middleware, ctx *fasthttp.RequestCtx) bucketDo,
+	options []Option,
+) func(fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		m := &middleware{
+			next:   next,
+			denied: tooManyRequests,
+		}
+
+		for _, opt := range options {
+			opt(m)
+		}
+		m.bucket = func(ctx *fasthttp.RequestCtx) bucketDo {
+			return bucket(m, ctx)
+		}
+
+		return m.handle
+	}
+}
+
+// Middleware returns handler wrapper which consumes one token per request of the limiter.
+// throttled requests get 429 Too Many Requests, with Retry-After header if the limiter is InfoLimiter
+func Middleware(l token_bucket.Limiter, options ...Option) func(fasthttp.RequestHandler) fasthttp.RequestHandler {
+	do := bucketOf(l)
+
+	return newMiddleware(func(_ *middleware, _ *fasthttp.RequestCtx) bucketDo {
+		return do
+	}, options)
+}
+
+// MiddlewareKeyed returns handler wrapper which consumes one token per request
+// from the bucket of the request key, e.g. client IP or API key.
+// throttled requests get 429 Too Many Requests with Retry-After header,
+// requests get 503 Service Unavailable if the limiter store fails
+func MiddlewareKeyed(
+	kl *token_bucket.KeyedLimiter[string],
+	keyFn func(ctx *fasthttp.RequestCtx) string,
+	options ...Option,
+) func(fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return newMiddleware(func(m *middleware, ctx *fasthttp.RequestCtx) bucketDo {
+		key := keyFn(ctx)
+
+		if key == "" {
+			return bucketOf(m.emptyKey)
+		}
+		return func(fn func(l token_bucket.Limiter)) error {
+			return kl.Do(key, func(tb *token_bucket.TokenBucket) {
+				fn(tb)
+			})
+		}
+	}, options)
+}
+
+// handle implements fasthttp.RequestHandler.
+// requests get 503 Service Unavailable if the limiter is draining for shutdown or the store fails
+func (m *middleware) handle(ctx *fasthttp.RequestCtx) {
+	do := m.bucket(ctx)
+
+	if do == nil {
+		m.next(ctx)
+		return
+	}
+	var (
+		draining, allowed, info bool
+		limit, remaining        int
+		wait                    time.Duration
+		resetT                  time.Time
+		resetIn                 time.Duration
+	)
+	err := do(func(l token_bucket.Limiter) {
+		if dl, ok := l.(drainLimiter); ok {
+			if draining = dl.IsDraining(); draining {
+				return
+			}
+		}
+		il, ok := l.(InfoLimiter)
+
+		if info = ok; !info {
+			allowed = l.Allow()
+			return
+		}
+		allowed, remaining, wait = il.AllowInfo()
+		limit = il.Capacity()
+
+		if rl, ok := l.(resetLimiter); ok {
+			resetT, resetIn = rl.NextAvailable(limit), rl.DelayN(limit)
+		}
+	})
+	if err != nil || draining {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusServiceUnavailable), fasthttp.StatusServiceUnavailable)
+		return
+	}
+	if info {
+		m.setHeaders(ctx, limit, remaining, resetT, resetIn)
+	}
+
+	if !allowed {
+		setRetryAfter(ctx, wait)
+		m.denied(ctx)
+		return
+	}
+	m.next(ctx)
+}
+
+// tooManyRequests replies with 429 Too Many Requests.
+// the response is not reset like by ctx.Error, so the rate limit headers are kept
+func tooManyRequests(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString(fasthttp.StatusMessage(fasthttp.StatusTooManyRequests))
+}
+
+// setHeaders set rate limit headers of the configured style,
+// 'resetT' and 'resetIn' are time the bucket is full again and duration until it by the bucket clock
+func (m *middleware) setHeaders(ctx *fasthttp.RequestCtx, limit, remaining int, resetT time.Time, resetIn time.Duration) {
+	if m.headers == HeadersNone {
+		return
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	prefix := "RateLimit-"
+
+	if m.headers == HeadersLegacy {
+		prefix = "X-RateLimit-"
+	}
+	h := &ctx.Response.Header
+
+	h.Set(prefix+"Limit", strconv.Itoa(limit))
+	h.Set(prefix+"Remaining", strconv.Itoa(remaining))
+
+	if resetT.IsZero() {
+		return
+	}
+	if m.headers == HeadersLegacy {
+		h.Set(prefix+"Reset", strconv.FormatInt(resetT.Add(time.Second-1).Unix(), 10))
+		return
+	}
+	// the bucket clock may differ from the wall clock, e.g. in tests
+	secs := int(math.Ceil(resetIn.Seconds()))
+
+	h.Set(prefix+"Reset", strconv.Itoa(secs))
+}
+
+// setRetryAfter set Retry-After header in whole seconds,
+// nothing is set if the tokens can never be available
+func setRetryAfter(ctx *fasthttp.RequestCtx, d time.Duration) {
+	if d <= 0 || d == math.MaxInt64 {
+		return
+	}
+	secs := int(math.Ceil(d.Seconds()))
+
+	ctx.Response.Header.Set("Retry-After", strconv.Itoa(secs))
+}
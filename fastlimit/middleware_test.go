@@ -0,0 +1,247 @@
+package fastlimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// failingStore fails every operation like unavailable external store
+type failingStore struct{}
+
+func (failingStore) Do(string, func() *token_bucket.TokenBucket, func(tb *token_bucket.TokenBucket)) error {
+	return errors.New("store is down")
+}
+
+func (failingStore) Delete(string) {}
+
+func (failingStore) Len() int { return 0 }
+
+func (failingStore) Evict(func(tb *token_bucket.TokenBucket) bool) {}
+
+func serve(h fasthttp.RequestHandler, key string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("X-Key", key)
+
+	h(ctx)
+
+	return ctx
+}
+
+func headerKey(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.Request.Header.Peek("X-Key"))
+}
+
+func TestMiddlewareKeyed(t *testing.T) {
+	kl := token_bucket.NewKeyedLimiter[string](1, 1,
+		token_bucket.SetBucketOptions(token_bucket.SetRefillDuration(time.Hour)))
+	defer kl.Close()
+
+	h := MiddlewareKeyed(kl, headerKey, SetRateLimitHeaders(HeadersLegacy))(func(ctx *fasthttp.RequestCtx) {})
+
+	if ctx := serve(h, "a"); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("first request: got status %d", ctx.Response.StatusCode())
+	}
+	ctx := serve(h, "a")
+
+	if ctx.Response.StatusCode() != fasthttp.StatusTooManyRequests {
+		t.Fatalf("second request: got status %d, want %d", ctx.Response.StatusCode(), fasthttp.StatusTooManyRequests)
+	}
+	if got := string(ctx.Response.Header.Peek("X-RateLimit-Limit")); got != "1" {
+		t.Fatalf("got limit %q, want 1", got)
+	}
+	if got := string(ctx.Response.Header.Peek("Retry-After")); got == "" {
+		t.Fatal("throttled response must have Retry-After")
+	}
+	if ctx := serve(h, "b"); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("key 'b' must have independent limit: got status %d", ctx.Response.StatusCode())
+	}
+}
+
+func TestMiddlewareKeyedStoreError(t *testing.T) {
+	kl := token_bucket.NewKeyedLimiterStore[string](failingStore{}, 1, 1)
+	defer kl.Close()
+
+	called := false
+	h := MiddlewareKeyed(kl, headerKey)(func(ctx *fasthttp.RequestCtx) { called = true })
+
+	if ctx := serve(h, "a"); ctx.Response.StatusCode() != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", ctx.Response.StatusCode(), fasthttp.StatusServiceUnavailable)
+	}
+	if called {
+		t.Fatal("next handler must not be called if the store fails")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(1000, 0))
+
+	tb := token_bucket.NewTokenBucket(2, 1, token_bucket.SetClock(clock))
+	defer tb.Close()
+
+	called := 0
+	h := Middleware(tb, SetRateLimitHeaders(HeadersDraft))(func(ctx *fasthttp.RequestCtx) { called++ })
+
+	for i, want := range []int{fasthttp.StatusOK, fasthttp.StatusOK, fasthttp.StatusTooManyRequests} {
+		if ctx := serve(h, ""); ctx.Response.StatusCode() != want {
+			t.Fatalf("request %d: got status %d, want %d", i, ctx.Response.StatusCode(), want)
+		}
+	}
+	if called != 2 {
+		t.Fatalf("next handler must be called for allowed requests only: called %d times", called)
+	}
+	ctx := serve(h, "")
+
+	for header, want := range map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "2",
+		"Retry-After":         "1",
+	} {
+		if got := string(ctx.Response.Header.Peek(header)); got != want {
+			t.Fatalf("got %s %q, want %q", header, got, want)
+		}
+	}
+	clock.Advance(time.Second)
+
+	if ctx := serve(h, ""); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("refilled bucket: got status %d", ctx.Response.StatusCode())
+	}
+}
+
+func TestMiddlewareDeniedHandler(t *testing.T) {
+	tb := token_bucket.NewTokenBucket(1, 1, token_bucket.SetClock(token_bucket.NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	denied := func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusTeapot) }
+	h := Middleware(tb, SetDeniedHandler(denied))(func(ctx *fasthttp.RequestCtx) {})
+
+	serve(h, "")
+
+	if ctx := serve(h, ""); ctx.Response.StatusCode() != fasthttp.StatusTeapot {
+		t.Fatalf("throttled request must be served by the denied handler: got status %d", ctx.Response.StatusCode())
+	}
+	tb.BeginDrain()
+
+	if ctx := serve(h, ""); ctx.Response.StatusCode() != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("draining bucket: got status %d, want %d", ctx.Response.StatusCode(), fasthttp.StatusServiceUnavailable)
+	}
+}
+
+func TestMiddlewareKeyedEmptyKey(t *testing.T) {
+	kl := token_bucket.NewKeyedLimiter[string](5, 1)
+	defer kl.Close()
+
+	empty := token_bucket.NewTokenBucket(1, 1, token_bucket.SetClock(token_bucket.NewTestClock(time.Unix(0, 0))))
+	defer empty.Close()
+
+	h := MiddlewareKeyed(kl, headerKey, SetEmptyKeyBucket(empty))(func(ctx *fasthttp.RequestCtx) {})
+
+	for i, want := range []int{fasthttp.StatusOK, fasthttp.StatusTooManyRequests} {
+		if ctx := serve(h, ""); ctx.Response.StatusCode() != want {
+			t.Fatalf("request %d with empty key: got status %d, want %d", i, ctx.Response.StatusCode(), want)
+		}
+	}
+	if kl.Len() != 0 {
+		t.Fatalf("empty key must not create bucket: got %d buckets", kl.Len())
+	}
+}
+
+func TestMiddlewareLimiter(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	sw := token_bucket.NewSlidingWindowLimiter(2, time.Second, token_bucket.SetClock(clock))
+	h := Middleware(sw, SetRateLimitHeaders(HeadersDraft))(func(ctx *fasthttp.RequestCtx) {})
+
+	for i, want := range []int{fasthttp.StatusOK, fasthttp.StatusOK, fasthttp.StatusTooManyRequests} {
+		if ctx := serve(h, ""); ctx.Response.StatusCode() != want {
+			t.Fatalf("request %d: got status %d, want %d", i, ctx.Response.StatusCode(), want)
+		}
+	}
+	// the limiter does not report its state, so no headers are set
+	ctx := serve(h, "")
+
+	for _, header := range []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"} {
+		if got := ctx.Response.Header.Peek(header); got != nil {
+			t.Fatalf("got %s %q, want not set for plain limiter", header, got)
+		}
+	}
+	clock.Advance(time.Second)
+
+	if ctx := serve(h, ""); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("request after the window: got status %d", ctx.Response.StatusCode())
+	}
+}
+
+// infoLimiter reports its state, but not the reset time
+type infoLimiter struct {
+	tokens int
+}
+
+func (il *infoLimiter) Allow() bool {
+	ok, _, _ := il.AllowInfo()
+	return ok
+}
+
+func (il *infoLimiter) AllowN(n int) bool {
+	if n > il.tokens {
+		return false
+	}
+	il.tokens -= n
+	return true
+}
+
+func (il *infoLimiter) AllowInfo() (bool, int, time.Duration) {
+	if !il.AllowN(1) {
+		return false, il.tokens, 3 * time.Second
+	}
+	return true, il.tokens, 0
+}
+
+func (il *infoLimiter) Capacity() int { return 5 }
+
+func TestMiddlewareInfoLimiter(t *testing.T) {
+	h := Middleware(&infoLimiter{tokens: 1}, SetRateLimitHeaders(HeadersLegacy))(func(ctx *fasthttp.RequestCtx) {})
+
+	serve(h, "")
+	ctx := serve(h, "")
+
+	if ctx.Response.StatusCode() != fasthttp.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", ctx.Response.StatusCode(), fasthttp.StatusTooManyRequests)
+	}
+	for header, want := range map[string]string{
+		"X-RateLimit-Limit":     "5",
+		"X-RateLimit-Remaining": "0",
+		"Retry-After":           "3",
+	} {
+		if got := string(ctx.Response.Header.Peek(header)); got != want {
+			t.Fatalf("got %s %q, want %q", header, got, want)
+		}
+	}
+	if got := ctx.Response.Header.Peek("X-RateLimit-Reset"); got != nil {
+		t.Fatalf("got reset %q, want not set for limiter without reset time", got)
+	}
+}
+
+func TestMiddlewareKeyedEmptyKeyLimiter(t *testing.T) {
+	kl := token_bucket.NewKeyedLimiter[string](5, 1)
+	defer kl.Close()
+
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+	empty := token_bucket.NewSlidingWindowLimiter(1, time.Minute, token_bucket.SetClock(clock))
+
+	h := MiddlewareKeyed(kl, headerKey, SetEmptyKeyBucket(empty))(func(ctx *fasthttp.RequestCtx) {})
+
+	for i, want := range []int{fasthttp.StatusOK, fasthttp.StatusTooManyRequests} {
+		if ctx := serve(h, ""); ctx.Response.StatusCode() != want {
+			t.Fatalf("request %d with empty key: got status %d, want %d", i, ctx.Response.StatusCode(), want)
+		}
+	}
+	if ctx := serve(h, "a"); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("keyed request: got status %d", ctx.Response.StatusCode())
+	}
+}
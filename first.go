@@ -0,0 +1,60 @@
+package token_bucket
+
+import (
+	"math"
+	"time"
+)
+
+// SetProportionalFirstInterval set crediting tokens of the first refill interval after creation
+// proportionally to the time elapsed since the creation, so a bucket emptied right after creation
+// gets its first token after 'refillDur'/'refillRate' instead of waiting the whole 'refillDur'.
+// the first interval boundary credits only the rest of 'refillRate', later intervals are refilled as usual.
+// the proportional crediting ends early if the refill duration is changed at runtime. default: false
+func SetProportionalFirstInterval(proportional bool) Option {
+	return func(tb *TokenBucket) {
+		tb.firstProp = proportional
+	}
+}
+
+// startFirstInterval starts proportional crediting of the first refill interval if it is set.
+// must be called under the lock or on creation
+func (tb *TokenBucket) startFirstInterval() {
+	tb.early = tb.firstProp && !tb.continuous
+	tb.earlyN = 0
+}
+
+// refillEarly credits tokens due for the time elapsed in the first refill interval.
+// must be called under the lock
+func (tb *TokenBucket) refillEarly(nowT time.Time) {
+	elapsed := nowT.Sub(tb.lastFillT)
+
+	if elapsed <= 0 {
+		return
+	}
+	due := int64(math.Floor(float64(tb.refillRate) * float64(elapsed) / float64(tb.refillDur)))
+
+	if due <= tb.earlyN {
+		return
+	}
+	prevTokens := tb.currTokens
+	tb.currTokens = fill(tb.currTokens, due-tb.earlyN, tb.capacityAt(nowT))
+	tb.refilled(tb.currTokens-prevTokens, nowT)
+
+	tb.earlyN = due
+}
+
+// endFirstInterval returns tokens credited for 'intervals' reduced by tokens credited early
+// in the first refill interval and ends the proportional crediting.
+// must be called under the lock
+func (tb *TokenBucket) endFirstInterval(credited int64) int64 {
+	if !tb.early {
+		return credited
+	}
+	credited -= tb.earlyN
+	tb.early, tb.earlyN = false, 0
+
+	if credited < 0 {
+		return 0
+	}
+	return credited
+}
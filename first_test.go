@@ -0,0 +1,62 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFirstIntervalEmptiedRightAfterCreation(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 10, SetClock(clock))
+
+	tb.AllowN(10)
+	clock.Advance(900 * time.Millisecond)
+
+	if tb.Allow() {
+		t.Fatal("discrete bucket refilled before the first interval")
+	}
+	clock.Advance(100 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 10 {
+		t.Fatalf("tokens after the first interval: %d, want 10", got)
+	}
+}
+
+func TestProportionalFirstInterval(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	tb := NewTokenBucket(10, 10, SetClock(clock), SetProportionalFirstInterval(true))
+
+	tb.AllowN(10)
+	clock.Advance(99 * time.Millisecond)
+
+	if tb.Allow() {
+		t.Fatal("token credited before 'refillDur'/'refillRate'")
+	}
+	clock.Advance(time.Millisecond)
+
+	if !tb.Allow() {
+		t.Fatal("no token after 'refillDur'/'refillRate'")
+	}
+	clock.Advance(400 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 4 {
+		t.Fatalf("tokens in the middle of the first interval: %d, want 4", got)
+	}
+	clock.Advance(500 * time.Millisecond)
+
+	// the first interval credits 'refillRate' in total, one token was consumed
+	if got := tb.Tokens(); got != 9 {
+		t.Fatalf("tokens after the first interval: %d, want 9", got)
+	}
+	tb.AllowN(9)
+	clock.Advance(500 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("later interval credited proportionally: %d tokens", got)
+	}
+	clock.Advance(500 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 10 {
+		t.Fatalf("tokens after the second interval: %d, want 10", got)
+	}
+}
@@ -0,0 +1,111 @@
+package token_bucket
+
+import (
+	"sync"
+	"time"
+)
+
+// FixedWindowLimiter
+//
+//	counts tokens consumed in the current window aligned to the clock, e.g. calendar hour
+//	resetting at :00, and resets the counter when the window rolls over.
+//	unlike TokenBucket the whole limit is available again at every window start
+//
+//	Fields:
+//
+//	[limit]     maximum number of tokens consumed in one window
+//	[window]    duration of the window, windows are aligned to multiples of it since zero time in UTC
+//	[startT]    start time of the current window
+//	[consumed]  number of tokens consumed in the current window
+//	[lock]      mutex for atomic operations
+//
+//	For Options:
+//
+//	[tokenN] weight for one request or operation. default: 1
+//	[clock] source of current time. default: wall clock with monotonic reading
+type FixedWindowLimiter struct {
+	limit    int
+	window   time.Duration
+	startT   time.Time
+	consumed int
+	lock     sync.Mutex
+
+	tokenN int
+	clock  Clock
+}
+
+var _ Limiter = (*FixedWindowLimiter)(nil)
+
+// NewFixedWindowLimiter returns new FixedWindowLimiter entity instance.
+// options not related to weight and clock are ignored
+func NewFixedWindowLimiter(limit int, window time.Duration, options ...Option) *FixedWindowLimiter {
+	cfg := NewTokenBucket(limit, limit, options...)
+
+	fw := &FixedWindowLimiter{
+		limit:  limit,
+		window: window,
+		tokenN: cfg.Weight(),
+		clock:  cfg.clock,
+	}
+	cfg.Close()
+
+	fw.startT = fw.clock.Now().Truncate(fw.window)
+
+	return fw
+}
+
+// roll resets the counter if the current window is over.
+// must be called under the lock
+func (fw *FixedWindowLimiter) roll() {
+	startT := fw.clock.Now().Truncate(fw.window)
+
+	if !startT.Equal(fw.startT) {
+		fw.startT = startT
+		fw.consumed = 0
+	}
+}
+
+// AllowN return 'true' if 'n' tokens fit in the limit of the current window.
+// non-positive 'n' is denied
+func (fw *FixedWindowLimiter) AllowN(n int) bool {
+	if n <= 0 {
+		return false
+	}
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+
+	fw.roll()
+
+	// compared without adding 'n', so huge 'n' can not overflow
+	if n > fw.limit-fw.consumed {
+		return false
+	}
+	fw.consumed += n
+
+	return true
+}
+
+// Allow returns 'true' if weight of one request or operation fits in the limit of the current window
+func (fw *FixedWindowLimiter) Allow() bool {
+	return fw.AllowN(fw.tokenN)
+}
+
+// RemainingInWindow returns number of tokens which can be consumed in the current window
+func (fw *FixedWindowLimiter) RemainingInWindow() int {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+
+	fw.roll()
+
+	return fw.limit - fw.consumed
+}
+
+// WindowResetsAt returns time at which the current window is over and the counter is reset
+func (fw *FixedWindowLimiter) WindowResetsAt() time.Time {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+
+	fw.roll()
+
+	return fw.startT.Add(fw.window)
+}
@@ -0,0 +1,39 @@
+package token_bucket
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFixedWindowLimiter(t *testing.T) {
+	clock := NewTestClock(time.Unix(10, 0))
+	fw := NewFixedWindowLimiter(2, time.Second, SetClock(clock))
+
+	if !fw.Allow() || !fw.Allow() || fw.Allow() {
+		t.Fatal("two requests must be allowed per window")
+	}
+	if got, want := fw.WindowResetsAt(), time.Unix(11, 0); !got.Equal(want) {
+		t.Fatalf("got reset at %v, want %v", got, want)
+	}
+	clock.Advance(time.Second)
+
+	if got := fw.RemainingInWindow(); got != 2 {
+		t.Fatalf("counter must be reset in the next window: got %d remaining, want 2", got)
+	}
+}
+
+func TestFixedWindowLimiterInvalidN(t *testing.T) {
+	fw := NewFixedWindowLimiter(3, time.Second, SetClock(NewTestClock(time.Unix(0, 0))))
+
+	fw.Allow()
+
+	for _, n := range []int{math.MinInt, -1, 0, 3, math.MaxInt} {
+		if fw.AllowN(n) {
+			t.Fatalf("n %d must be denied", n)
+		}
+	}
+	if got := fw.RemainingInWindow(); got != 2 {
+		t.Fatalf("denied requests must not consume: got %d remaining, want 2", got)
+	}
+}
@@ -0,0 +1,103 @@
+package grpclimit
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+	"github.com/UshakovN/token-bucket/limitctx"
+)
+
+// interceptor
+//
+//	limits unary RPCs with token bucket
+//
+//	Fields:
+//
+//	[tb]   bucket consumed by every RPC
+//
+//	For Options:
+//
+//	[weight] tokens number consumed by the RPC method. default: bucket weight for one operation
+type interceptor struct {
+	tb *token_bucket.TokenBucket
+
+	weight func(fullMethod string) int
+}
+
+// Option for UnaryServerInterceptor
+type Option func(*interceptor)
+
+// SetMethodWeight set function which returns tokens number consumed by the RPC method.
+// non-positive weight falls back to the bucket weight for one operation
+func SetMethodWeight(fn func(fullMethod string) int) Option {
+	return func(i *interceptor) {
+		i.weight = fn
+	}
+}
+
+// UnaryServerInterceptor returns interceptor which consumes tokens per RPC.
+// throttled RPCs fail with codes.ResourceExhausted and RetryInfo detail with the delay until the tokens are available
+func UnaryServerInterceptor(tb *token_bucket.TokenBucket, options ...Option) grpc.UnaryServerInterceptor {
+	i := &interceptor{
+		tb: tb,
+	}
+
+	for _, opt := range options {
+		opt(i)
+	}
+
+	return i.intercept
+}
+
+// intercept implements grpc.UnaryServerInterceptor.
+// the decision is passed to the handler in the context, see limitctx.LimitInfoFromContext
+func (i *interceptor) intercept(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	var decision limitctx.LimitInfo
+
+	decision.Allowed, decision.Remaining, decision.RetryAfter = i.tb.AllowNInfo(i.weightOf(info.FullMethod))
+
+	if !decision.Allowed {
+		return nil, rateLimited(info.FullMethod, decision.RetryAfter)
+	}
+	return handler(limitctx.WithLimitInfo(ctx, decision), req)
+}
+
+// weightOf returns tokens number consumed by the RPC method
+func (i *interceptor) weightOf(fullMethod string) int {
+	if i.weight != nil {
+		if n := i.weight(fullMethod); n > 0 {
+			return n
+		}
+	}
+	return i.tb.Weight()
+}
+
+// rateLimited returns codes.ResourceExhausted error of the RPC method with RetryInfo detail,
+// the detail is not set if the tokens can never be available
+func rateLimited(fullMethod string, retryAfter time.Duration) error {
+	st := status.Newf(codes.ResourceExhausted, "%s is rate limited", fullMethod)
+
+	if retryAfter <= 0 || retryAfter == math.MaxInt64 {
+		return st.Err()
+	}
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryAfter),
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
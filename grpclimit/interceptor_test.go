@@ -0,0 +1,88 @@
+package grpclimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+	"github.com/UshakovN/token-bucket/limitctx"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	tb := token_bucket.NewTokenBucket(3, 1, token_bucket.SetClock(clock))
+	defer tb.Close()
+
+	intercept := UnaryServerInterceptor(tb, SetMethodWeight(func(fullMethod string) int {
+		if fullMethod == "/svc/Heavy" {
+			return 2
+		}
+		return 0
+	}))
+	var got limitctx.LimitInfo
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		got, _ = limitctx.LimitInfoFromContext(ctx)
+		return req, nil
+	}
+	if _, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Heavy"}, handler); err != nil {
+		t.Fatalf("first RPC: %v", err)
+	}
+	if !got.Allowed || got.Remaining != 1 {
+		t.Fatalf("got limit info %+v, want allowed with 1 remaining", got)
+	}
+	_, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Heavy"}, handler)
+
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("got code %v, want ResourceExhausted", st.Code())
+	}
+	var retry *errdetails.RetryInfo
+
+	for _, detail := range st.Details() {
+		if ri, ok := detail.(*errdetails.RetryInfo); ok {
+			retry = ri
+		}
+	}
+	if retry == nil || retry.RetryDelay.AsDuration() != time.Second {
+		t.Fatalf("got retry info %v, want 1s delay", retry)
+	}
+	if _, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Light"}, handler); err != nil {
+		t.Fatalf("light RPC must consume the bucket weight: %v", err)
+	}
+}
+
+func TestUnaryServerInterceptorNeverAvailable(t *testing.T) {
+	tb := token_bucket.NewTokenBucket(2, 1, token_bucket.SetClock(token_bucket.NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	intercept := UnaryServerInterceptor(tb, SetMethodWeight(func(string) int { return 3 }))
+	called := false
+
+	handler := func(ctx context.Context, req any) (any, error) {
+		called = true
+		return req, nil
+	}
+	_, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Huge"}, handler)
+
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("got code %v, want ResourceExhausted", st.Code())
+	}
+	if len(st.Details()) != 0 {
+		t.Fatalf("got details %v, want no RetryInfo for weight over capacity", st.Details())
+	}
+	if called {
+		t.Fatal("handler must not be called for throttled RPC")
+	}
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want throttled RPC not consuming them", got)
+	}
+}
@@ -0,0 +1,83 @@
+package httplimit
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// IPOptions
+//
+//	client IP resolution of MiddlewareIP
+//
+//	Fields:
+//
+//	[TrustedProxies]   number of reverse proxies in front of the server appending to X-Forwarded-For.
+//	                   headers are ignored if zero, so clients can not spoof their IP
+//	[Allowlist]        client networks which are never limited
+type IPOptions struct {
+	TrustedProxies int
+	Allowlist      []netip.Prefix
+}
+
+// MiddlewareIP returns handler wrapper which consumes one token per request
+// from the bucket of the client IP. requests from the allowlisted networks are not limited.
+// throttled requests get 429 Too Many Requests with Retry-After header,
+// requests get 503 Service Unavailable if the limiter store fails
+func MiddlewareIP(
+	kl *token_bucket.KeyedLimiter[string],
+	opts IPOptions,
+	options ...Option,
+) func(http.Handler) http.Handler {
+	return newMiddleware(func(m *middleware, r *http.Request) (target, bool) {
+		ip, ok := clientIP(r, opts.TrustedProxies)
+
+		if !ok {
+			return m.emptyKeyTarget()
+		}
+		for _, prefix := range opts.Allowlist {
+			if prefix.Contains(ip) {
+				return target{}, false
+			}
+		}
+		return keyTarget(kl, ip.String()), true
+	}, options)
+}
+
+// clientIP returns IP of the client behind 'trustedProxies' reverse proxies.
+// every trusted proxy appends address of its peer to X-Forwarded-For,
+// so addresses before the last 'trustedProxies' ones may be spoofed by the client
+func clientIP(r *http.Request, trustedProxies int) (netip.Addr, bool) {
+	chain := make([]string, 0, 4)
+
+	if trustedProxies > 0 {
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(header, ",") {
+				chain = append(chain, strings.TrimSpace(addr))
+			}
+		}
+		if len(chain) == 0 {
+			if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+				chain = append(chain, strings.TrimSpace(realIP))
+			}
+		}
+	}
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	chain = append(chain, remote)
+
+	i := len(chain) - 1 - trustedProxies
+	if i < 0 {
+		i = 0
+	}
+	ip, err := netip.ParseAddr(chain[i])
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
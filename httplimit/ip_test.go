@@ -0,0 +1,103 @@
+package httplimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   []string
+		realIP         string
+		trustedProxies int
+		want           string
+	}{
+		{"remote", "10.0.0.1:1234", nil, "", 0, "10.0.0.1"},
+		{"untrusted header", "10.0.0.1:1234", []string{"1.1.1.1"}, "", 0, "10.0.0.1"},
+		{"one proxy", "10.0.0.1:1234", []string{"6.6.6.6, 1.1.1.1"}, "", 1, "1.1.1.1"},
+		{"two proxies", "10.0.0.1:1234", []string{"6.6.6.6", "1.1.1.1, 10.0.0.2"}, "", 2, "1.1.1.1"},
+		{"more proxies than chain", "10.0.0.1:1234", []string{"1.1.1.1"}, "", 5, "1.1.1.1"},
+		{"real ip", "10.0.0.1:1234", nil, "2.2.2.2", 1, "2.2.2.2"},
+		{"mapped ipv6", "[::ffff:3.3.3.3]:1234", nil, "", 0, "3.3.3.3"},
+		{"no port", "4.4.4.4", nil, "", 0, "4.4.4.4"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+
+		for _, header := range tt.forwardedFor {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		ip, ok := clientIP(r, tt.trustedProxies)
+
+		if !ok || ip.String() != tt.want {
+			t.Fatalf("%s: got %s, %t, want %s", tt.name, ip, ok, tt.want)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "pipe"
+
+	if _, ok := clientIP(r, 0); ok {
+		t.Fatal("invalid remote address resolved")
+	}
+}
+
+func TestMiddlewareIP(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	kl := token_bucket.NewKeyedLimiter[string](1, 1,
+		token_bucket.SetBucketOptions(token_bucket.SetClock(clock)))
+	defer kl.Close()
+
+	opts := IPOptions{
+		TrustedProxies: 1,
+		Allowlist:      []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")},
+	}
+	h := MiddlewareIP(kl, opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serveIP := func(forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w.Code
+	}
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		if got := serveIP("1.1.1.1"); got != want {
+			t.Fatalf("request %d of 1.1.1.1: got status %d, want %d", i, got, want)
+		}
+	}
+	// the spoofed address before the trusted proxy does not change the client
+	if got := serveIP("9.9.9.9, 1.1.1.1"); got != http.StatusTooManyRequests {
+		t.Fatalf("spoofed request: got status %d, want %d", got, http.StatusTooManyRequests)
+	}
+	if got := serveIP("2.2.2.2"); got != http.StatusOK {
+		t.Fatalf("other client must have independent limit: got status %d", got)
+	}
+	for i := 0; i < 3; i++ {
+		if got := serveIP("192.168.1.1"); got != http.StatusOK {
+			t.Fatalf("allowlisted request %d: got status %d", i, got)
+		}
+	}
+	if kl.Len() != 2 {
+		t.Fatalf("got %d buckets, want 2 without allowlisted client", kl.Len())
+	}
+	clock.Advance(time.Second)
+
+	if got := serveIP("1.1.1.1"); got != http.StatusOK {
+		t.Fatalf("refilled client: got status %d", got)
+	}
+}
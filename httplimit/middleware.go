@@ -0,0 +1,322 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+	"github.com/UshakovN/token-bucket/limitctx"
+)
+
+// middleware
+//
+//	limits handler requests with token bucket
+//
+//	Fields:
+//
+//	[bucket]   returns bucket consumed by the request, 'false' to skip limiting
+//	[next]     handler called for allowed requests
+//
+//	For Options:
+//
+//	[denied] handler called for throttled requests. default: 429 Too Many Requests
+//	[emptyKey] bucket for requests with empty key in keyed middleware. default: not limited
+//	[headers] style of rate limit headers set on every response. default: none
+//	[wait] wait for tokens within the request context instead of rejecting. default: false
+type middleware struct {
+	bucket func(r *http.Request) (target, bool)
+	next   http.Handler
+
+	denied   http.Handler
+	emptyKey *token_bucket.TokenBucket
+	headers  HeaderStyle
+	wait     bool
+}
+
+// target
+//
+//	bucket consumed by the request, it is used only inside 'do',
+//	so the decision is saved by external store of keyed limiter
+//
+//	Fields:
+//
+//	[do]     runs 'fn' with the bucket, returns error of the store
+//	[wait]   waits for weight of one request in the bucket and consumes it
+type target struct {
+	do   func(fn func(tb *token_bucket.TokenBucket)) error
+	wait func(ctx context.Context) error
+}
+
+// bucketTarget returns target of the single bucket
+func bucketTarget(tb *token_bucket.TokenBucket) target {
+	return target{
+		do: func(fn func(tb *token_bucket.TokenBucket)) error {
+			fn(tb)
+			return nil
+		},
+		wait: tb.Wait,
+	}
+}
+
+// keyTarget returns target of the key bucket of the keyed limiter
+func keyTarget(kl *token_bucket.KeyedLimiter[string], key string) target {
+	return target{
+		do: func(fn func(tb *token_bucket.TokenBucket)) error {
+			return kl.Do(key, fn)
+		},
+		wait: func(ctx context.Context) error {
+			return kl.Wait(ctx, key)
+		},
+	}
+}
+
+// emptyKeyTarget returns target of the empty key bucket, 'false' if such requests are not limited
+func (m *middleware) emptyKeyTarget() (target, bool) {
+	if m.emptyKey == nil {
+		return target{}, false
+	}
+	return bucketTarget(m.emptyKey), true
+}
+
+// HeaderStyle of rate limit response headers
+type HeaderStyle int
+
+const (
+	HeadersNone   HeaderStyle = iota // only Retry-After on throttled responses
+	HeadersLegacy                    // X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset in unix seconds
+	HeadersDraft                     // IETF draft RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset in seconds from now
+)
+
+// Option for Middleware
+type Option func(*middleware)
+
+// SetDeniedHandler set handler called for throttled requests
+func SetDeniedHandler(h http.Handler) Option {
+	return func(m *middleware) {
+		m.denied = h
+	}
+}
+
+// SetEmptyKeyBucket set bucket shared by requests with empty key in MiddlewareKeyed,
+// such requests are not limited by default
+func SetEmptyKeyBucket(tb *token_bucket.TokenBucket) Option {
+	return func(m *middleware) {
+		m.emptyKey = tb
+	}
+}
+
+// SetRateLimitHeaders set style of rate limit headers set on every response:
+// limit is the bucket capacity, remaining is current tokens and reset is the time the bucket is full again
+func SetRateLimitHeaders(style HeaderStyle) Option {
+	return func(m *middleware) {
+		m.headers = style
+	}
+}
+
+// SetWaitForTokens set waiting for tokens within the request context, so requests are paced instead of rejected.
+// requests are throttled only if the tokens can not be accumulated before the request deadline
+// or the bucket max wait, the wait is aborted as soon as the client disconnects
+func SetWaitForTokens(wait bool) Option {
+	return func(m *middleware) {
+		m.wait = wait
+	}
+}
+
+// newMiddleware returns handler wrapper limiting requests with buckets returned by 'bucket'
+func newMiddleware(
+	bucket func(m *middleware, r *http.Request) (target, bool),
+	options []Option,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		m := &middleware{
+			next:   next,
+			denied: http.HandlerFunc(tooManyRequests),
+		}
+
+		for _, opt := range options {
+			opt(m)
+		}
+		m.bucket = func(r *http.Request) (target, bool) {
+			return bucket(m, r)
+		}
+
+		return m
+	}
+}
+
+// Middleware returns handler wrapper which consumes one token per request.
+// throttled requests get 429 Too Many Requests with Retry-After header
+func Middleware(tb *token_bucket.TokenBucket, options ...Option) func(http.Handler) http.Handler {
+	return newMiddleware(func(_ *middleware, _ *http.Request) (target, bool) {
+		return bucketTarget(tb), true
+	}, options)
+}
+
+// MiddlewareKeyed returns handler wrapper which consumes one token per request
+// from the bucket of the request key, e.g. client IP or API key.
+// throttled requests get 429 Too Many Requests with Retry-After header,
+// requests get 503 Service Unavailable if the limiter store fails
+func MiddlewareKeyed(
+	kl *token_bucket.KeyedLimiter[string],
+	keyFn func(r *http.Request) string,
+	options ...Option,
+) func(http.Handler) http.Handler {
+	return newMiddleware(func(m *middleware, r *http.Request) (target, bool) {
+		key := keyFn(r)
+
+		if key == "" {
+			return m.emptyKeyTarget()
+		}
+		return keyTarget(kl, key), true
+	}, options)
+}
+
+// decision
+//
+//	decision of the request made inside the bucket target
+//
+//	Fields:
+//
+//	[info]       decision passed to the handlers
+//	[draining]   the bucket is draining for shutdown
+//	[limit]      capacity of the bucket
+//	[resetT]     time the bucket is full again
+//	[resetIn]    duration until the bucket is full again by the bucket clock
+type decision struct {
+	info     limitctx.LimitInfo
+	draining bool
+	limit    int
+	resetT   time.Time
+	resetIn  time.Duration
+}
+
+// ServeHTTP implements http.Handler.
+// requests get 503 Service Unavailable if the bucket is draining for shutdown or the store fails.
+// the decision is passed to the handlers in the request context, see limitctx.LimitInfoFromContext
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t, ok := m.bucket(r)
+
+	if !ok {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	var (
+		d   decision
+		err error
+	)
+	if m.wait {
+		d, ok, err = waitDecision(r, t)
+		if !ok {
+			return
+		}
+	} else {
+		err = t.do(func(tb *token_bucket.TokenBucket) {
+			if d.draining = tb.IsDraining(); d.draining {
+				return
+			}
+			d.info.Allowed, d.info.Remaining, d.info.RetryAfter = tb.AllowInfo()
+			d.limit, d.resetT, d.resetIn = resetOf(tb)
+		})
+	}
+	if err != nil || d.draining {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	m.setHeaders(w, d)
+
+	ctx := limitctx.WithLimitInfo(r.Context(), d.info)
+
+	if !d.info.Allowed {
+		setRetryAfter(w, d.info.RetryAfter)
+		m.denied.ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+	m.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// waitDecision waits for the tokens within the request context and returns the decision.
+// returns 'false' if the request context is canceled, e.g. the client disconnected
+func waitDecision(r *http.Request, t target) (d decision, ok bool, err error) {
+	err = t.do(func(tb *token_bucket.TokenBucket) {
+		d.draining = tb.IsDraining()
+	})
+	if err != nil || d.draining {
+		return d, true, err
+	}
+	waitErr := t.wait(r.Context())
+
+	if errors.Is(waitErr, context.Canceled) {
+		return d, false, nil
+	}
+	d.info.Allowed = waitErr == nil
+
+	err = t.do(func(tb *token_bucket.TokenBucket) {
+		d.info.Remaining = tb.Tokens()
+
+		if !d.info.Allowed {
+			d.info.RetryAfter = token_bucket.RetryAfter(tb, tb.Weight())
+		}
+		d.limit, d.resetT, d.resetIn = resetOf(tb)
+	})
+	return d, true, err
+}
+
+// resetOf returns capacity of the bucket, time the bucket is full again and duration until it
+func resetOf(tb *token_bucket.TokenBucket) (limit int, resetT time.Time, resetIn time.Duration) {
+	limit = tb.Capacity()
+
+	return limit, tb.NextAvailable(limit), tb.DelayN(limit)
+}
+
+// tooManyRequests replies with 429 Too Many Requests
+func tooManyRequests(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// setHeaders set rate limit headers of the configured style
+func (m *middleware) setHeaders(w http.ResponseWriter, d decision) {
+	if m.headers == HeadersNone {
+		return
+	}
+	limit, remaining, resetT := d.limit, d.info.Remaining, d.resetT
+
+	if remaining < 0 {
+		remaining = 0
+	}
+	prefix := "RateLimit-"
+
+	if m.headers == HeadersLegacy {
+		prefix = "X-RateLimit-"
+	}
+	h := w.Header()
+
+	h.Set(prefix+"Limit", strconv.Itoa(limit))
+	h.Set(prefix+"Remaining", strconv.Itoa(remaining))
+
+	if resetT.IsZero() {
+		return
+	}
+	if m.headers == HeadersLegacy {
+		h.Set(prefix+"Reset", strconv.FormatInt(resetT.Add(time.Second-1).Unix(), 10))
+		return
+	}
+	// the bucket clock may differ from the wall clock, e.g. in tests
+	secs := int(math.Ceil(d.resetIn.Seconds()))
+
+	h.Set(prefix+"Reset", strconv.Itoa(secs))
+}
+
+// setRetryAfter set Retry-After header in whole seconds,
+// nothing is set if the tokens can never be available
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d <= 0 || d == math.MaxInt64 {
+		return
+	}
+	secs := int(math.Ceil(d.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
@@ -0,0 +1,319 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+	"github.com/UshakovN/token-bucket/limitctx"
+)
+
+// snapshotStore keeps key buckets as snapshots, so buckets passed to 'fn' are copies like in external stores
+type snapshotStore struct {
+	snapshots map[string]token_bucket.Snapshot
+	options   []token_bucket.Option
+	err       error
+	lock      sync.Mutex
+}
+
+func newSnapshotStore(options ...token_bucket.Option) *snapshotStore {
+	return &snapshotStore{
+		snapshots: map[string]token_bucket.Snapshot{},
+		options:   options,
+	}
+}
+
+func (ss *snapshotStore) Do(key string, create func() *token_bucket.TokenBucket, fn func(tb *token_bucket.TokenBucket)) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.err != nil {
+		return ss.err
+	}
+	var tb *token_bucket.TokenBucket
+
+	if s, ok := ss.snapshots[key]; ok {
+		tb = token_bucket.NewTokenBucketFromSnapshot(s, ss.options...)
+	} else {
+		tb = create()
+	}
+	defer tb.Close()
+
+	fn(tb)
+	ss.snapshots[key] = tb.Snapshot()
+
+	return nil
+}
+
+func (ss *snapshotStore) Delete(key string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	delete(ss.snapshots, key)
+}
+
+func (ss *snapshotStore) Len() int {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	return len(ss.snapshots)
+}
+
+func (ss *snapshotStore) Evict(func(tb *token_bucket.TokenBucket) bool) {}
+
+func serve(h http.Handler, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Key", key)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func headerKey(r *http.Request) string {
+	return r.Header.Get("X-Key")
+}
+
+func TestMiddlewareKeyedExternalStore(t *testing.T) {
+	store := newSnapshotStore()
+	kl := token_bucket.NewKeyedLimiterStore[string](store, 2, 1,
+		token_bucket.SetBucketOptions(token_bucket.SetRefillDuration(time.Hour)))
+	defer kl.Close()
+
+	h := MiddlewareKeyed(kl, headerKey, SetRateLimitHeaders(HeadersDraft))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if w := serve(h, "a"); w.Code != want {
+			t.Fatalf("request %d of key 'a': got status %d, want %d", i, w.Code, want)
+		}
+	}
+	w := serve(h, "b")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("key 'b' must have independent limit: got status %d", w.Code)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "1" {
+		t.Fatalf("got remaining %q, want 1", got)
+	}
+}
+
+func TestMiddlewareKeyedStoreError(t *testing.T) {
+	store := newSnapshotStore()
+	store.err = errors.New("store is down")
+
+	kl := token_bucket.NewKeyedLimiterStore[string](store, 2, 1)
+	defer kl.Close()
+
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	handlers := map[string]http.Handler{
+		"keyed": MiddlewareKeyed(kl, headerKey)(next),
+		"ip":    MiddlewareIP(kl, IPOptions{})(next),
+	}
+	for name, h := range handlers {
+		if w := serve(h, "a"); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s: got status %d, want %d", name, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+	if called {
+		t.Fatal("next handler must not be called if the store fails")
+	}
+}
+
+func TestMiddlewareKeyedEmptyKey(t *testing.T) {
+	kl := token_bucket.NewKeyedLimiter[string](1, 1)
+	defer kl.Close()
+
+	h := MiddlewareKeyed(kl, headerKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 3; i++ {
+		if w := serve(h, ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d with empty key must not be limited: got status %d", i, w.Code)
+		}
+	}
+	if kl.Len() != 0 {
+		t.Fatalf("empty key must not create bucket: got %d buckets", kl.Len())
+	}
+}
+
+func TestMiddlewareWaitForTokensKeyed(t *testing.T) {
+	options := []token_bucket.Option{
+		token_bucket.SetRefillDuration(time.Hour),
+		token_bucket.SetMaxWait(time.Millisecond),
+	}
+	store := newSnapshotStore(options...)
+	kl := token_bucket.NewKeyedLimiterStore[string](store, 1, 1, token_bucket.SetBucketOptions(options...))
+	defer kl.Close()
+
+	h := MiddlewareKeyed(kl, headerKey, SetWaitForTokens(true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if w := serve(h, "a"); w.Code != http.StatusOK {
+		t.Fatalf("first request: got status %d", w.Code)
+	}
+	w := serve(h, "a")
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("tokens are not available within the request: got status %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("throttled response must have Retry-After")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	tb := token_bucket.NewTokenBucket(2, 1, token_bucket.SetClock(clock))
+	defer tb.Close()
+
+	called := 0
+	h := Middleware(tb)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ }))
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if w := serve(h, ""); w.Code != want {
+			t.Fatalf("request %d: got status %d, want %d", i, w.Code, want)
+		}
+	}
+	if called != 2 {
+		t.Fatalf("next handler must be called for allowed requests only: called %d times", called)
+	}
+	if got := serve(h, "").Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("got Retry-After %q, want 1", got)
+	}
+	clock.Advance(time.Second)
+
+	if w := serve(h, ""); w.Code != http.StatusOK {
+		t.Fatalf("refilled bucket: got status %d", w.Code)
+	}
+}
+
+func TestMiddlewareDeniedHandler(t *testing.T) {
+	tb := token_bucket.NewTokenBucket(1, 1, token_bucket.SetRefillDuration(time.Hour))
+	defer tb.Close()
+
+	denied := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := Middleware(tb, SetDeniedHandler(denied))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve(h, "")
+
+	if w := serve(h, ""); w.Code != http.StatusTeapot {
+		t.Fatalf("throttled request must be served by the denied handler: got status %d", w.Code)
+	}
+}
+
+func TestMiddlewareLimitInfo(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	tb := token_bucket.NewTokenBucket(2, 1, token_bucket.SetClock(clock))
+	defer tb.Close()
+
+	var got []limitctx.LimitInfo
+
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ := limitctx.LimitInfoFromContext(r.Context())
+		got = append(got, info)
+	})
+	h := Middleware(tb, SetDeniedHandler(record))(record)
+
+	for i := 0; i < 3; i++ {
+		serve(h, "")
+	}
+	want := []limitctx.LimitInfo{
+		{Allowed: true, Remaining: 1},
+		{Allowed: true, Remaining: 0},
+		{Allowed: false, Remaining: 0, RetryAfter: time.Second},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d decisions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("request %d: got limit info %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMiddlewareRateLimitHeaders(t *testing.T) {
+	tests := []struct {
+		style HeaderStyle
+		want  map[string]string
+	}{
+		{HeadersNone, map[string]string{"RateLimit-Limit": "", "X-RateLimit-Limit": ""}},
+		{HeadersLegacy, map[string]string{"X-RateLimit-Limit": "2", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1002"}},
+		{HeadersDraft, map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "0", "RateLimit-Reset": "2"}},
+	}
+	for _, tt := range tests {
+		clock := token_bucket.NewTestClock(time.Unix(1000, 0))
+
+		tb := token_bucket.NewTokenBucket(2, 1, token_bucket.SetClock(clock))
+		defer tb.Close()
+
+		h := Middleware(tb, SetRateLimitHeaders(tt.style))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		serve(h, "")
+		w := serve(h, "")
+
+		for header, want := range tt.want {
+			if got := w.Header().Get(header); got != want {
+				t.Fatalf("style %d: got %s %q, want %q", tt.style, header, got, want)
+			}
+		}
+	}
+}
+
+func TestMiddlewareDraining(t *testing.T) {
+	tb := token_bucket.NewTokenBucket(2, 1, token_bucket.SetClock(token_bucket.NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	tb.BeginDrain()
+
+	for name, h := range map[string]http.Handler{
+		"allow": Middleware(tb)(next),
+		"wait":  Middleware(tb, SetWaitForTokens(true))(next),
+	} {
+		if w := serve(h, ""); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s: got status %d, want %d", name, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+	if called {
+		t.Fatal("next handler must not be called while draining")
+	}
+}
+
+func TestMiddlewareWaitForTokens(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	tb := token_bucket.NewTokenBucket(1, 1, token_bucket.SetRefillDuration(20*time.Millisecond), token_bucket.SetClock(clock))
+	defer tb.Close()
+
+	called := 0
+	h := Middleware(tb, SetWaitForTokens(true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ }))
+
+	// the second request is paced until the refill 20ms later instead of rejected
+	for i := 0; i < 2; i++ {
+		if w := serve(h, ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	if called != 2 || w.Body.Len() != 0 {
+		t.Fatalf("disconnected client must get no response: called %d times, body %q", called, w.Body.String())
+	}
+}
@@ -0,0 +1,78 @@
+package httplimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// registryBucket
+//
+//	introspected state of the registered bucket
+//
+//	Fields:
+//
+//	[Name]         name of the bucket in the registry
+//	[MaxTokens]    maximum number of tokens in bucket
+//	[RefillRate]   number of tokens added in bucket per refill duration
+//	[RefillDur]    bucket refill duration like "1s"
+//	[TokenN]       weight for one request or operation
+//	[Tokens]       current token number in bucket
+//	[Allowed]      number of allowed requests or operations
+//	[Denied]       number of denied requests or operations
+type registryBucket struct {
+	Name       string `json:"name"`
+	MaxTokens  int    `json:"max_tokens"`
+	RefillRate int    `json:"refill_rate"`
+	RefillDur  string `json:"refill_dur"`
+	TokenN     int    `json:"token_n"`
+	Tokens     int    `json:"tokens"`
+	Allowed    int64  `json:"allowed"`
+	Denied     int64  `json:"denied"`
+}
+
+// registryState
+//
+//	introspected state of the registry
+//
+//	Fields:
+//
+//	[Buckets]   registered buckets ordered by name
+type registryState struct {
+	Buckets []registryBucket `json:"buckets"`
+}
+
+// RegistryHandler returns handler replying with JSON state of every registered bucket,
+// e.g. {"buckets": [{"name": "api", "max_tokens": 10, "refill_rate": 5, "tokens": 7, ...}]}.
+// the state is read on every request, so it reflects refills, suitable for /internal/ratelimits
+func RegistryHandler(reg *token_bucket.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		state := registryState{
+			Buckets: []registryBucket{},
+		}
+		reg.Range(func(name string, tb *token_bucket.TokenBucket) bool {
+			config := tb.Config()
+			stats := tb.Stats()
+
+			state.Buckets = append(state.Buckets, registryBucket{
+				Name:       name,
+				MaxTokens:  config.MaxTokens,
+				RefillRate: config.RefillRate,
+				RefillDur:  config.RefillDur.String(),
+				TokenN:     config.TokenN,
+				Tokens:     stats.Tokens,
+				Allowed:    stats.Allowed,
+				Denied:     stats.Denied,
+			})
+			return true
+		})
+		sort.Slice(state.Buckets, func(i, j int) bool {
+			return state.Buckets[i].Name < state.Buckets[j].Name
+		})
+		w.Header().Set("Content-Type", "application/json")
+
+		_ = json.NewEncoder(w).Encode(state)
+	})
+}
@@ -0,0 +1,73 @@
+package httplimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+func TestRegistryHandler(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	reg, err := token_bucket.NewRegistryFromConfig(strings.NewReader(`{"buckets": [
+		{"name": "search", "max_tokens": 2, "refill_rate": 1},
+		{"name": "api", "max_tokens": 10, "refill_rate": 5, "refill_dur": "500ms", "token_n": 2}
+	]}`), token_bucket.SetRegistryBucketOptions(token_bucket.SetClock(clock)))
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	search, _ := reg.Get("search")
+	search.AllowN(2)
+	search.Allow()
+
+	api, _ := reg.Get("api")
+	api.Allow()
+
+	h := RegistryHandler(reg)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/ratelimits", nil))
+
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("got content type %q", got)
+	}
+	var got registryState
+
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := registryState{Buckets: []registryBucket{
+		{Name: "api", MaxTokens: 10, RefillRate: 5, RefillDur: "500ms", TokenN: 2, Tokens: 8, Allowed: 1},
+		{Name: "search", MaxTokens: 2, RefillRate: 1, RefillDur: "1s", TokenN: 1, Tokens: 0, Allowed: 1, Denied: 1},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got state %+v, want %+v", got, want)
+	}
+	// the state is read on every request
+	clock.Advance(time.Second)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/ratelimits", nil))
+
+	if !strings.Contains(w.Body.String(), `"name":"search","max_tokens":2,"refill_rate":1,"refill_dur":"1s","token_n":1,"tokens":1`) {
+		t.Fatalf("got body %s, want refilled search bucket", w.Body.String())
+	}
+}
+
+func TestRegistryHandlerEmpty(t *testing.T) {
+	reg, err := token_bucket.NewRegistry(token_bucket.RegistryConfig{})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	w := httptest.NewRecorder()
+	RegistryHandler(reg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := strings.TrimSpace(w.Body.String()); got != `{"buckets":[]}` {
+		t.Fatalf("got body %s, want empty list", got)
+	}
+}
@@ -0,0 +1,46 @@
+package httplimit
+
+import (
+	"net/http"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// Transport
+//
+//	implement http.RoundTripper which waits for a token before every outbound request
+//
+//	Fields:
+//
+//	[tb]     bucket consumed by every request
+//	[base]   transport issuing the requests
+type Transport struct {
+	tb   *token_bucket.TokenBucket
+	base http.RoundTripper
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+// NewTransport returns new Transport entity instance.
+// http.DefaultTransport is used if 'base' is nil
+func NewTransport(tb *token_bucket.TokenBucket, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		tb:   tb,
+		base: base,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+// blocks until a token is available or the request context is done
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.tb.Wait(req.Context()); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,71 @@
+package httplimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// roundTripFunc adapts function to http.RoundTripper
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// closeBody records closing of the request body
+type closeBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	tb := token_bucket.NewTokenBucket(1, 1,
+		token_bucket.SetRefillDuration(time.Hour),
+		token_bucket.SetClock(token_bucket.NewTestClock(time.Unix(0, 0))),
+	)
+	defer tb.Close()
+
+	sent := 0
+	client := &http.Client{
+		Transport: NewTransport(tb, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			sent++
+			return httptest.NewRecorder().Result(), nil
+		})),
+	}
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	resp.Body.Close()
+
+	// the next token is an hour away, so the request deadline is too short to wait
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	body := &closeBody{Reader: strings.NewReader("payload")}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://example.com/", body)
+
+	if _, err := NewTransport(tb, nil).RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if !body.closed {
+		t.Fatal("body of the request which is not sent must be closed")
+	}
+	if sent != 1 {
+		t.Fatalf("got %d sent requests, want 1", sent)
+	}
+}
@@ -0,0 +1,25 @@
+package token_bucket
+
+import "context"
+
+// Limiter is implemented by rate limiters which can be used instead of TokenBucket
+type Limiter interface {
+	// Allow returns 'true' if one request or operation is allowed
+	Allow() bool
+	// AllowN returns 'true' if 'n' tokens are allowed
+	AllowN(n int) bool
+}
+
+// WaitLimiter is implemented by rate limiters which can block until tokens are available
+type WaitLimiter interface {
+	Limiter
+	// Wait blocks until one request or operation is allowed
+	Wait(ctx context.Context) error
+	// WaitN blocks until 'n' tokens are allowed
+	WaitN(ctx context.Context, n int) error
+}
+
+var (
+	_ Limiter     = (*TokenBucket)(nil)
+	_ WaitLimiter = (*TokenBucket)(nil)
+)
@@ -0,0 +1,50 @@
+package token_bucket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// exhaust consumes tokens of the limiter through Limiter interface only
+func exhaust(l Limiter, n int) int {
+	allowed := 0
+
+	for i := 0; i < n; i++ {
+		if l.Allow() {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestLimiterSwappable(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	limiters := map[string]Limiter{
+		"token bucket":   NewTokenBucket(3, 1, SetClock(clock)),
+		"atomic bucket":  NewAtomicTokenBucket(3, 1, SetClock(clock)),
+		"sliding window": NewSlidingWindowLimiter(3, time.Second, SetClock(clock)),
+		"fixed window":   NewFixedWindowLimiter(3, time.Second, SetClock(clock)),
+		"multi limiter":  NewMultiLimiter(NewTokenBucket(3, 1, SetClock(clock)), NewTokenBucket(5, 1, SetClock(clock))),
+	}
+	for name, l := range limiters {
+		if got := exhaust(l, 5); got != 3 {
+			t.Fatalf("%s: allowed %d of 5 requests, want 3", name, got)
+		}
+	}
+}
+
+func TestWaitLimiter(t *testing.T) {
+	var l WaitLimiter = NewTokenBucket(1, 1, SetRefillDuration(20*time.Millisecond))
+
+	if !l.Allow() {
+		t.Fatal("full bucket denied")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := l.Wait(ctx); err != nil {
+		t.Fatalf("wait through WaitLimiter: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package token_bucket
+
+import "time"
+
+// SetMinInterval set floor of the refill duration guarding against misconfigured ultra-fast refills,
+// e.g. SetRefillDuration(time.Nanosecond). shorter refill durations set by options or by SetRefillDurationNow
+// are clamped to the floor with "clamp" event logged, the refill rate stays per clamped duration.
+// there is no floor by default
+func SetMinInterval(d time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.minInterval = d
+	}
+}
+
+// clampInterval returns 'dur' clamped to the minimum refill interval and logs the clamping.
+// must be called under the lock
+func (tb *TokenBucket) clampInterval(dur time.Duration) time.Duration {
+	if tb.minInterval <= 0 || dur >= tb.minInterval {
+		return dur
+	}
+	if tb.logger != nil {
+		l, fields := tb.logger, map[string]any{
+			"bucket":    tb.name,
+			"requested": dur,
+			"clamped":   tb.minInterval,
+		}
+		tb.later(func() {
+			l.Log("clamp", fields)
+		})
+	}
+	return tb.minInterval
+}
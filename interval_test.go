@@ -0,0 +1,148 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetMinInterval(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	var events []map[string]any
+
+	logger := LoggerFunc(func(event string, fields map[string]any) {
+		if event == "clamp" {
+			events = append(events, fields)
+		}
+	})
+	tb := NewTokenBucket(10, 1, SetClock(clock), SetRefillDuration(time.Nanosecond),
+		SetMinInterval(100*time.Millisecond), SetLogger(logger))
+	defer tb.Close()
+
+	if got := tb.RefillDuration(); got != 100*time.Millisecond {
+		t.Fatalf("got refill duration %s, want the 100ms floor", got)
+	}
+	if len(events) != 1 || events[0]["requested"] != time.Nanosecond || events[0]["clamped"] != 100*time.Millisecond {
+		t.Fatalf("got clamp events %v, want one from 1ns to 100ms", events)
+	}
+	// the refill rate stays per clamped duration
+	tb.AllowN(10)
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 10 {
+		t.Fatalf("got %d tokens, want 10 refilled by one per 100ms", got)
+	}
+	if err := tb.SetRefillDurationNow(time.Millisecond); err != nil {
+		t.Fatalf("set refill duration: %v", err)
+	}
+	if got := tb.RefillDuration(); got != 100*time.Millisecond {
+		t.Fatalf("got refill duration %s, want the floor at runtime", got)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d clamp events, want 2", len(events))
+	}
+	// durations over the floor are kept and not logged
+	if err := tb.SetRefillDurationNow(time.Second); err != nil {
+		t.Fatalf("set refill duration: %v", err)
+	}
+	if got := tb.RefillDuration(); got != time.Second {
+		t.Fatalf("got refill duration %s, want 1s", got)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d clamp events, want no event over the floor", len(events))
+	}
+	clone := tb.Clone()
+	defer clone.Close()
+
+	if err := clone.SetRefillDurationNow(time.Nanosecond); err != nil {
+		t.Fatalf("set refill duration: %v", err)
+	}
+	if got := clone.RefillDuration(); got != 100*time.Millisecond {
+		t.Fatalf("got clone refill duration %s, want the floor kept by clone", got)
+	}
+}
+
+func TestSetMinIntervalDisabled(t *testing.T) {
+	tb := NewTokenBucket(10, 1, SetClock(NewTestClock(time.Unix(0, 0))), SetRefillDuration(time.Millisecond))
+	defer tb.Close()
+
+	if got := tb.RefillDuration(); got != time.Millisecond {
+		t.Fatalf("got refill duration %s, want no floor by default", got)
+	}
+}
+
+func TestSetMinIntervalRuntimePaths(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	short := NewTokenBucket(10, 1, SetClock(clock), SetRefillDuration(time.Nanosecond))
+	defer short.Close()
+
+	state, err := short.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	paths := map[string]func(tb *TokenBucket) error{
+		"SetRateNow": func(tb *TokenBucket) error {
+			return tb.SetRateNow(1, time.Nanosecond, false)
+		},
+		"SetRefillDurationNow": func(tb *TokenBucket) error {
+			return tb.SetRefillDurationNow(time.Nanosecond)
+		},
+		"UnmarshalJSON": func(tb *TokenBucket) error {
+			data, err := short.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			return tb.UnmarshalJSON(data)
+		},
+		"UnmarshalBinary": func(tb *TokenBucket) error {
+			return tb.UnmarshalBinary(state)
+		},
+		"Registry.Reload": func(tb *TokenBucket) error {
+			reg, err := NewRegistry(RegistryConfig{})
+			if err != nil {
+				return err
+			}
+			reg.Register("api", tb)
+
+			return reg.Reload(RegistryConfig{Buckets: []BucketConfig{
+				{Name: "api", MaxTokens: 10, RefillRate: 1, RefillDur: "1ns"},
+			}}, false)
+		},
+	}
+	for name, change := range paths {
+		tb := NewTokenBucket(10, 1, SetClock(clock), SetMinInterval(time.Second))
+
+		if err := change(tb); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := tb.RefillDuration(); got != time.Second {
+			t.Fatalf("%s: got refill duration %s, want the 1s floor", name, got)
+		}
+		tb.Close()
+	}
+}
+
+func TestSetMinIntervalRegistry(t *testing.T) {
+	reg, err := NewRegistry(RegistryConfig{Buckets: []BucketConfig{
+		{Name: "api", MaxTokens: 10, RefillRate: 1, RefillDur: "1ns"},
+	}}, SetClock(NewTestClock(time.Unix(0, 0))), SetMinInterval(time.Second))
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	api, _ := reg.Get("api")
+	defer api.Close()
+
+	if got := api.RefillDuration(); got != time.Second {
+		t.Fatalf("got refill duration %s, want the 1s floor of the registry options", got)
+	}
+	err = reg.Reload(RegistryConfig{Buckets: []BucketConfig{
+		{Name: "api", MaxTokens: 10, RefillRate: 1, RefillDur: "1ms"},
+	}}, false)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := api.RefillDuration(); got != time.Second {
+		t.Fatalf("got refill duration %s, want the floor kept by reload", got)
+	}
+}
@@ -0,0 +1,61 @@
+package token_bucket
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// jitterRand
+//
+//	package source of refill jitter
+//
+//	Fields:
+//
+//	[rnd]    random source
+//	[lock]   mutex for atomic operations, rand.Rand is not safe for concurrent use
+var jitterRand = struct {
+	rnd  *rand.Rand
+	lock sync.Mutex
+}{
+	rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+}
+
+// SeedJitter set seed of the package refill jitter source for deterministic runs
+func SeedJitter(seed int64) {
+	jitterRand.lock.Lock()
+	defer jitterRand.lock.Unlock()
+
+	jitterRand.rnd.Seed(seed)
+}
+
+// SetRefillJitter set maximum random delay of every refill to desynchronize buckets.
+// tokens are still credited per nominal refill duration, so the long-run rate is unaffected
+func SetRefillJitter(maxJitter time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.maxJitter = maxJitter
+	}
+}
+
+// SetRandSource set random source of the refill jitter for deterministic runs of the bucket.
+// the source is used under the bucket lock, so it must not be shared with other buckets,
+// clones of the bucket draw the jitter from the package source
+func SetRandSource(r *rand.Rand) Option {
+	return func(tb *TokenBucket) {
+		tb.randSrc = r
+	}
+}
+
+// jitter returns random delay in [0, maxJitter)
+func (tb *TokenBucket) jitter() time.Duration {
+	if tb.maxJitter <= 0 {
+		return 0
+	}
+	if tb.randSrc != nil {
+		return time.Duration(tb.randSrc.Int63n(int64(tb.maxJitter)))
+	}
+	jitterRand.lock.Lock()
+	defer jitterRand.lock.Unlock()
+
+	return time.Duration(jitterRand.rnd.Int63n(int64(tb.maxJitter)))
+}
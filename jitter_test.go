@@ -0,0 +1,66 @@
+package token_bucket
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestRefillJitter(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(100, 1,
+		SetRefillJitter(500*time.Millisecond),
+		SetRandSource(rand.New(rand.NewSource(1))),
+		SetClock(clock),
+	)
+	defer tb.Close()
+
+	tb.AllowN(100)
+
+	for i := 0; i < 10; i++ {
+		tb.lock.Lock()
+		delay := tb.refillT.Sub(tb.lastFillT)
+		tb.unlock()
+
+		if delay < time.Second || delay >= 1500*time.Millisecond {
+			t.Fatalf("refill %d: delay %s out of [1s, 1.5s)", i, delay)
+		}
+		clock.Advance(delay)
+		tb.Tokens()
+	}
+	// tokens are credited per nominal refill duration, so the jitter does not slow the rate
+	clock.Set(time.Unix(20, 0).Add(500 * time.Millisecond))
+
+	if got := tb.Tokens(); got != 20 {
+		t.Fatalf("got %d tokens in 20.5s, want 20", got)
+	}
+}
+
+func TestSetRandSourceDeterministic(t *testing.T) {
+	delays := func() []time.Duration {
+		tb := NewTokenBucket(1, 1,
+			SetRefillJitter(time.Second),
+			SetRandSource(rand.New(rand.NewSource(42))),
+			SetClock(NewTestClock(time.Unix(0, 0))),
+		)
+		defer tb.Close()
+
+		var ds []time.Duration
+
+		for i := 0; i < 5; i++ {
+			ds = append(ds, tb.jitter())
+		}
+		return ds
+	}
+	first, second := delays(), delays()
+
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("jitter %d differs for the same seed: %s and %s", i, first[i], second[i])
+		}
+	}
+	if (&TokenBucket{}).jitter() != 0 {
+		t.Fatal("bucket without jitter must not delay refills")
+	}
+}
@@ -0,0 +1,319 @@
+package token_bucket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyedLimiter
+//
+//	implement independent token buckets per key, e.g. user ID, client IP
+//	or structured key like {tenant, route}
+//
+//	Fields:
+//
+//	[maxTokens]    maximum number of tokens in every key bucket
+//	[refillRate]   number of tokens added in every key bucket per refill duration
+//	[capacityFn]   returns parameters of the key bucket, overrides 'maxTokens' and 'refillRate'
+//	[store]        storage of key buckets
+//	[pool]         evicted buckets reused for new keys
+//	[done]         closed to stop the idle buckets sweeper
+//	[closeOnce]    guard for closing
+//	[keyedConfig]  limiter options
+type KeyedLimiter[K comparable] struct {
+	maxTokens  int
+	refillRate int
+	capacityFn CapacityFunc[K]
+	store      Store[K]
+	pool       sync.Pool
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	keyedConfig
+}
+
+// keyedConfig
+//
+//	options of KeyedLimiter
+//
+//	For Options:
+//
+//	[options] options applied to every key bucket. default: none
+//	[idleTTL] idle duration after which a full key bucket is evicted. default: never
+//	[sweepDur] idle buckets sweep interval. default: idle TTL
+//	[shardsN] number of independently locked shards of MemoryStore. default: 16
+//	[reuse] reuse buckets evicted by the idle TTL for new keys. default: false
+type keyedConfig struct {
+	options  []Option
+	idleTTL  time.Duration
+	sweepDur time.Duration
+	shardsN  int
+	reuse    bool
+}
+
+// NewKeyedLimiter returns new KeyedLimiter entity instance keeping key buckets in MemoryStore
+func NewKeyedLimiter[K comparable](maxTokens, refillRate int, options ...KeyedOption) *KeyedLimiter[K] {
+	return NewKeyedLimiterStore[K](nil, maxTokens, refillRate, options...)
+}
+
+// NewKeyedLimiterStore returns new KeyedLimiter entity instance keeping key buckets in the store.
+// MemoryStore is used if the store is nil
+func NewKeyedLimiterStore[K comparable](store Store[K], maxTokens, refillRate int, options ...KeyedOption) *KeyedLimiter[K] {
+	kl := &KeyedLimiter[K]{
+		maxTokens:  maxTokens,
+		refillRate: refillRate,
+		store:      store,
+		done:       make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt(&kl.keyedConfig)
+	}
+	if kl.store == nil {
+		kl.store = NewMemoryStoreShards[K](kl.shardsN)
+	}
+
+	if kl.idleTTL > 0 {
+		if kl.sweepDur <= 0 {
+			kl.sweepDur = kl.idleTTL
+		}
+		go kl.sweeper()
+	}
+
+	return kl
+}
+
+// CapacityFunc returns parameters of the key bucket, e.g. by tenant tier
+type CapacityFunc[K comparable] func(key K) (maxTokens, refillRate int)
+
+// NewKeyedLimiterFunc returns new KeyedLimiter entity instance
+// which key buckets are created with parameters returned by 'fn' when the key is first seen
+func NewKeyedLimiterFunc[K comparable](fn CapacityFunc[K], options ...KeyedOption) *KeyedLimiter[K] {
+	kl := NewKeyedLimiter[K](0, 0, options...)
+	kl.capacityFn = fn
+
+	return kl
+}
+
+// KeyedOption for KeyedLimiter entity
+type KeyedOption func(*keyedConfig)
+
+// SetBucketOptions set options applied to every key bucket
+func SetBucketOptions(options ...Option) KeyedOption {
+	return func(c *keyedConfig) {
+		c.options = append(c.options, options...)
+	}
+}
+
+// SetIdleTTL set idle duration after which a full key bucket is evicted
+func SetIdleTTL(ttl time.Duration) KeyedOption {
+	return func(c *keyedConfig) {
+		c.idleTTL = ttl
+	}
+}
+
+// SetShards set number of independently locked shards of MemoryStore.
+// more shards reduce lock contention between keys under high concurrency
+func SetShards(n int) KeyedOption {
+	return func(c *keyedConfig) {
+		c.shardsN = n
+	}
+}
+
+// SetBucketReuse set reusing of buckets evicted by the idle TTL for new keys,
+// which reduces allocations for many short-lived keys. reused buckets are fully reinitialized,
+// so buckets returned by Bucket must not be kept after the key is idle for the TTL.
+// buckets with background refilling, C channel or subscribers are never reused
+func SetBucketReuse(reuse bool) KeyedOption {
+	return func(c *keyedConfig) {
+		c.reuse = reuse
+	}
+}
+
+// SetSweepInterval set idle buckets sweep interval
+func SetSweepInterval(dur time.Duration) KeyedOption {
+	return func(c *keyedConfig) {
+		c.sweepDur = dur
+	}
+}
+
+// bucketErr runs 'fn' with the key bucket
+func (kl *KeyedLimiter[K]) bucketErr(key K, fn func(tb *TokenBucket)) error {
+	return kl.store.Do(key, func() *TokenBucket {
+		return kl.newBucket(key)
+	}, fn)
+}
+
+// newBucket returns new token bucket for the key
+func (kl *KeyedLimiter[K]) newBucket(key K) *TokenBucket {
+	maxTokens, refillRate := kl.maxTokens, kl.refillRate
+
+	if kl.capacityFn != nil {
+		maxTokens, refillRate = kl.capacityFn(key)
+	}
+	// the key is the default name, the bucket options may override it
+	options := append([]Option{SetName(fmt.Sprint(key))}, kl.options...)
+
+	if kl.reuse {
+		if tb, ok := kl.pool.Get().(*TokenBucket); ok {
+			tb.init(maxTokens, refillRate, options)
+			tb.clampConfig()
+
+			return tb
+		}
+	}
+	return NewTokenBucket(maxTokens, refillRate, options...)
+}
+
+// Bucket returns token bucket for the key, creating it on first use.
+// the bucket of external store is a copy, its changes are not saved, use Do to consume from it.
+// returns nil if the store fails
+func (kl *KeyedLimiter[K]) Bucket(key K) *TokenBucket {
+	var bucket *TokenBucket
+
+	if err := kl.bucketErr(key, func(tb *TokenBucket) { bucket = tb }); err != nil {
+		return nil
+	}
+	return bucket
+}
+
+// Do runs 'fn' with the key bucket inside the store, creating the bucket on first use,
+// so changes of the bucket are saved by external store as well. 'fn' must not keep the bucket.
+// returns error of the store
+func (kl *KeyedLimiter[K]) Do(key K, fn func(tb *TokenBucket)) error {
+	return kl.bucketErr(key, fn)
+}
+
+// Allow returns 'true' if there are enough tokens in the key bucket
+func (kl *KeyedLimiter[K]) Allow(key K) bool {
+	ok, err := kl.allowNErr(key, useWeight)
+	return ok && err == nil
+}
+
+// AllowN return 'true' if there are 'n' tokens in the key bucket, negative 'n' is always denied.
+// returns 'false' if the store fails, so the limiter fails closed
+func (kl *KeyedLimiter[K]) AllowN(key K, n int) bool {
+	ok, err := kl.AllowNErr(key, n)
+	return ok && err == nil
+}
+
+// AllowNErr works as AllowN and also returns error of the store
+func (kl *KeyedLimiter[K]) AllowNErr(key K, n int) (bool, error) {
+	if n < 0 {
+		return false, nil
+	}
+	return kl.allowNErr(key, n)
+}
+
+// allowNErr consumes 'n' tokens or the bucket weight if 'n' is useWeight from the key bucket
+func (kl *KeyedLimiter[K]) allowNErr(key K, n int) (bool, error) {
+	var allowed bool
+
+	err := kl.bucketErr(key, func(tb *TokenBucket) {
+		allowed = tb.AllowN(weightOf(tb, n))
+	})
+	return allowed, err
+}
+
+// Wait blocks until weight of one operation is available in the key bucket and consumes it
+func (kl *KeyedLimiter[K]) Wait(ctx context.Context, key K) error {
+	return kl.waitN(ctx, key, useWeight)
+}
+
+// WaitN blocks until 'n' tokens are available in the key bucket and consumes them,
+// returns ErrNegativeTokens for negative 'n'.
+// the tokens are reserved in the store and the wait happens outside of it,
+// so waiters of a saturated key never block other keys. works as TokenBucket.WaitN,
+// but the tokens of canceled wait are returned only to buckets of MemoryStore
+func (kl *KeyedLimiter[K]) WaitN(ctx context.Context, key K, n int) error {
+	if n < 0 {
+		return ErrNegativeTokens
+	}
+	return kl.waitN(ctx, key, n)
+}
+
+// waitN waits for 'n' tokens or the bucket weight if 'n' is useWeight in the key bucket
+func (kl *KeyedLimiter[K]) waitN(ctx context.Context, key K, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var (
+		r       *Reservation
+		turn    *waitTurn
+		waitErr error
+		hook    func(ctx context.Context, waited time.Duration)
+	)
+	err := kl.bucketErr(key, func(tb *TokenBucket) {
+		r, turn, waitErr = tb.reserveWait(ctx, weightOf(tb, n))
+		hook = tb.traceHook
+	})
+	if err != nil {
+		return err
+	}
+	var waited time.Duration
+
+	if waitErr == nil {
+		waited, waitErr = waitReserved(ctx, r, turn)
+	}
+	if hook != nil {
+		hook(ctx, waited)
+	}
+	return waitErr
+}
+
+// Range calls 'fn' for every key bucket until it returns 'false', e.g. to list throttled keys.
+// iterates snapshot of the keys, so 'fn' may call the limiter, see MemoryStore.Range.
+// does nothing if the store does not implement RangeStore
+func (kl *KeyedLimiter[K]) Range(fn func(key K, tb *TokenBucket) bool) {
+	if rs, ok := kl.store.(RangeStore[K]); ok {
+		rs.Range(fn)
+	}
+}
+
+// Forget removes the key bucket, the next use of the key starts with a new bucket
+func (kl *KeyedLimiter[K]) Forget(key K) {
+	kl.store.Delete(key)
+}
+
+// Len returns number of key buckets
+func (kl *KeyedLimiter[K]) Len() int {
+	return kl.store.Len()
+}
+
+// Close stops the idle buckets sweeper
+func (kl *KeyedLimiter[K]) Close() {
+	kl.closeOnce.Do(func() {
+		close(kl.done)
+	})
+}
+
+// sweeper evicts idle buckets every 'sweepDur' until the limiter is closed
+func (kl *KeyedLimiter[K]) sweeper() {
+	ticker := time.NewTicker(kl.sweepDur)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kl.done:
+			return
+		case <-ticker.C:
+			kl.sweep()
+		}
+	}
+}
+
+// sweep evicts full buckets which were not refilled for 'idleTTL'.
+// evicted buckets are put in the pool if the reuse is set
+func (kl *KeyedLimiter[K]) sweep() {
+	kl.store.Evict(func(tb *TokenBucket) bool {
+		if !tb.idle(kl.idleTTL) {
+			return false
+		}
+		if kl.reuse && tb.reusable() {
+			kl.pool.Put(tb)
+		}
+		return true
+	})
+}
@@ -0,0 +1,509 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiterDo(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[string](2, 1, SetBucketOptions(SetClock(clock)))
+	defer kl.Close()
+
+	for i, want := range []bool{true, true, false} {
+		var allowed bool
+
+		if err := kl.Do("a", func(tb *TokenBucket) { allowed = tb.Allow() }); err != nil {
+			t.Fatalf("do: %v", err)
+		}
+		if allowed != want {
+			t.Fatalf("request %d: got allowed %v, want %v", i, allowed, want)
+		}
+	}
+	if !kl.Allow("b") {
+		t.Fatal("key 'b' must have independent bucket")
+	}
+	clock.Advance(time.Second)
+
+	if !kl.Allow("a") {
+		t.Fatal("key 'a' must be refilled")
+	}
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[string](2, 1, SetBucketOptions(SetClock(clock)))
+	defer kl.Close()
+
+	if !kl.AllowN("a", 2) || kl.Allow("a") {
+		t.Fatal("key 'a' must have two tokens")
+	}
+	if !kl.AllowN("b", 2) {
+		t.Fatal("key 'b' must not share tokens with key 'a'")
+	}
+	if got := kl.Len(); got != 2 {
+		t.Fatalf("got %d key buckets, want 2", got)
+	}
+	kl.Forget("a")
+
+	if got := kl.Len(); got != 1 {
+		t.Fatalf("got %d key buckets after forget, want 1", got)
+	}
+	if !kl.AllowN("a", 2) {
+		t.Fatal("forgotten key must start with new full bucket")
+	}
+}
+
+func TestKeyedLimiterFunc(t *testing.T) {
+	kl := NewKeyedLimiterFunc(func(key string) (int, int) {
+		if key == "premium" {
+			return 10, 10
+		}
+		return 1, 1
+	})
+	defer kl.Close()
+
+	if !kl.AllowN("premium", 10) {
+		t.Fatal("premium key must have ten tokens")
+	}
+	if kl.AllowN("free", 2) {
+		t.Fatal("free key must have one token")
+	}
+}
+
+func TestKeyedLimiterIdleEviction(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	// the sweeper never ticks during the test, the sweep is run by hand
+	kl := NewKeyedLimiter[string](2, 1,
+		SetBucketOptions(SetClock(clock)),
+		SetIdleTTL(time.Minute),
+		SetSweepInterval(time.Hour),
+	)
+	defer kl.Close()
+
+	kl.Allow("idle")
+	kl.AllowN("busy", 2)
+
+	clock.Advance(30 * time.Second)
+	kl.AllowN("busy", 2)
+	clock.Advance(31 * time.Second)
+	kl.sweep()
+
+	if got := kl.Len(); got != 1 {
+		t.Fatalf("idle key must be evicted: got %d key buckets, want 1", got)
+	}
+	kl.Range(func(key string, tb *TokenBucket) bool {
+		if key != "busy" {
+			t.Fatalf("key %q must be evicted", key)
+		}
+		return true
+	})
+	clock.Advance(time.Minute)
+	kl.sweep()
+
+	if got := kl.Len(); got != 0 {
+		t.Fatalf("refilled key must be evicted: got %d key buckets, want 0", got)
+	}
+}
+
+func TestKeyedLimiterIdleEvictionNotFull(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[string](100, 1,
+		SetBucketOptions(SetClock(clock)),
+		SetIdleTTL(time.Second),
+		SetSweepInterval(time.Hour),
+	)
+	defer kl.Close()
+
+	kl.AllowN("a", 100)
+	clock.Advance(2 * time.Second)
+	kl.sweep()
+
+	// evicting the bucket which is not refilled yet would hand out a new full bucket
+	if got := kl.Len(); got != 1 {
+		t.Fatalf("not full bucket must be kept: got %d key buckets, want 1", got)
+	}
+}
+
+// routeKey is structured key of the generic limiter
+type routeKey struct {
+	tenant string
+	route  string
+}
+
+func TestKeyedLimiterStructKeyConcurrent(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[routeKey](50, 1, SetBucketOptions(SetClock(clock)), SetShards(4))
+	defer kl.Close()
+
+	const (
+		workers = 16
+		keysN   = 8
+		calls   = 500
+	)
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		allowed = map[routeKey]int{}
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			local := map[routeKey]int{}
+
+			for i := 0; i < calls; i++ {
+				key := routeKey{tenant: fmt.Sprint("t", (w+i)%keysN), route: "/api"}
+
+				if kl.Allow(key) {
+					local[key]++
+				}
+				if i%50 == 0 {
+					kl.Range(func(routeKey, *TokenBucket) bool { return true })
+					kl.Len()
+				}
+			}
+			lock.Lock()
+			defer lock.Unlock()
+
+			for key, n := range local {
+				allowed[key] += n
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if len(allowed) != keysN {
+		t.Fatalf("got %d keys, want %d", len(allowed), keysN)
+	}
+	for key, n := range allowed {
+		if n != 50 {
+			t.Fatalf("key %+v: allowed %d, want exactly the capacity 50", key, n)
+		}
+	}
+}
+
+func BenchmarkKeyedLimiter(b *testing.B) {
+	b.Run("string", func(b *testing.B) {
+		keys := make([]string, 1024)
+
+		for i := range keys {
+			keys[i] = fmt.Sprint("t", i)
+		}
+		benchmarkKeyedLimiter(b, keys)
+	})
+	// struct keys are hashed by their formatting, which allocates
+	b.Run("struct", func(b *testing.B) {
+		keys := make([]routeKey, 1024)
+
+		for i := range keys {
+			keys[i] = routeKey{tenant: fmt.Sprint("t", i), route: "/api"}
+		}
+		benchmarkKeyedLimiter(b, keys)
+	})
+}
+
+func benchmarkKeyedLimiter[K comparable](b *testing.B, keys []K) {
+	kl := NewKeyedLimiter[K](1<<30, 1, SetBucketOptions(SetRefillDuration(time.Hour)))
+	defer kl.Close()
+
+	for _, key := range keys {
+		kl.Allow(key)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+
+		for pb.Next() {
+			kl.Allow(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func TestKeyedLimiterFuncPerKeyRate(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	calls := map[string]int{}
+
+	kl := NewKeyedLimiterFunc(func(key string) (int, int) {
+		calls[key]++
+
+		if key == "premium" {
+			return 10, 5
+		}
+		return 10, 1
+	}, SetBucketOptions(SetClock(clock)))
+	defer kl.Close()
+
+	kl.AllowN("premium", 10)
+	kl.AllowN("free", 10)
+	clock.Advance(time.Second)
+
+	if !kl.AllowN("premium", 5) || kl.AllowN("free", 2) {
+		t.Fatal("keys must be refilled at their own rates")
+	}
+	if calls["premium"] != 1 || calls["free"] != 1 {
+		t.Fatalf("capacity must be computed once when the key is first seen: got calls %v", calls)
+	}
+}
+
+func TestKeyedLimiterBucketName(t *testing.T) {
+	kl := NewKeyedLimiter[int](1, 1)
+	defer kl.Close()
+
+	if got := kl.Bucket(42).Name(); got != "42" {
+		t.Fatalf("key bucket must be named by the key: got %q", got)
+	}
+	named := NewKeyedLimiter[int](1, 1, SetBucketOptions(SetName("tenant")))
+	defer named.Close()
+
+	if got := named.Bucket(42).Name(); got != "tenant" {
+		t.Fatalf("bucket options must override the key name: got %q", got)
+	}
+}
+
+func TestKeyedLimiterBucketReuse(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiterFunc(func(key string) (int, int) {
+		if key == "a" {
+			return 2, 1
+		}
+		return 5, 1
+	}, SetBucketOptions(SetClock(clock)), SetIdleTTL(time.Minute), SetSweepInterval(time.Hour), SetBucketReuse(true))
+	defer kl.Close()
+
+	kl.AllowN("a", 2)
+	kl.Allow("a")
+
+	clock.Advance(2 * time.Minute)
+	kl.sweep()
+
+	// the bucket of "b" may be the evicted bucket of "a", it is reinitialized either way
+	tb := kl.Bucket("b")
+
+	if s := tb.Stats(); s.Allowed != 0 || s.Denied != 0 || s.Tokens != 5 || s.Name != "b" {
+		t.Fatalf("reused bucket must be fresh: got stats %+v", s)
+	}
+	if got := tb.Capacity(); got != 5 {
+		t.Fatalf("got capacity %d, want 5 of the new key", got)
+	}
+	subscribed := kl.Bucket("c")
+	subscribed.Subscribe()
+
+	if subscribed.reusable() {
+		t.Fatal("bucket with subscribers must not be reusable")
+	}
+	clock.Advance(2 * time.Minute)
+	kl.sweep()
+
+	for i := 0; i < 10; i++ {
+		if kl.Bucket(fmt.Sprint("d", i)) == subscribed {
+			t.Fatal("bucket with subscribers reused")
+		}
+	}
+}
+
+func TestKeyedLimiterBucketReuseConcurrent(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[string](1, 1,
+		SetBucketOptions(SetClock(clock)),
+		SetIdleTTL(time.Second),
+		SetSweepInterval(time.Hour),
+		SetBucketReuse(true),
+	)
+	defer kl.Close()
+
+	done := make(chan struct{})
+	swept := make(chan struct{})
+
+	go func() {
+		defer close(swept)
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			clock.Advance(time.Second)
+			kl.sweep()
+		}
+	}()
+	var wg sync.WaitGroup
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			// every key is used once, so its bucket must be full whether it is new or reused
+			for i := 0; i < 500; i++ {
+				if !kl.Allow(fmt.Sprint(g, "-", i)) {
+					t.Errorf("fresh key %d-%d denied", g, i)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(done)
+	<-swept
+}
+
+func BenchmarkKeyedLimiterReuse(b *testing.B) {
+	for _, reuse := range []bool{false, true} {
+		b.Run(fmt.Sprint("reuse-", reuse), func(b *testing.B) {
+			clock := NewTestClock(time.Unix(0, 0))
+
+			kl := NewKeyedLimiter[int](1, 1,
+				SetBucketOptions(SetClock(clock)),
+				SetIdleTTL(time.Second),
+				SetSweepInterval(time.Hour),
+				SetBucketReuse(reuse),
+			)
+			defer kl.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			// short-lived keys evicted every 64 keys
+			for i := 0; i < b.N; i++ {
+				kl.Allow(i)
+
+				if i%64 == 63 {
+					clock.Advance(time.Second)
+					kl.sweep()
+				}
+			}
+		})
+	}
+}
+
+func TestKeyedLimiterWait(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[string](1, 1, SetBucketOptions(SetClock(clock), SetRefillDuration(time.Hour)))
+	defer kl.Close()
+
+	kl.Allow("a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error, 1)
+
+	go func() {
+		waited <- kl.Wait(ctx, "a")
+	}()
+	for storedTokens(kl.Bucket("a")) >= 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// the waiter of the saturated key does not block other keys
+	if err := kl.Wait(context.Background(), "b"); err != nil {
+		t.Fatalf("wait of other key: %v", err)
+	}
+	cancel()
+
+	if err := <-waited; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	clock.Advance(time.Hour)
+
+	if got := kl.Bucket("a").Tokens(); got != 1 {
+		t.Fatalf("canceled wait must return the token: got %d, want 1", got)
+	}
+	if err := kl.WaitN(ctx, "a", 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled without reserving", err)
+	}
+	if err := kl.WaitN(context.Background(), "a", 2); !errors.Is(err, ErrExceedsMaxTokens) {
+		t.Fatalf("got error %v, want ErrExceedsMaxTokens", err)
+	}
+}
+
+// storeOnly hides all methods of the store except Store ones
+type storeOnly struct {
+	Store[string]
+}
+
+func TestKeyedLimiterRange(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[string](2, 1, SetBucketOptions(SetClock(clock)))
+	defer kl.Close()
+
+	kl.AllowN("a", 2)
+	kl.Allow("b")
+	kl.Allow("c")
+
+	throttled := map[string]bool{}
+
+	// the callback uses the limiter, since the keys are iterated without the store locks
+	kl.Range(func(key string, tb *TokenBucket) bool {
+		if !kl.Allow(key) {
+			throttled[key] = true
+		}
+		kl.Allow("new")
+		return true
+	})
+	if len(throttled) != 1 || !throttled["a"] {
+		t.Fatalf("got throttled keys %v, want only 'a'", throttled)
+	}
+	visited := 0
+
+	kl.Range(func(string, *TokenBucket) bool {
+		visited++
+		return visited < 2
+	})
+	if visited != 2 {
+		t.Fatalf("got %d visited keys, want iteration stopped at 2", visited)
+	}
+	plain := NewKeyedLimiterStore[string](storeOnly{NewMemoryStore[string]()}, 1, 1)
+	defer plain.Close()
+
+	plain.Allow("a")
+
+	plain.Range(func(string, *TokenBucket) bool {
+		t.Fatal("store without Range must not be iterated")
+		return true
+	})
+}
+
+func TestKeyedLimiterNegativeN(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[string](4, 1, SetBucketOptions(SetClock(clock), SetTokenN(2)))
+	defer kl.Close()
+
+	if kl.AllowN("a", -1) {
+		t.Fatal("negative tokens number allowed")
+	}
+	if ok, err := kl.AllowNErr("a", -1); ok || err != nil {
+		t.Fatalf("got %t, %v, want denied without error", ok, err)
+	}
+	if err := kl.WaitN(context.Background(), "a", -1); !errors.Is(err, ErrNegativeTokens) {
+		t.Fatalf("got error %v, want ErrNegativeTokens", err)
+	}
+	// Allow and Wait still consume the bucket weight
+	if !kl.Allow("a") {
+		t.Fatal("request within tokens denied")
+	}
+	if err := kl.Wait(context.Background(), "a"); err != nil {
+		t.Fatalf("wait within tokens: %v", err)
+	}
+	if kl.Allow("a") {
+		t.Fatal("weights of two requests must drain the 4 tokens")
+	}
+}
@@ -0,0 +1,183 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull returned when the leaky bucket or PolicyLimiter queue has no room for the request
+var ErrQueueFull = errors.New("token_bucket: queue is full")
+
+// LeakyBucket
+//
+//	implement the leaky bucket algorithm as a queue.
+//	admitted tokens leave the queue one by one at a fixed rate,
+//	so unlike TokenBucket it does not release a burst after idle:
+//	callers of Wait are paced evenly, Allow only admits tokens into the queue
+//
+//	Fields:
+//
+//	[capacity]   maximum number of tokens queued ahead of a new request
+//	[interval]   duration for one token to leave the queue, zero if the queue never drains
+//	[emptyT]     time at which all queued tokens leave the queue
+//	[lock]       mutex for atomic operations
+//
+//	For Options:
+//
+//	[tokenN] weight for one request or operation. default: 1
+//	[refillDur] duration per which 'leakRate' tokens leave the queue. default: 1 second
+//	[clock] source of current time. default: wall clock with monotonic reading
+type LeakyBucket struct {
+	capacity int64
+	interval time.Duration
+	emptyT   time.Time
+	lock     sync.Mutex
+
+	tokenN int
+	clock  Clock
+}
+
+// NewLeakyBucket returns new LeakyBucket entity instance.
+// options not related to the queue draining are ignored
+func NewLeakyBucket(capacity, leakRate int, options ...Option) *LeakyBucket {
+	cfg := NewTokenBucket(capacity, leakRate, options...)
+
+	lb := &LeakyBucket{
+		capacity: int64(capacity),
+		tokenN:   cfg.Weight(),
+		clock:    cfg.clock,
+	}
+	cfg.Close()
+
+	if leakRate > 0 {
+		lb.interval = cfg.refillDur / time.Duration(leakRate)
+	}
+	lb.emptyT = lb.clock.Now()
+
+	return lb
+}
+
+// reserveN queues 'n' tokens and returns duration until they start leaving the queue
+// and time at which they left it.
+// returns 'false' if there are more than 'capacity' tokens queued ahead,
+// 'n' is not positive or more than 'capacity', at least one token, so it can never be admitted
+func (lb *LeakyBucket) reserveN(n int) (time.Duration, time.Time, bool) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	if lb.interval <= 0 || n <= 0 || int64(n) > lb.capacity && n > 1 {
+		return 0, time.Time{}, false
+	}
+	nowT := lb.clock.Now()
+	startT := lb.emptyT
+
+	if startT.Before(nowT) {
+		startT = nowT
+	}
+	delay := startT.Sub(nowT)
+
+	if delay > time.Duration(lb.capacity)*lb.interval {
+		return 0, time.Time{}, false
+	}
+	lb.emptyT = startT.Add(time.Duration(n) * lb.interval)
+
+	return delay, lb.emptyT, true
+}
+
+// cancelN removes 'n' tokens of abandoned wait leaving the queue at 'endT'.
+// the tokens are removed only if they are the last queued ones,
+// otherwise later requests are already scheduled after them
+func (lb *LeakyBucket) cancelN(n int, endT time.Time) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	if lb.emptyT.Equal(endT) {
+		lb.emptyT = endT.Add(-time.Duration(n) * lb.interval)
+	}
+}
+
+// AllowN return 'true' if 'n' tokens are admitted into the queue
+func (lb *LeakyBucket) AllowN(n int) bool {
+	_, _, ok := lb.reserveN(n)
+	return ok
+}
+
+// Allow returns 'true' if one request or operation is admitted into the queue
+func (lb *LeakyBucket) Allow() bool {
+	return lb.AllowN(lb.tokenN)
+}
+
+// WaitN admits 'n' tokens into the queue and blocks until they leave it.
+// returns ErrNegativeTokens if 'n' is negative and ErrQueueFull if the queue has no room for the tokens.
+// the tokens of wait canceled by the context leave the queue if no request is queued after them
+func (lb *LeakyBucket) WaitN(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if n < 0 {
+		return ErrNegativeTokens
+	}
+	delay, endT, ok := lb.reserveN(n)
+	if !ok {
+		return ErrQueueFull
+	}
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		lb.cancelN(n, endT)
+		return ctx.Err()
+	}
+}
+
+// Wait admits one request or operation into the queue and blocks until it leaves the queue
+func (lb *LeakyBucket) Wait(ctx context.Context) error {
+	return lb.WaitN(ctx, lb.tokenN)
+}
+
+var _ WaitLimiter = (*LeakyBucket)(nil)
+
+// EquivalentLeakyParams returns parameters of LeakyBucket with the same steady-state behavior
+// as TokenBucket of 'maxTokens' and 'refillRate' per 'refillDur':
+//
+//	leakRate = refillRate * 1s / refillDur, rounded to the nearest integer, at least 1 if 'refillRate' is positive
+//	capacity = maxTokens - 1, since the leaky bucket admits 'capacity' tokens queued ahead plus the leaving one
+//
+// 'leakRate' is per second, the default refill duration of LeakyBucket.
+// the sustained rate is equal, but TokenBucket releases the burst at once
+// while LeakyBucket releases it evenly, one token per 1/leakRate
+func EquivalentLeakyParams(maxTokens, refillRate int, refillDur time.Duration) (capacity int, leakRate int) {
+	capacity = maxTokens - 1
+
+	if capacity < 0 {
+		capacity = 0
+	}
+	if refillRate <= 0 || refillDur <= 0 {
+		return capacity, 0
+	}
+	leakRate = int(math.Round(float64(refillRate) * float64(time.Second) / float64(refillDur)))
+
+	if leakRate < 1 {
+		leakRate = 1
+	}
+	return capacity, leakRate
+}
+
+// EquivalentTokenParams returns parameters of TokenBucket with one second refill duration
+// with the same steady-state behavior as LeakyBucket of 'capacity' and 'leakRate' per second,
+// inverse of EquivalentLeakyParams:
+//
+//	maxTokens  = capacity + 1
+//	refillRate = leakRate
+func EquivalentTokenParams(capacity, leakRate int) (maxTokens, refillRate int) {
+	return capacity + 1, leakRate
+}
@@ -0,0 +1,125 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// doneCtx is context canceled after its error is checked once,
+// so WaitN reserves the tokens and then sees the cancellation
+type doneCtx struct {
+	context.Context
+	done   chan struct{}
+	checks int
+}
+
+func newDoneCtx() *doneCtx {
+	done := make(chan struct{})
+	close(done)
+
+	return &doneCtx{Context: context.Background(), done: done}
+}
+
+func (ctx *doneCtx) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *doneCtx) Err() error {
+	if ctx.checks++; ctx.checks == 1 {
+		return nil
+	}
+	return context.Canceled
+}
+
+func TestLeakyBucketAllowN(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	lb := NewLeakyBucket(2, 1, SetClock(clock))
+
+	for i, want := range []bool{true, true, true, false} {
+		if got := lb.Allow(); got != want {
+			t.Fatalf("request %d: got %v, want %v", i, got, want)
+		}
+	}
+	clock.Advance(time.Second)
+
+	if !lb.Allow() {
+		t.Fatal("one token must leave the queue per second")
+	}
+}
+
+func TestLeakyBucketInvalidN(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	lb := NewLeakyBucket(2, 1, SetClock(clock))
+
+	for _, n := range []int{-1, 0, 3} {
+		if lb.AllowN(n) {
+			t.Fatalf("n %d must be denied", n)
+		}
+	}
+	if err := lb.WaitN(context.Background(), -1); !errors.Is(err, ErrNegativeTokens) {
+		t.Fatalf("got error %v, want ErrNegativeTokens", err)
+	}
+	if !lb.emptyT.Equal(clock.Now()) {
+		t.Fatalf("denied requests must not change the queue: empty at %v", lb.emptyT)
+	}
+	if !NewLeakyBucket(0, 1, SetClock(clock)).Allow() {
+		t.Fatal("single token must be admitted into empty queue of zero capacity")
+	}
+}
+
+func TestLeakyBucketWaitCanceled(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	lb := NewLeakyBucket(2, 1, SetClock(clock))
+
+	lb.Allow()
+
+	if err := lb.WaitN(newDoneCtx(), 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if want := clock.Now().Add(time.Second); !lb.emptyT.Equal(want) {
+		t.Fatalf("canceled wait must leave the queue: empty at %v, want %v", lb.emptyT, want)
+	}
+}
+
+func TestLeakyBucketWaitCanceledNotLast(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	lb := NewLeakyBucket(3, 1, SetClock(clock))
+
+	lb.Allow()
+
+	_, endT, _ := lb.reserveN(1)
+	lb.Allow()
+	lb.cancelN(1, endT)
+
+	if want := clock.Now().Add(3 * time.Second); !lb.emptyT.Equal(want) {
+		t.Fatalf("tokens queued before others must stay: empty at %v, want %v", lb.emptyT, want)
+	}
+}
+
+func TestEquivalentParams(t *testing.T) {
+	tests := []struct {
+		maxTokens, refillRate int
+		refillDur             time.Duration
+		capacity, leakRate    int
+	}{
+		{10, 5, time.Second, 9, 5},
+		{10, 1, 100 * time.Millisecond, 9, 10},
+		{3, 1, time.Minute, 2, 1},
+		{5, 2, 3 * time.Second, 4, 1},
+		{1, 0, time.Second, 0, 0},
+		{0, 1, time.Second, 0, 1},
+	}
+	for _, tt := range tests {
+		capacity, leakRate := EquivalentLeakyParams(tt.maxTokens, tt.refillRate, tt.refillDur)
+
+		if capacity != tt.capacity || leakRate != tt.leakRate {
+			t.Fatalf("EquivalentLeakyParams(%d, %d, %s): got %d and %d, want %d and %d",
+				tt.maxTokens, tt.refillRate, tt.refillDur, capacity, leakRate, tt.capacity, tt.leakRate)
+		}
+	}
+	if maxTokens, refillRate := EquivalentTokenParams(EquivalentLeakyParams(10, 5, time.Second)); maxTokens != 10 || refillRate != 5 {
+		t.Fatalf("round trip: got %d and %d, want 10 and 5", maxTokens, refillRate)
+	}
+}
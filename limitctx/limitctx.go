@@ -0,0 +1,36 @@
+package limitctx
+
+import (
+	"context"
+	"time"
+)
+
+// LimitInfo
+//
+//	rate limiter decision for the request
+//
+//	Fields:
+//
+//	[Allowed]      the request is allowed
+//	[Remaining]    tokens remaining in the bucket after the decision
+//	[RetryAfter]   duration until the request could be allowed, zero if allowed
+type LimitInfo struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// infoKey is context key of LimitInfo
+type infoKey struct{}
+
+// WithLimitInfo returns copy of the context carrying the limiter decision
+func WithLimitInfo(ctx context.Context, info LimitInfo) context.Context {
+	return context.WithValue(ctx, infoKey{}, info)
+}
+
+// LimitInfoFromContext returns the limiter decision carried by the context
+// and 'true' if it is set
+func LimitInfoFromContext(ctx context.Context) (LimitInfo, bool) {
+	info, ok := ctx.Value(infoKey{}).(LimitInfo)
+	return info, ok
+}
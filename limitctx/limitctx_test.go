@@ -0,0 +1,25 @@
+package limitctx
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimitInfoFromContext(t *testing.T) {
+	if _, ok := LimitInfoFromContext(context.Background()); ok {
+		t.Fatal("limit info found in empty context")
+	}
+	want := LimitInfo{
+		Allowed:    false,
+		Remaining:  0,
+		RetryAfter: time.Second,
+	}
+	ctx := WithLimitInfo(context.Background(), want)
+
+	got, ok := LimitInfoFromContext(context.WithValue(ctx, struct{}{}, "other"))
+
+	if !ok || got != want {
+		t.Fatalf("got limit info %+v, %t, want %+v", got, ok, want)
+	}
+}
@@ -1,8 +1,12 @@
 package token_bucket
 
 import (
+	"context"
+	"fmt"
 	"math"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,113 +17,1190 @@ const (
 
 // TokenBucket
 //
-//	implement the token bucket algorithm
+//	implement the token bucket algorithm.
+//	the bucket must not be copied after creation, go vet reports copies, use Clone
 //
 //	Fields:
 //
+//	[noCopy]        marker for go vet copylocks check
 //	[refillRate]    number of tokens to be added in bucket per refill duration
 //	[maxTokens]     maximum number of tokens in bucket
-//	[currTokens]    current token number in bucket
+//	[currTokens]    current token number in bucket, negative when taken in advance by waiters
 //	[lastFillT]     time of the last refilling of the bucket
 //	[refillT]       time for bucket refilling
+//	[partTokens]    fractional part of token accumulated in continuous refill mode
 //	[lock]          mutex for atomic operations
+//	[allowedN]      number of allowed requests or operations
+//	[deniedN]       number of denied requests or operations
+//	[consumedN]     number of tokens consumed by allowed requests or operations
+//	[pending]       callbacks to run after the lock is released
+//	[lastTurn]      closed when the last FIFO waiter returns from Wait
+//	[done]          closed to stop the bucket goroutines
+//	[closeOnce]     guard for closing
+//	[paused]        deny consumption and stop refilling while set
+//	[pausedT]       time of pausing the bucket
+//	[tokensC]       channel emitting every consumed token, see C
+//	[tokensOnce]    guard for starting the tokens channel
+//	[pressureC]     channel receivable while the bucket has spare tokens, see BackpressureChan
+//	[pressureOnce]  guard for starting the backpressure channel
+//	[warmupT]       start time of the warmup
+//	[subs]          channels of refill events subscribers
+//	[closed]        the bucket is closed
+//	[draining]      deny consumption forever during shutdown
+//	[rateSlots]     tokens consumed, allowed and denied counts per slot of the rate window, ring buffer
+//	[rateSlotI]     index of the current rate slot
+//	[rateSlotT]     start time of the current rate slot
+//	[reservations]  reservations which may expire, see SetReservationGrace
+//	[wouldDenyN]    number of requests or operations allowed only by the dry run mode
+//	[lateMax]       maximum lateness of interval refills behind their scheduled time
+//	[lateSum]       total lateness of interval refills
+//	[lateN]         number of interval refills
+//	[wake]          single timer waking waiters of Wait
+//	[streakN]       number of consecutive denials since the last allowed request or operation
+//	[streakMax]     maximum number of consecutive denials
+//	[early]         the first refill interval is credited proportionally, see SetProportionalFirstInterval
+//	[earlyN]        tokens credited proportionally in the first refill interval
 //
 //	For Options:
 //
 //	[tokenN] weight for one request or operation. default: 1
 //	[refillDur] bucket refill duration. default: 1 second
+//	[clock] source of current time. default: wall clock with monotonic reading
+//	[continuous] credit tokens proportionally to elapsed time instead of per interval. default: false
+//	[background] refill the bucket by ticker in addition to refilling on access. default: false
+//	[maxJitter] maximum random delay of every refill. default: none
+//	[randSrc] random source of the refill jitter. default: package source seeded from time
+//	[onThrottle] callback for denied consumption. default: none
+//	[onRefill] callback for tokens credited by refilling. default: none
+//	[fifo] return from Wait in order of arrival. default: false
+//	[maxWait] maximum duration Wait may block. default: none
+//	[name] label identifying the bucket in logs and metrics. default: empty
+//	[logger] logger of refill and deny events. default: none
+//	[costFn] estimator of request weight used by AllowRequest. default: none
+//	[warmup] duration during which the effective capacity grows up to 'maxTokens'. default: none
+//	[strictFloor] drop fractional token in continuous refill mode instead of carrying it. default: false
+//	[reserve] tokens which can be consumed only by AllowReserved. default: 0
+//	[rateWindow] recent window of EffectiveRate. default: none, the rate is not tracked
+//	[reserveGrace] expiry of unused reservations after their time. default: none
+//	[dryRun] allow requests over the limit counting them as would-deny, see SetDryRun. default: false
+//	[traceHook] callback with duration spent in Wait. default: none
+//	[oversize] handling of requests over 'maxTokens'. default: OversizeReject
+//	[softLimit] ratio of 'maxTokens' below which consumption calls 'onSoftLimit'. default: none
+//	[onSoftLimit] callback for consumption crossing the soft limit. default: none
+//	[minInterval] floor of the refill duration, see SetMinInterval. default: none
+//	[firstProp] credit the first refill interval proportionally to the elapsed time. default: false
+//	[lateStats] record lateness of interval refills, see SetLatenessStats. default: false
 type TokenBucket struct {
-	refillRate int
-	maxTokens  int
-	currTokens int
-	lastFillT  time.Time
-	refillT    time.Time
-	lock       sync.Mutex
+	noCopy       noCopy
+	refillRate   int64
+	maxTokens    int64
+	currTokens   int64
+	lastFillT    time.Time
+	refillT      time.Time
+	partTokens   float64
+	lock         sync.Mutex
+	allowedN     int64
+	deniedN      int64
+	consumedN    int64
+	pending      []func()
+	lastTurn     chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
+	paused       bool
+	pausedT      time.Time
+	tokensC      chan struct{}
+	tokensOnce   sync.Once
+	pressureC    chan struct{}
+	pressureOnce sync.Once
+	warmupT      time.Time
+	subs         []chan RefillEvent
+	closed       bool
+	draining     bool
+	rateSlots    []rateSlot
+	rateSlotI    int
+	rateSlotT    time.Time
+	reservations []*Reservation
+	wouldDenyN   int64
+	lateMax      time.Duration
+	lateSum      time.Duration
+	lateN        int64
+	wake         waker
+	streakN      int64
+	streakMax    int64
+	early        bool
+	earlyN       int64
 
-	tokenN    int
-	refillDur time.Duration
+	tokenN       atomic.Int64
+	refillDur    time.Duration
+	clock        Clock
+	continuous   bool
+	background   bool
+	maxJitter    time.Duration
+	randSrc      *rand.Rand
+	onThrottle   func(requested, available int)
+	onRefill     func(added, current int)
+	fifo         bool
+	maxWait      time.Duration
+	name         string
+	logger       Logger
+	costFn       func(req any) int
+	warmup       time.Duration
+	strictFloor  bool
+	reserve      int64
+	rateWindow   time.Duration
+	reserveGrace time.Duration
+	dryRun       bool
+	traceHook    func(ctx context.Context, waited time.Duration)
+	oversize     OversizePolicy
+	softLimit    float64
+	onSoftLimit  func(current, max int)
+	minInterval  time.Duration
+	firstProp    bool
+	lateStats    bool
 }
 
-// NewTokenBucket returns new TokenBucket entity instance
+// noCopy
+//
+//	marker making go vet report copies of the struct containing it,
+//	kept explicit so the check does not depend on the bucket mutex type
+type noCopy struct{}
+
+// Lock is used by go vet copylocks check
+func (*noCopy) Lock() {}
+
+// Unlock is used by go vet copylocks check
+func (*noCopy) Unlock() {}
+
+// NewTokenBucket returns new TokenBucket entity instance.
+// refill intervals are counted from the creation, so a bucket emptied right after creation
+// gets the first refill 'refillDur' after the creation, or proportionally earlier with
+// SetProportionalFirstInterval, and a bucket emptied later waits only the rest of the current interval.
+// zero 'refillRate' makes the bucket one-time quota of 'maxTokens' which is replenished only by Reset.
+// non-positive weight set by SetTokenN is replaced by the default weight of 1
+// and non-positive refill duration by the default 1 second, NewTokenBucketChecked returns error instead
 func NewTokenBucket(maxTokens, refillRate int, options ...Option) *TokenBucket {
-	tb := &TokenBucket{
-		refillRate: refillRate,
-		maxTokens:  maxTokens,
-		currTokens: maxTokens,
-		lastFillT:  nowT(),
+	tb := &TokenBucket{}
+	tb.init(maxTokens, refillRate, options)
+	tb.clampConfig()
+
+	return tb
+}
+
+// clampConfig replaces non-positive weight for one request or operation and non-positive
+// refill duration by the default ones, otherwise Allow consumes nothing or adds tokens
+// and refilling divides by zero
+func (tb *TokenBucket) clampConfig() {
+	if tb.tokenN.Load() <= 0 {
+		tb.tokenN.Store(defaultTokensN)
+	}
+	if tb.refillDur <= 0 {
+		tb.refillDur = refillDuration
+		tb.refillT = tb.nextT()
+	}
+}
+
+// init reinitializes the whole bucket state and configuration as NewTokenBucket does.
+// the bucket must not be used by anyone else
+func (tb *TokenBucket) init(maxTokens, refillRate int, options []Option) {
+	*tb = TokenBucket{
+		refillRate: int64(refillRate),
+		maxTokens:  int64(maxTokens),
+		currTokens: int64(maxTokens),
 
-		tokenN:    defaultTokensN,
 		refillDur: refillDuration,
+		clock:     realClock{},
 	}
+	tb.tokenN.Store(defaultTokensN)
+	tb.done = make(chan struct{})
 
 	for _, opt := range options {
 		opt(tb)
 	}
+	tb.lock.Lock()
+	tb.refillDur = tb.clampInterval(tb.refillDur)
+	tb.unlock()
 
+	tb.lastFillT = tb.now()
 	tb.refillT = tb.nextT()
+	tb.startWarmup()
+	tb.startFirstInterval()
 
-	return tb
+	if tb.background {
+		go tb.refiller()
+	}
+}
+
+// NewPerSecond returns new TokenBucket entity instance allowing 'rate' tokens per second
+// with burst equal to the rate
+func NewPerSecond(rate int, options ...Option) *TokenBucket {
+	return NewTokenBucket(rate, rate, append([]Option{SetRefillDuration(time.Second)}, options...)...)
+}
+
+// NewPerMinute returns new TokenBucket entity instance allowing 'rate' tokens per minute
+// with burst equal to the rate
+func NewPerMinute(rate int, options ...Option) *TokenBucket {
+	return NewTokenBucket(rate, rate, append([]Option{SetRefillDuration(time.Minute)}, options...)...)
+}
+
+// NewTokenBucketChecked returns new TokenBucket entity instance
+// or error if the parameters are invalid
+func NewTokenBucketChecked(maxTokens, refillRate int, options ...Option) (*TokenBucket, error) {
+	tb := &TokenBucket{}
+	tb.init(maxTokens, refillRate, options)
+
+	if err := tb.validate(); err != nil {
+		tb.Close()
+		return nil, err
+	}
+	return tb, nil
+}
+
+// validate returns error if the bucket parameters are invalid
+func (tb *TokenBucket) validate() error {
+	if tb.maxTokens <= 0 {
+		return fmt.Errorf("token_bucket: max tokens must be positive, got %d", tb.maxTokens)
+	}
+	if tb.refillRate < 0 {
+		return fmt.Errorf("token_bucket: refill rate must not be negative, got %d", tb.refillRate)
+	}
+	if tb.refillDur <= 0 {
+		return fmt.Errorf("token_bucket: refill duration must be positive, got %s", tb.refillDur)
+	}
+	if n := tb.tokenN.Load(); n <= 0 {
+		return fmt.Errorf("token_bucket: token weight must be positive, got %d", n)
+	}
+	return nil
 }
 
 // Option for TokenBucket entity
 type Option func(*TokenBucket)
 
-// SetRefillDuration set refill duration
+// SetRefillDuration set refill duration, it must be positive, see NewTokenBucket.
+// durations shorter than the clock resolution, e.g. ~15ms ticks on Windows, keep the average rate:
+// time elapsed past the last whole interval is carried to the next refilling instead of being dropped
 func SetRefillDuration(dur time.Duration) Option {
 	return func(tb *TokenBucket) {
 		tb.refillDur = dur
 	}
 }
 
-// SetTokenN set weight for one request or operation
+// SetTokenN set default weight for one request or operation used by Allow, Wait and Reserve.
+// the weight must be at least 1, default is 1. use AllowCost or AllowN for requests with variable cost
+// and AllowOne to consume literally one token
 func SetTokenN(n int) Option {
 	return func(tb *TokenBucket) {
-		tb.tokenN = n
+		tb.tokenN.Store(int64(n))
+	}
+}
+
+// SetClock set source of current time, any type with Now() time.Time method fits
+func SetClock(c Clock) Option {
+	return func(tb *TokenBucket) {
+		tb.clock = c
+	}
+}
+
+// SetCapacity set maximum number of tokens in bucket, the new bucket is full
+func SetCapacity(n int) Option {
+	return func(tb *TokenBucket) {
+		tb.maxTokens = int64(n)
+		tb.currTokens = int64(n)
+	}
+}
+
+// SetInitialTokens set number of tokens in the new bucket, clamped to [0, maxTokens].
+// default: maxTokens
+func SetInitialTokens(n int) Option {
+	return func(tb *TokenBucket) {
+		tb.currTokens = int64(n)
+
+		if tb.currTokens < 0 {
+			tb.currTokens = 0
+		}
+		if tb.currTokens > tb.maxTokens {
+			tb.currTokens = tb.maxTokens
+		}
+	}
+}
+
+// SetBurst set number of tokens in the new bucket which may exceed 'maxTokens',
+// allowing one large burst. refill still never fills the bucket over 'maxTokens',
+// so the surplus is only consumed and, when 'b' is below 'maxTokens',
+// the bucket starts with 'b' tokens and is refilled up to 'maxTokens'
+func SetBurst(b int) Option {
+	return func(tb *TokenBucket) {
+		if b >= 0 {
+			tb.currTokens = int64(b)
+		}
+	}
+}
+
+// SetContinuousRefill set crediting tokens proportionally to the time elapsed
+// since the last filling instead of once per refill duration
+func SetContinuousRefill(continuous bool) Option {
+	return func(tb *TokenBucket) {
+		tb.continuous = continuous
+	}
+}
+
+// SetStrictFloor set dropping fractional token on every continuous refilling.
+// by default the fraction is carried to the next refilling, so over many intervals
+// the delivered tokens match the nominal rate exactly, e.g. 10 tokens per 3 seconds.
+// strict floor under-delivers, and never refills if the bucket is accessed
+// more often than one token is accumulated
+func SetStrictFloor(strict bool) Option {
+	return func(tb *TokenBucket) {
+		tb.strictFloor = strict
+	}
+}
+
+// SetReserve set number of tokens kept in reserve for critical requests:
+// AllowN and other consuming methods deny if fewer than 'r' tokens would be left,
+// so they deny while the bucket has 'r' or fewer tokens even though tokens exist,
+// only AllowReserved can consume them. Wait and Reserve are not limited by the reserve.
+// the bucket is still refilled up to 'maxTokens'
+func SetReserve(r int) Option {
+	return func(tb *TokenBucket) {
+		tb.reserve = int64(r)
+	}
+}
+
+// SetBackgroundRefill set refilling the bucket by ticker every refill duration,
+// so the bucket is filled even without any access. stopped by Close
+func SetBackgroundRefill(background bool) Option {
+	return func(tb *TokenBucket) {
+		tb.background = background
+	}
+}
+
+// SetOnThrottle set callback called every time consumption of tokens is denied
+// with requested and available tokens number. the callback is called after the bucket lock
+// is released, so it may use the bucket, but it blocks the denied caller until it returns
+func SetOnThrottle(fn func(requested, available int)) Option {
+	return func(tb *TokenBucket) {
+		tb.onThrottle = fn
+	}
+}
+
+// SetOnRefill set callback called every time refilling credits tokens, never with zero added tokens,
+// e.g. to retry queued work when capacity frees up. the callback is called after the bucket lock
+// is released, so it may use the bucket, but it blocks the caller which triggered the refilling
+func SetOnRefill(fn func(added, current int)) Option {
+	return func(tb *TokenBucket) {
+		tb.onRefill = fn
+	}
+}
+
+// SetFIFOWait set returning from Wait strictly in order of arrival.
+// tokens are always granted to waiters in order of arrival, since every waiter
+// takes its tokens in advance and Allow can not consume tokens owed to waiters,
+// this option also orders waiters granted in the same refill
+func SetFIFOWait(fifo bool) Option {
+	return func(tb *TokenBucket) {
+		tb.fifo = fifo
+	}
+}
+
+// SetMaxWait set maximum duration Wait may block,
+// Wait returns ErrWouldBlock immediately if the tokens are available later
+func SetMaxWait(d time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.maxWait = d
+	}
+}
+
+// SetCostEstimator set estimator of request weight used by AllowRequest, e.g. by number of items in the payload
+func SetCostEstimator(fn func(req any) int) Option {
+	return func(tb *TokenBucket) {
+		tb.costFn = fn
+	}
+}
+
+// SetName set label identifying the bucket in logs and metrics, it does not affect limiting
+func SetName(name string) Option {
+	return func(tb *TokenBucket) {
+		tb.name = name
 	}
 }
 
-// nowT returns current time in UTC
+// Name returns label of the bucket set by SetName
+func (tb *TokenBucket) Name() string {
+	return tb.name
+}
+
+// Weight returns tokens number consumed by one request or operation set by SetTokenN.
+// the weight is read without the lock, so it is safe while SetWeight changes it on the running bucket
+func (tb *TokenBucket) Weight() int {
+	return int(tb.tokenN.Load())
+}
+
+// SetWeight set tokens number consumed by one request or operation like SetTokenN on the running bucket.
+// non-positive weight is replaced by the default weight of 1
+func (tb *TokenBucket) SetWeight(n int) *TokenBucket {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	if n <= 0 {
+		n = defaultTokensN
+	}
+	tb.tokenN.Store(int64(n))
+
+	return tb
+}
+
+// nowT returns current time.
+// the monotonic clock reading is kept, so wall clock steps do not break refilling
 func nowT() time.Time {
-	return time.Now().UTC()
+	return time.Now()
+}
+
+// now returns current time of the bucket clock
+func (tb *TokenBucket) now() time.Time {
+	return tb.clock.Now()
 }
 
-// nextT returns next filling time
+// nextT returns next filling time delayed by the refill jitter
 func (tb *TokenBucket) nextT() time.Time {
-	return tb.lastFillT.Add(tb.refillDur)
+	return tb.lastFillT.Add(tb.refillDur + tb.jitter())
 }
 
-// refill fill the bucket if the 'refillDur' interval is reached
+// refill fill the bucket for every 'refillDur' interval elapsed since the last filling.
+// the clock is read once, elapsed intervals and the new filling time are derived from the reading
 func (tb *TokenBucket) refill() {
-	nowT := nowT()
+	if tb.paused {
+		return
+	}
+	tb.refillTo(tb.now())
+}
+
+// refillTo fill the bucket for the clock reading 'nowT', see refill
+func (tb *TokenBucket) refillTo(nowT time.Time) {
+	// clock went backward: elapsed time is zero, refilling continues from now
+	if nowT.Before(tb.lastFillT) {
+		tb.lastFillT = nowT
+		tb.refillT = tb.nextT()
+		return
+	}
+	if len(tb.reservations) > 0 {
+		tb.expireReservations(nowT)
+	}
+	tb.refillAt(nowT)
+}
 
-	if tb.refillT.Unix() <= nowT.Unix() {
+// refillDue returns 'true' if refilling at the clock reading 'nowT' may change the bucket state:
+// the refill interval elapsed, reservations may expire, the clock went backward or the refill is continuous.
+// quota mode only tracks the time, which is done by every refill before the rate is changed
+func (tb *TokenBucket) refillDue(nowT time.Time) bool {
+	if tb.paused {
+		return false
+	}
+	if tb.continuous || tb.early || len(tb.reservations) > 0 || nowT.Before(tb.lastFillT) {
+		return true
+	}
+	return tb.refillRate > 0 && !nowT.Before(tb.refillT)
+}
+
+// refillAt fill the bucket as if current time is 'nowT'.
+// time before the last filling credits nothing.
+// interval refilling uses integer arithmetic only and returns after one time comparison
+// until the next refill is due, floats are used only in continuous refill mode
+func (tb *TokenBucket) refillAt(nowT time.Time) {
+	if tb.paused {
+		return
+	}
+	// quota mode credits nothing, the time is tracked only
+	// to avoid crediting the quota period if the rate is changed later
+	if tb.refillRate <= 0 {
+		if nowT.After(tb.lastFillT) {
+			tb.lastFillT = nowT
+			tb.refillT = tb.nextT()
+		}
+		return
+	}
+	if tb.continuous {
+		tb.refillContinuous(nowT)
+		return
+	}
+	if !nowT.Before(tb.refillT) {
+		intervals := int64(nowT.Sub(tb.lastFillT) / tb.refillDur)
 
-		filling := float64(tb.currTokens + tb.refillRate)
-		max := float64(tb.maxTokens)
+		if intervals <= 0 {
+			return
+		}
+		if tb.lateStats {
+			tb.late(nowT.Sub(tb.refillT))
+		}
 
-		tb.currTokens = int(math.Min(filling, max))
+		prevTokens := tb.currTokens
+		tb.currTokens = fill(tb.currTokens, tb.endFirstInterval(tb.credit(intervals)), tb.capacityAt(nowT))
+		tb.refilled(tb.currTokens-prevTokens, nowT)
 
-		tb.lastFillT = nowT
+		tb.lastFillT = tb.lastFillT.Add(time.Duration(intervals) * tb.refillDur)
 		tb.refillT = tb.nextT()
+		return
+	}
+	if tb.early {
+		tb.refillEarly(nowT)
+	}
+}
+
+// RefillNow credits tokens due since the last filling without consuming,
+// does nothing if the refill interval has not elapsed
+func (tb *TokenBucket) RefillNow() {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+}
+
+// minRefillTick is the shortest period of the background refilling,
+// shorter refill durations are credited for all elapsed intervals on the tick
+const minRefillTick = time.Millisecond
+
+// refiller refill the bucket every 'refillDur' until the bucket is closed
+func (tb *TokenBucket) refiller() {
+	tick := tb.refillDur
+
+	if tick < minRefillTick {
+		tick = minRefillTick
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tb.done:
+			return
+		case <-ticker.C:
+			tb.lock.Lock()
+			tb.refill()
+			tb.unlock()
+		}
+	}
+}
+
+// Close stops the bucket goroutines, safe to call multiple times
+func (tb *TokenBucket) Close() {
+	tb.closeOnce.Do(func() {
+		if tb.done != nil {
+			close(tb.done)
+		}
+		tb.closeSubs()
+	})
+}
+
+// fill returns 'curr' increased by 'added' but not over 'max'.
+// surplus over 'max' is kept but never increased.
+// saturates instead of overflowing for large values
+func fill(curr, added, max int64) int64 {
+	if curr >= max {
+		return curr
+	}
+	if curr > max-added {
+		return max
+	}
+	return curr + added
+}
+
+// credit returns tokens number added for 'intervals' refill intervals.
+// huge number of elapsed intervals, e.g. with nanosecond refill duration,
+// is clamped instead of overflowing, fill clamps it to the bucket capacity anyway
+func (tb *TokenBucket) credit(intervals int64) int64 {
+	if tb.refillRate > 0 && intervals > math.MaxInt64/tb.refillRate {
+		return math.MaxInt64
 	}
+	return tb.refillRate * intervals
 }
 
-// AllowN return 'true' if there are 'n' tokens in the bucket
+// refillContinuous fill the bucket with fraction of 'refillRate' proportional to the time elapsed
+func (tb *TokenBucket) refillContinuous(nowT time.Time) {
+	elapsed := nowT.Sub(tb.lastFillT)
+
+	if elapsed <= 0 {
+		return
+	}
+	filling := tb.partTokens + float64(tb.refillRate)*float64(elapsed)/float64(tb.refillDur)
+	whole := math.Floor(filling)
+
+	tb.partTokens = filling - whole
+
+	if tb.strictFloor {
+		tb.partTokens = 0
+	}
+	prevTokens := tb.currTokens
+	tb.currTokens = fill(tb.currTokens, int64(whole), tb.capacityAt(nowT))
+	tb.refilled(tb.currTokens-prevTokens, nowT)
+
+	if tb.currTokens >= tb.capacityAt(nowT) {
+		tb.partTokens = 0
+	}
+	tb.lastFillT = nowT
+	tb.refillT = tb.nextT()
+}
+
+// AllowN return 'true' if there are 'n' tokens in the bucket.
+// zero 'n' is allowed without any changes, negative 'n' is always denied
+// and never adds tokens to the bucket. AllowN and Allow never panic for any 'n',
+// so they are safe in request paths without recover.
+// until the next refill is due the refill bookkeeping is skipped, so the common case is one clock
+// reading and one comparison under the lock. the lock is always taken: tokens share the state
+// with the counters, the rate window, reservations and callbacks, so a lock-free decrement
+// would make them inconsistent. use AtomicTokenBucket if the mutex dominates under contention
 func (tb *TokenBucket) AllowN(n int) bool {
 	tb.lock.Lock()
-	defer tb.lock.Unlock()
+	defer tb.unlock()
+
+	if nowT := tb.now(); tb.refillDue(nowT) {
+		tb.refillTo(nowT)
+	}
+	return tb.take(n)
+}
+
+// Allow returns 'true' if there are enough tokens in the bucket
+// for the configured weight of one request or operation, see SetTokenN
+func (tb *TokenBucket) Allow() bool {
+	return tb.AllowN(tb.Weight())
+}
+
+// AllowOne returns 'true' if there is one token in the bucket and consumes exactly one token
+// regardless of the weight set by SetTokenN
+func (tb *TokenBucket) AllowOne() bool {
+	return tb.AllowN(1)
+}
+
+// AllowNAt return 'true' if there are 'n' tokens in the bucket at time 't'.
+// 't' going backward relative to the last filling is treated as the last filling time
+func (tb *TokenBucket) AllowNAt(t time.Time, n int) bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refillAt(t)
+
+	return tb.take(n)
+}
+
+// AllowAt returns 'true' if there are enough tokens in the bucket at time 't'
+func (tb *TokenBucket) AllowAt(t time.Time) bool {
+	return tb.AllowNAt(t, tb.Weight())
+}
+
+// AllowCost return 'true' if there are tokens for request of variable 'cost',
+// e.g. payload size. cost over 'maxTokens' is always denied
+func (tb *TokenBucket) AllowCost(cost int) bool {
+	tb.lock.Lock()
+	defer tb.unlock()
 
 	tb.refill()
 
-	if tb.currTokens < n {
+	if int64(tb.clampOversize(cost)) > tb.maxTokens {
+		tb.countDenied()
+		tb.throttled(cost)
 		return false
 	}
-	tb.currTokens -= n
+	return tb.take(cost)
+}
+
+// AllowReserved return 'true' if there are 'n' tokens in the bucket including the reserve set by SetReserve
+func (tb *TokenBucket) AllowReserved(n int) bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	return tb.takeAbove(n, 0)
+}
+
+// AllowRequest return 'true' if there are tokens for the request weight estimated by SetCostEstimator.
+// non-positive cost or missing estimator means weight of one request, cost over 'maxTokens' is always denied
+func (tb *TokenBucket) AllowRequest(req any) bool {
+	cost := 0
+
+	if tb.costFn != nil {
+		cost = tb.costFn(req)
+	}
+	if cost <= 0 {
+		cost = tb.Weight()
+	}
+	return tb.AllowCost(cost)
+}
+
+// AllowDebt return 'true' if 'n' tokens can be consumed driving the bucket at most 'maxDebt' tokens
+// below zero. the debt is repaid by the next refills before tokens are available again,
+// so total overshoot over the limit is bounded by 'maxDebt'
+func (tb *TokenBucket) AllowDebt(n, maxDebt int) bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	if n < 0 {
+		return false
+	}
+	if tb.denying() || tb.currTokens-int64(n) < -int64(maxDebt) {
+		tb.countDenied()
+		tb.throttled(n)
+		return false
+	}
+	tb.currTokens -= int64(n)
+	tb.countAllowed(int64(n))
+	tb.softLimited(tb.currTokens + int64(n))
 
 	return true
 }
 
-// Allow returns 'true' if there are enough tokens in the bucket
-func (tb *TokenBucket) Allow() bool {
-	return tb.AllowN(tb.tokenN)
+// AllowMany consumes up to 'requested' tokens and returns number of granted tokens,
+// which may be less than requested, including zero if the bucket is empty.
+// the caller processes granted number now and retries the rest later
+func (tb *TokenBucket) AllowMany(requested int) (granted int) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	available := tb.currTokens
+	if tb.denying() || available < 0 {
+		available = 0
+	}
+	if int64(requested) < available {
+		available = int64(requested)
+	}
+	if available <= 0 {
+		if requested > 0 {
+			tb.countDenied()
+			tb.throttled(requested)
+		}
+		return 0
+	}
+	tb.currTokens -= available
+	tb.countAllowed(available)
+	tb.softLimited(tb.currTokens + available)
+
+	return int(available)
+}
+
+// AllowEach consumes tokens for every weight in order under one lock and refill,
+// returns decision per weight. denied weight does not stop processing,
+// so later smaller weights can still be allowed
+func (tb *TokenBucket) AllowEach(weights []int) []bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	allowed := make([]bool, len(weights))
+
+	for i, n := range weights {
+		allowed[i] = tb.take(n)
+	}
+	return allowed
+}
+
+// AllowNErr returns nil if there are 'n' tokens in the bucket,
+// otherwise *ErrRateLimited with duration until the tokens are available
+func (tb *TokenBucket) AllowNErr(n int) error {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	if !tb.take(n) {
+		return &ErrRateLimited{
+			retryAfter: tb.retryAfter(n, tb.now()),
+		}
+	}
+	return nil
+}
+
+// AllowErr returns nil if there are enough tokens in the bucket, otherwise *ErrRateLimited
+func (tb *TokenBucket) AllowErr() error {
+	return tb.AllowNErr(tb.Weight())
+}
+
+// AllowNInfo works as AllowN and also returns tokens remaining after the decision
+// and duration until 'n' tokens are available if denied, all read under one lock,
+// so the values are consistent with the decision, e.g. for rate limit headers
+func (tb *TokenBucket) AllowNInfo(n int) (ok bool, remaining int, retryAfter time.Duration) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	ok = tb.take(n)
+
+	if !ok {
+		retryAfter = tb.retryAfter(n, tb.now())
+	}
+	return ok, int(tb.currTokens), retryAfter
+}
+
+// AllowInfo works as AllowNInfo for weight of one request or operation
+func (tb *TokenBucket) AllowInfo() (ok bool, remaining int, retryAfter time.Duration) {
+	return tb.AllowNInfo(tb.Weight())
+}
+
+// AllowNReserving return 'true' and zero duration if there are 'n' tokens in the bucket,
+// otherwise 'false' and duration until the tokens are available, nothing is reserved on denial.
+// the duration is math.MaxInt64 if 'n' tokens can never be available
+func (tb *TokenBucket) AllowNReserving(n int) (bool, time.Duration) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	if !tb.take(n) {
+		return false, tb.retryAfter(n, tb.now())
+	}
+	return true, 0
+}
+
+// take consumes 'n' tokens if they are in the bucket over the reserve.
+// zero 'n' is allowed and negative 'n' is denied without changes.
+// must be called under the lock
+func (tb *TokenBucket) take(n int) bool {
+	return tb.takeAbove(n, tb.reserve)
+}
+
+// takeN works as take and also returns number of consumed tokens.
+// must be called under the lock
+func (tb *TokenBucket) takeN(n int) (int64, bool) {
+	return tb.takeAboveN(n, tb.reserve)
+}
+
+// takeAbove consumes 'n' tokens if 'floor' tokens are left in the bucket after it.
+// must be called under the lock
+func (tb *TokenBucket) takeAbove(n int, floor int64) bool {
+	_, ok := tb.takeAboveN(n, floor)
+	return ok
+}
+
+// takeAboveN works as takeAbove and also returns number of consumed tokens,
+// which is less than 'n' for oversize request clamped to max tokens and bounded dry run debt.
+// must be called under the lock
+func (tb *TokenBucket) takeAboveN(n int, floor int64) (int64, bool) {
+	if n <= 0 {
+		return 0, n == 0
+	}
+	n = tb.clampOversize(n)
+
+	// compared without subtracting 'n', so huge 'n' can not overflow current tokens
+	short := tb.currTokens-floor < int64(n)
+
+	if tb.denying() || (short && !tb.dryRun) {
+		tb.countDenied()
+		tb.throttled(n)
+		return 0, false
+	}
+	if short {
+		tb.wouldDenyN++
+
+		// debt of the dry run is bounded by 'maxTokens', so huge 'n' can not overflow current tokens
+		if left := tb.currTokens + tb.maxTokens; int64(n) > left {
+			n = 0
+
+			if left > 0 {
+				n = int(left)
+			}
+		}
+	}
+	tb.currTokens -= int64(n)
+	tb.countAllowed(int64(n))
+	tb.softLimited(tb.currTokens + int64(n))
+
+	return int64(n), true
+}
+
+// Refund returns 'n' previously consumed tokens back to the bucket.
+// the bucket is never filled over 'maxTokens', so refunding can not create extra capacity
+func (tb *TokenBucket) Refund(n int) {
+	if n <= 0 {
+		return
+	}
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+	tb.giveBack(n)
+}
+
+// throttled schedules the throttle callback and logging for denied 'n' tokens.
+// must be called under the lock
+func (tb *TokenBucket) throttled(n int) {
+	tb.logDeny(n)
+
+	if tb.onThrottle == nil {
+		return
+	}
+	fn := tb.onThrottle
+	available := int(tb.currTokens)
+
+	if available < 0 {
+		available = 0
+	}
+	tb.later(func() {
+		fn(n, available)
+	})
+}
+
+// later schedules the callback to run after the lock is released.
+// must be called under the lock
+func (tb *TokenBucket) later(fn func()) {
+	tb.pending = append(tb.pending, fn)
+}
+
+// unlock releases the lock and runs the callbacks scheduled under it
+func (tb *TokenBucket) unlock() {
+	pending := tb.pending
+	tb.pending = nil
+
+	tb.lock.Unlock()
+
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+// untake refunds 'n' tokens consumed by takeN, which must be the number it returned.
+// the previous tokens number is restored exactly, not clamped to 'maxTokens',
+// so surplus over the capacity, e.g. of SetBurst, is not lost by the refund.
+// must be called under the lock
+func (tb *TokenBucket) untake(n int64) {
+	tb.currTokens += n
+	tb.allowedN--
+	tb.consumed(-n)
+}
+
+// Allowed returns number of allowed requests or operations since the bucket creation
+func (tb *TokenBucket) Allowed() int64 {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.allowedN
+}
+
+// Denied returns number of denied requests or operations since the bucket creation
+func (tb *TokenBucket) Denied() int64 {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.deniedN
+}
+
+// Tokens returns current token number in the bucket after refilling
+func (tb *TokenBucket) Tokens() int {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	return int(tb.currTokens)
+}
+
+// Available returns number of tokens which can be consumed right now without consuming them.
+// the value may change immediately due to concurrent consumers
+func (tb *TokenBucket) Available() int {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	if tb.currTokens < 0 {
+		return 0
+	}
+	return int(tb.currTokens)
+}
+
+// FillRatio returns current tokens number after refilling divided by 'maxTokens' in [0, 1],
+// e.g. to shed non-critical work while the bucket is below 20%.
+// tokens taken in advance give zero, surplus over 'maxTokens' gives one
+func (tb *TokenBucket) FillRatio() float64 {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	if tb.maxTokens <= 0 || tb.currTokens <= 0 {
+		return 0
+	}
+	if tb.currTokens >= tb.maxTokens {
+		return 1
+	}
+	return float64(tb.currTokens) / float64(tb.maxTokens)
+}
+
+// Capacity returns maximum number of tokens in the bucket
+func (tb *TokenBucket) Capacity() int {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return int(tb.maxTokens)
+}
+
+// Reset fill the bucket up to 'maxTokens'.
+// progress accumulated toward the next refill is discarded,
+// the warmup is restarted if it is set.
+// it is the only way to replenish the bucket with zero refill rate
+func (tb *TokenBucket) Reset() {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.currTokens = tb.maxTokens
+	tb.lastFillT = tb.now()
+	tb.refillT = tb.nextT()
+	tb.startWarmup()
+}
+
+// Drain removes all available tokens from the bucket
+func (tb *TokenBucket) Drain() {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	if tb.currTokens > 0 {
+		tb.currTokens = 0
+	}
+}
+
+// DrainN removes up to 'n' available tokens from the bucket without allow or deny decision
+// and returns number of removed tokens. the bucket never goes below zero
+func (tb *TokenBucket) DrainN(n int) int {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	drained := int64(n)
+	if drained > tb.currTokens {
+		drained = tb.currTokens
+	}
+	if drained <= 0 {
+		return 0
+	}
+	tb.currTokens -= drained
+
+	return int(drained)
+}
+
+// idle returns 'true' if the bucket was not filled for 'ttl' and would be full now.
+// the bucket is not refilled, so 'lastFillT' still points to the last bucket use
+func (tb *TokenBucket) idle(ttl time.Duration) bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	nowT := tb.now()
+
+	if !tb.lastFillT.Add(ttl).Before(nowT) {
+		return false
+	}
+	intervals := int64(nowT.Sub(tb.lastFillT) / tb.refillDur)
+
+	return fill(tb.currTokens, tb.credit(intervals), tb.maxTokens) >= tb.maxTokens
+}
+
+// reusable returns 'true' if no goroutines or subscribers refer to the bucket,
+// so the bucket can be reinitialized by init
+func (tb *TokenBucket) reusable() bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return !tb.background && tb.tokensC == nil && tb.pressureC == nil && len(tb.subs) == 0
+}
+
+// RefillRate returns number of tokens added in the bucket per refill duration
+func (tb *TokenBucket) RefillRate() int {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return int(tb.refillRate)
+}
+
+// RefillDuration returns bucket refill duration
+func (tb *TokenBucket) RefillDuration() time.Duration {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.refillDur
+}
+
+// SetRefillRate set number of tokens added in the bucket per refill duration.
+// tokens accumulated with the previous rate are credited first.
+// returns the bucket for chaining, e.g. tb.SetRefillRate(5).SetMaxTokens(20), every call is locked separately
+func (tb *TokenBucket) SetRefillRate(rate int) *TokenBucket {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	tb.refillRate = int64(rate)
+
+	return tb
+}
+
+// SetRefillDurationNow set bucket refill duration at runtime.
+// the accrual is settled first: intervals completed with the previous duration are credited,
+// and the elapsed fraction of the interrupted interval becomes the same fraction of the first new interval.
+// so shortening the duration after a long partial interval does not credit the old time at the new rate,
+// and refills are neither skipped nor doubled at the switch point
+func (tb *TokenBucket) SetRefillDurationNow(dur time.Duration) error {
+	if dur <= 0 {
+		return fmt.Errorf("token_bucket: refill duration must be positive, got %s", dur)
+	}
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+	tb.switchRefillDur(dur)
+
+	return nil
+}
+
+// switchRefillDur set refill duration clamped to the minimum interval keeping progress of the interrupted
+// interval: its elapsed fraction becomes the same fraction of the first new interval.
+// must be called under the lock after the accrual with the previous duration is credited by refill
+func (tb *TokenBucket) switchRefillDur(dur time.Duration) {
+	dur = tb.clampInterval(dur)
+
+	if !tb.paused {
+		nowT := tb.now()
+
+		if elapsed := nowT.Sub(tb.lastFillT); elapsed > 0 {
+			progress := float64(elapsed) / float64(tb.refillDur)
+			tb.lastFillT = nowT.Add(-time.Duration(progress * float64(dur)))
+		}
+	}
+	tb.refillDur = dur
+	tb.refillT = tb.nextT()
+	tb.early, tb.earlyN = false, 0
+}
+
+// SetMaxTokens set maximum number of tokens in the bucket.
+// tokens over the new maximum are dropped immediately, see SetMaxTokensGraceful.
+// returns the bucket for chaining
+func (tb *TokenBucket) SetMaxTokens(max int) *TokenBucket {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	tb.maxTokens = int64(max)
+
+	if tb.currTokens > tb.maxTokens {
+		tb.currTokens = tb.maxTokens
+	}
+	return tb
+}
+
+// SetMaxTokensGraceful set maximum number of tokens in the bucket.
+// tokens over the new maximum are kept and drain by normal consumption,
+// the bucket is not refilled until it goes below the new maximum.
+// returns the bucket for chaining
+func (tb *TokenBucket) SetMaxTokensGraceful(max int) *TokenBucket {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	tb.maxTokens = int64(max)
+
+	return tb
 }
@@ -0,0 +1,1264 @@
+package token_bucket
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func BenchmarkAllowN(b *testing.B) {
+	b.Run("allowed", func(b *testing.B) {
+		tb := NewTokenBucket(b.N+1, 0)
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if !tb.AllowN(1) {
+				b.Fatal("denied")
+			}
+		}
+	})
+	b.Run("denied", func(b *testing.B) {
+		tb := NewTokenBucket(1, 1, SetRefillDuration(time.Hour))
+		tb.AllowN(1)
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if tb.AllowN(1) {
+				b.Fatal("allowed")
+			}
+		}
+	})
+	b.Run("refill", func(b *testing.B) {
+		clock := NewTestClock(time.Unix(0, 0))
+		tb := NewTokenBucket(1, 1, SetRefillDuration(time.Millisecond), SetClock(clock))
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			clock.Advance(time.Millisecond)
+
+			if !tb.AllowN(1) {
+				b.Fatal("denied")
+			}
+		}
+	})
+}
+
+func BenchmarkAllow(b *testing.B) {
+	b.Run("allowed", func(b *testing.B) {
+		tb := NewTokenBucket(b.N+1, 0)
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			tb.Allow()
+		}
+	})
+	b.Run("denied", func(b *testing.B) {
+		tb := NewTokenBucket(1, 0)
+		tb.Allow()
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			tb.Allow()
+		}
+	})
+	b.Run("refill", func(b *testing.B) {
+		clock := NewTestClock(time.Unix(0, 0))
+		tb := NewTokenBucket(1, 1, SetRefillDuration(time.Millisecond), SetClock(clock))
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			clock.Advance(time.Millisecond)
+			tb.Allow()
+		}
+	})
+}
+
+func FuzzAllowN(f *testing.F) {
+	for _, n := range []int{0, 1, -1, 10, 11, math.MaxInt, math.MinInt, math.MaxInt - 1} {
+		for _, dur := range []int64{0, -1, 1, int64(time.Second), math.MaxInt64, math.MinInt64} {
+			f.Add(n, dur, int64(time.Millisecond), false)
+		}
+	}
+	f.Add(math.MaxInt, int64(time.Nanosecond), int64(math.MaxInt64), true)
+
+	f.Fuzz(func(t *testing.T, n int, dur, advance int64, continuous bool) {
+		clock := NewTestClock(time.Unix(0, 0))
+		tb := NewTokenBucket(10, 5,
+			SetRefillDuration(time.Duration(dur)),
+			SetContinuousRefill(continuous),
+			SetClock(clock),
+		)
+		defer tb.Close()
+
+		for i := 0; i < 3; i++ {
+			tb.AllowN(n)
+			tb.Allow()
+
+			if advance > 0 {
+				clock.Advance(time.Duration(advance))
+			}
+			if tokens := tb.Tokens(); tokens < 0 || tokens > 10 {
+				t.Fatalf("tokens out of [0, 10]: %d", tokens)
+			}
+		}
+		if n < 0 && tb.AllowN(n) {
+			t.Fatalf("negative n %d allowed", n)
+		}
+	})
+}
+
+func TestAllowNNonPositiveRefillDuration(t *testing.T) {
+	for _, dur := range []time.Duration{0, -time.Second} {
+		tb := NewTokenBucket(1, 1, SetRefillDuration(dur))
+
+		if got := tb.RefillDuration(); got != time.Second {
+			t.Fatalf("refill duration %s is not clamped to 1s, got %s", dur, got)
+		}
+		tb.Allow()
+		tb.Close()
+
+		if _, err := NewTokenBucketChecked(1, 1, SetRefillDuration(dur)); err == nil {
+			t.Fatalf("refill duration %s is accepted by NewTokenBucketChecked", dur)
+		}
+		if err := NewTokenBucket(1, 1).SetRefillDurationNow(dur); err == nil {
+			t.Fatalf("refill duration %s is accepted by SetRefillDurationNow", dur)
+		}
+	}
+}
+
+// BenchmarkAllowNRefillSkip compares refilling on every call with AllowN skipping the refill
+// bookkeeping until a refill is due, both under the lock. the lock-free counterpart is
+// AtomicTokenBucket, see BenchmarkAtomicTokenBucket
+func BenchmarkAllowNRefillSkip(b *testing.B) {
+	for _, rate := range []int{0, 1} {
+		name := "interval"
+		if rate == 0 {
+			name = "quota"
+		}
+		b.Run(name+"/refill", func(b *testing.B) {
+			tb := NewTokenBucket(b.N+1, rate, SetRefillDuration(time.Hour))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				tb.lock.Lock()
+				tb.refill()
+				tb.take(1)
+				tb.unlock()
+			}
+		})
+		b.Run(name+"/skip", func(b *testing.B) {
+			tb := NewTokenBucket(b.N+1, rate, SetRefillDuration(time.Hour))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				tb.AllowN(1)
+			}
+		})
+	}
+	b.Run("parallel", func(b *testing.B) {
+		tb := NewTokenBucket(math.MaxInt32, 1, SetRefillDuration(time.Hour))
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				tb.AllowN(1)
+			}
+		})
+	})
+}
+
+func TestAllowNConcurrent(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	tb := NewTokenBucket(100, 10, SetRefillDuration(time.Millisecond), SetClock(clock))
+
+	const (
+		workers = 8
+		calls   = 2000
+		ticks   = 50
+	)
+	allowed := make(chan int, workers)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < ticks; i++ {
+			clock.Advance(time.Millisecond)
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			n := 0
+
+			for i := 0; i < calls; i++ {
+				if tb.AllowN(1) {
+					n++
+				}
+			}
+			allowed <- n
+		}()
+	}
+	total := 0
+
+	for w := 0; w < workers; w++ {
+		total += <-allowed
+	}
+	<-done
+
+	if limit := 100 + ticks*10; total > limit {
+		t.Fatalf("allowed %d tokens, at most %d are available", total, limit)
+	}
+	if tokens := tb.Tokens(); tokens < 0 || tokens > 100 {
+		t.Fatalf("tokens out of [0, 100]: %d", tokens)
+	}
+}
+
+func TestAllowNStress(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	var added atomic.Int64
+
+	tb := NewTokenBucket(50, 5, SetRefillDuration(time.Millisecond), SetClock(clock),
+		SetRateWindow(time.Second), SetOnRefill(func(n, _ int) {
+			added.Add(int64(n))
+		}))
+	defer tb.Close()
+
+	const (
+		workers = 8
+		calls   = 2000
+	)
+	var allowed, attempts atomic.Int64
+
+	wg := sync.WaitGroup{}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 200; i++ {
+			clock.Advance(time.Millisecond)
+			tb.Stats()
+
+			// interleaves the refills with the consumers even on a single CPU
+			runtime.Gosched()
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < calls; i++ {
+				n := 1 + (w+i)%3
+				attempts.Add(1)
+
+				if tb.AllowN(n) {
+					allowed.Add(int64(n))
+				}
+				if i%100 == 0 {
+					runtime.Gosched()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	<-done
+
+	if added.Load() == 0 {
+		t.Fatal("no refills during consumption")
+	}
+	// every token is accounted: consumed tokens, the counters and the refills agree exactly
+	s := tb.Stats()
+
+	if s.Consumed != allowed.Load() {
+		t.Fatalf("got %d consumed tokens in stats, want %d", s.Consumed, allowed.Load())
+	}
+	if s.Allowed+s.Denied != attempts.Load() {
+		t.Fatalf("got %d allowed and %d denied, want %d decisions", s.Allowed, s.Denied, attempts.Load())
+	}
+	if want := 50 + added.Load() - allowed.Load(); int64(s.Tokens) != want {
+		t.Fatalf("got %d tokens, want %d from the refills and consumption", s.Tokens, want)
+	}
+}
+
+func TestRefillCoarseClock(t *testing.T) {
+	for _, continuous := range []bool{false, true} {
+		clock := NewTestClock(time.Unix(0, 0))
+		tb := NewTokenBucket(100, 1,
+			SetRefillDuration(10*time.Millisecond),
+			SetContinuousRefill(continuous),
+			SetClock(clock),
+		)
+		tb.AllowN(100)
+
+		// 15ms clock ticks with 10ms refill duration: every call sees 1 or 2 elapsed intervals
+		consumed := 0
+
+		for i := 0; i < 1000; i++ {
+			clock.Advance(15 * time.Millisecond)
+
+			for tb.AllowOne() {
+				consumed++
+			}
+		}
+		if consumed != 1500 {
+			t.Fatalf("continuous %v: consumed %d tokens in 15s, want 1500", continuous, consumed)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 2, SetClock(clock))
+	defer tb.Close()
+
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("new bucket must be full: got %d tokens, want 5", got)
+	}
+	tb.AllowN(4)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1", got)
+	}
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("Tokens must refill the bucket first: got %d tokens, want 3", got)
+	}
+}
+
+func TestRefillAllElapsedIntervals(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 2, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(10)
+	clock.Advance(3*time.Second + 500*time.Millisecond)
+
+	if got := tb.Tokens(); got != 6 {
+		t.Fatalf("three elapsed intervals must be credited: got %d tokens, want 6", got)
+	}
+	// the rest of the fourth interval is kept, so the next refill is due in 500ms
+	clock.Advance(500 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 8 {
+		t.Fatalf("got %d tokens, want 8", got)
+	}
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != 10 {
+		t.Fatalf("refill must be capped at max tokens: got %d, want 10", got)
+	}
+}
+
+func TestRefillSubSecond(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(4, 1, SetRefillDuration(250*time.Millisecond), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(4)
+	clock.Advance(249 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("refill before the interval elapsed: got %d tokens, want 0", got)
+	}
+	clock.Advance(time.Millisecond)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("refill within the same second must be credited: got %d tokens, want 1", got)
+	}
+	clock.Advance(500 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("got %d tokens, want 3", got)
+	}
+}
+
+func TestReset(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(5)
+	clock.Advance(900 * time.Millisecond)
+	tb.Reset()
+
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("reset must fill the bucket: got %d tokens, want 5", got)
+	}
+	// progress toward the next refill is discarded, so the refill is due a full interval after reset
+	tb.AllowN(1)
+	clock.Advance(900 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 4 {
+		t.Fatalf("got %d tokens, want 4", got)
+	}
+	quota := NewTokenBucket(2, 0, SetClock(clock))
+	defer quota.Close()
+
+	quota.AllowN(2)
+	quota.Reset()
+
+	if got := quota.Tokens(); got != 2 {
+		t.Fatalf("reset must fill zero refill rate bucket: got %d tokens, want 2", got)
+	}
+}
+
+func TestSetRefillRate(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(10)
+	clock.Advance(2 * time.Second)
+
+	// two intervals at the previous rate are credited before the rate changes
+	tb.SetRefillRate(3)
+
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("new rate must apply to the next interval: got %d tokens, want 5", got)
+	}
+	if got := tb.RefillRate(); got != 3 {
+		t.Fatalf("got refill rate %d, want 3", got)
+	}
+}
+
+func TestSetMaxTokens(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 5, SetClock(clock))
+	defer tb.Close()
+
+	tb.SetMaxTokens(4)
+
+	if got := tb.Tokens(); got != 4 {
+		t.Fatalf("tokens over the new maximum must be dropped: got %d, want 4", got)
+	}
+	tb.SetMaxTokens(8).AllowN(4)
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("got %d tokens, want 5", got)
+	}
+	clock.Advance(time.Second)
+
+	if got, capacity := tb.Tokens(), tb.Capacity(); got != 8 || capacity != 8 {
+		t.Fatalf("refill must be capped at the new maximum: got %d tokens of %d, want 8 of 8", got, capacity)
+	}
+}
+
+func TestSettersChaining(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 1, SetClock(clock))
+	defer tb.Close()
+
+	if got := tb.SetRefillRate(5).SetMaxTokens(20).SetMaxTokensGraceful(15); got != tb {
+		t.Fatal("setters must return the bucket")
+	}
+	tb.AllowN(10)
+	clock.Advance(time.Second)
+
+	if got, capacity := tb.Tokens(), tb.Capacity(); got != 5 || capacity != 15 {
+		t.Fatalf("got %d tokens of %d, want 5 of 15", got, capacity)
+	}
+}
+
+func TestContinuousRefill(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 10, SetContinuousRefill(true), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(10)
+	clock.Advance(350 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("tokens must accrue within the interval: got %d, want 3", got)
+	}
+	// the fractional half token is kept
+	clock.Advance(50 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 4 {
+		t.Fatalf("fraction must be carried over: got %d tokens, want 4", got)
+	}
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != 10 {
+		t.Fatalf("refill must be capped at max tokens: got %d, want 10", got)
+	}
+}
+
+func TestStrictFloor(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 10, SetContinuousRefill(true), SetStrictFloor(true), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(10)
+	clock.Advance(350 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("got %d tokens, want 3", got)
+	}
+	// the half token of the previous refilling is dropped
+	clock.Advance(50 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("fraction must be dropped: got %d tokens, want 3", got)
+	}
+	// accessed more often than one token is accumulated, the bucket is never refilled
+	for i := 0; i < 10; i++ {
+		clock.Advance(50 * time.Millisecond)
+		tb.Tokens()
+	}
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("got %d tokens, want 3 without refilling", got)
+	}
+	clone := tb.Clone()
+	defer clone.Close()
+
+	for i := 0; i < 2; i++ {
+		clock.Advance(50 * time.Millisecond)
+		clone.Tokens()
+	}
+	if got := clone.Tokens(); got != 3 {
+		t.Fatalf("clone must keep strict floor: got %d tokens, want 3", got)
+	}
+}
+
+func TestSetTokenNNonPositive(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	for _, weight := range []int{0, -3} {
+		tb := NewTokenBucket(2, 1, SetClock(clock), SetTokenN(weight))
+		defer tb.Close()
+
+		if got := tb.Weight(); got != 1 {
+			t.Fatalf("weight %d: got %d, want the default 1", weight, got)
+		}
+		if !tb.Allow() || !tb.Allow() || tb.Allow() {
+			t.Fatalf("weight %d: Allow must consume one token", weight)
+		}
+	}
+	// key buckets are normalized as well
+	kl := NewKeyedLimiter[string](1, 1, SetBucketOptions(SetClock(clock), SetTokenN(0)), SetBucketReuse(true))
+	defer kl.Close()
+
+	if got := kl.Bucket("a").Weight(); got != 1 {
+		t.Fatalf("keyed bucket: got weight %d, want 1", got)
+	}
+}
+
+func TestNewTokenBucketChecked(t *testing.T) {
+	invalid := map[string]struct {
+		maxTokens, refillRate int
+		options               []Option
+	}{
+		"zero max tokens":     {0, 1, nil},
+		"negative refill":     {1, -1, nil},
+		"zero refill dur":     {1, 1, []Option{SetRefillDuration(0)}},
+		"zero token weight":   {1, 1, []Option{SetTokenN(0)}},
+		"negative max tokens": {-1, 1, nil},
+	}
+	for name, p := range invalid {
+		if _, err := NewTokenBucketChecked(p.maxTokens, p.refillRate, p.options...); err == nil {
+			t.Fatalf("%s: invalid parameters are accepted", name)
+		}
+	}
+	tb, err := NewTokenBucketChecked(1, 0)
+	if err != nil {
+		t.Fatalf("valid parameters: %v", err)
+	}
+	defer tb.Close()
+
+	if !tb.Allow() {
+		t.Fatal("checked bucket must be full")
+	}
+}
+
+// storedTokens returns tokens in the bucket without refilling it on access
+func storedTokens(tb *TokenBucket) int64 {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.currTokens
+}
+
+func TestBackgroundRefill(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	// the ticker is real, the refilled intervals are counted by the test clock
+	tb := NewTokenBucket(2, 1,
+		SetRefillDuration(time.Millisecond),
+		SetBackgroundRefill(true),
+		SetClock(clock),
+	)
+	tb.AllowN(2)
+	clock.Advance(2 * time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+
+	for storedTokens(tb) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("bucket is not refilled without access: got %d tokens", storedTokens(tb))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tb.Close()
+	tb.Close()
+
+	select {
+	case <-tb.done:
+	default:
+		t.Fatal("close must stop the refiller")
+	}
+}
+
+func TestRefillOverflow(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(math.MaxInt, math.MaxInt, SetRefillDuration(time.Nanosecond), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(math.MaxInt)
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != math.MaxInt {
+		t.Fatalf("huge credit must saturate at max tokens: got %d", got)
+	}
+	if got := fill(math.MaxInt64-1, math.MaxInt64, math.MaxInt64); got != math.MaxInt64 {
+		t.Fatalf("fill overflowed: got %d", got)
+	}
+}
+
+func TestRefillTinyDuration(t *testing.T) {
+	tb := NewTokenBucket(5, 3, SetRefillDuration(time.Nanosecond))
+	defer tb.Close()
+
+	if got := tb.credit(math.MaxInt64 / 2); got != math.MaxInt64 {
+		t.Fatalf("got credit %d, want clamped to max int64", got)
+	}
+	if got := tb.credit(4); got != 12 {
+		t.Fatalf("got credit %d, want 12", got)
+	}
+	// the background ticker is not faster than minRefillTick, the elapsed intervals are credited on the tick
+	bg := NewTokenBucket(5, 1, SetRefillDuration(time.Nanosecond), SetBackgroundRefill(true))
+	defer bg.Close()
+
+	bg.lock.Lock()
+	bg.currTokens = 0
+	bg.unlock()
+
+	deadline := time.Now().Add(time.Second)
+
+	for storedTokens(bg) < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("background refill stalled: got %d tokens", storedTokens(bg))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(3, 1, SetClock(clock))
+	defer tb.Close()
+
+	for i := 0; i < 3; i++ {
+		if got := tb.Available(); got != 3 {
+			t.Fatalf("Available must not consume: got %d tokens, want 3", got)
+		}
+	}
+	tb.AllowN(3)
+	r := tb.ReserveN(1)
+	defer r.Cancel()
+
+	if got := tb.Available(); got != 0 {
+		t.Fatalf("tokens taken in advance must not be available: got %d", got)
+	}
+	clock.Advance(2 * time.Second)
+
+	if got := tb.Available(); got != 1 {
+		t.Fatalf("got %d available tokens, want 1", got)
+	}
+}
+
+func TestAllowNAt(t *testing.T) {
+	startT := time.Unix(1000, 0)
+
+	tb := NewTokenBucket(2, 1, SetClock(NewTestClock(startT)))
+	defer tb.Close()
+
+	if !tb.AllowNAt(startT, 2) || tb.AllowAt(startT) {
+		t.Fatal("bucket of two tokens at the start time")
+	}
+	if !tb.AllowAt(startT.Add(time.Second)) {
+		t.Fatal("token refilled at the explicit time must be allowed")
+	}
+	// time going backward is treated as the last filling time
+	if tb.AllowAt(startT) {
+		t.Fatal("time going backward credited tokens")
+	}
+	if !tb.AllowNAt(startT.Add(time.Hour), 2) {
+		t.Fatal("bucket must be full an hour later")
+	}
+}
+
+func TestRefund(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(4)
+	tb.Refund(3)
+
+	if got := tb.Tokens(); got != 4 {
+		t.Fatalf("got %d tokens, want 4", got)
+	}
+	tb.Refund(10)
+
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("refund must not fill over max tokens: got %d, want 5", got)
+	}
+	tb.AllowN(5)
+	tb.Refund(-3)
+	tb.Refund(0)
+
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("non-positive refund changed tokens: got %d", got)
+	}
+}
+
+func TestSetInitialTokens(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	for initial, want := range map[int]int{0: 0, 2: 2, -5: 0, 50: 5} {
+		tb := NewTokenBucket(5, 1, SetInitialTokens(initial), SetClock(clock))
+
+		if got := tb.Tokens(); got != want {
+			t.Fatalf("initial %d: got %d tokens, want %d", initial, got, want)
+		}
+		tb.Close()
+	}
+	tb := NewTokenBucket(5, 1, SetInitialTokens(0), SetClock(clock))
+	defer tb.Close()
+
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("empty bucket must be refilled: got %d tokens, want 1", got)
+	}
+}
+
+func TestSetBurst(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 5, SetBurst(8), SetClock(clock))
+	defer tb.Close()
+
+	if !tb.AllowN(8) {
+		t.Fatal("burst over max tokens denied")
+	}
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("refill must be capped at max tokens: got %d, want 5", got)
+	}
+}
+
+func TestSetOnThrottle(t *testing.T) {
+	type throttle struct{ requested, available int }
+
+	var (
+		got []throttle
+		tb  *TokenBucket
+	)
+	tb = NewTokenBucket(3, 1,
+		SetOnThrottle(func(requested, available int) {
+			got = append(got, throttle{requested, available})
+			// the callback runs outside of the lock, so it may use the bucket
+			tb.Tokens()
+		}),
+		SetClock(NewTestClock(time.Unix(0, 0))),
+	)
+	defer tb.Close()
+
+	tb.AllowN(2)
+	tb.AllowN(2)
+	tb.AllowN(1)
+	tb.AllowN(1)
+
+	if len(got) != 2 || got[0] != (throttle{2, 1}) || got[1] != (throttle{1, 0}) {
+		t.Fatalf("got throttles %+v, want denied 2 of 1 and 1 of 0", got)
+	}
+}
+
+func TestAllowEach(t *testing.T) {
+	tb := NewTokenBucket(5, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	got := tb.AllowEach([]int{2, 4, 3, 1, -1})
+	want := []bool{true, false, true, false, false}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got decisions %v, want %v", got, want)
+		}
+	}
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("got %d tokens, want 0", got)
+	}
+	if got := tb.AllowEach(nil); len(got) != 0 {
+		t.Fatalf("got decisions %v for no weights", got)
+	}
+}
+
+func TestAllowCost(t *testing.T) {
+	tb := NewTokenBucket(10, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	if !tb.AllowCost(7) || tb.AllowCost(4) || !tb.AllowCost(3) {
+		t.Fatal("costs 7 and 3 must fit into 10 tokens, 4 over the rest must not")
+	}
+	tb.Reset()
+
+	if tb.AllowCost(11) {
+		t.Fatal("cost over max tokens allowed")
+	}
+	if s := tb.Stats(); s.Denied != 2 {
+		t.Fatalf("got %d denied, want 2", s.Denied)
+	}
+}
+
+func TestSetMaxTokensGraceful(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 2, SetClock(clock))
+	defer tb.Close()
+
+	tb.SetMaxTokensGraceful(4)
+
+	if got := tb.Tokens(); got != 10 {
+		t.Fatalf("surplus over the new maximum must be kept: got %d tokens, want 10", got)
+	}
+	tb.AllowN(5)
+	clock.Advance(time.Second)
+
+	// the bucket is over the new maximum, so it is not refilled
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("got %d tokens, want 5", got)
+	}
+	tb.AllowN(3)
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != 4 {
+		t.Fatalf("refill must be capped at the new maximum: got %d tokens, want 4", got)
+	}
+}
+
+func TestNewPerSecondPerMinute(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	perSecond := NewPerSecond(5, SetClock(clock))
+	defer perSecond.Close()
+
+	perMinute := NewPerMinute(60, SetClock(clock), SetCapacity(10))
+	defer perMinute.Close()
+
+	if perSecond.Capacity() != 5 || perSecond.RefillRate() != 5 || perSecond.RefillDuration() != time.Second {
+		t.Fatalf("got %s, want 5 per second with burst 5", perSecond)
+	}
+	// options override the defaults of the constructor
+	if perMinute.Capacity() != 10 || perMinute.RefillRate() != 60 || perMinute.RefillDuration() != time.Minute {
+		t.Fatalf("got %s, want 60 per minute with burst 10", perMinute)
+	}
+}
+
+func TestAllowDebt(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(4, 2, SetClock(clock))
+	defer tb.Close()
+
+	if !tb.AllowDebt(6, 3) {
+		t.Fatal("debt of 2 within max debt 3 denied")
+	}
+	if tb.AllowDebt(2, 3) {
+		t.Fatal("debt of 4 over max debt 3 allowed")
+	}
+	if tb.AllowDebt(-1, 3) {
+		t.Fatal("negative n allowed")
+	}
+	// the debt is repaid by the next refill before tokens are available again
+	clock.Advance(time.Second)
+
+	if tb.Allow() {
+		t.Fatal("debt is not repaid by the refill")
+	}
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+}
+
+func TestSetRefillDurationNowProration(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(10)
+	clock.Advance(2*time.Second + 500*time.Millisecond)
+
+	if err := tb.SetRefillDurationNow(10 * time.Second); err != nil {
+		t.Fatalf("set refill duration: %v", err)
+	}
+	// two whole intervals are credited, half of the interrupted one becomes half of the new one
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+	clock.Advance(5*time.Second - time.Millisecond)
+
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("refill before the prorated interval elapsed: got %d tokens", got)
+	}
+	clock.Advance(time.Millisecond)
+
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("got %d tokens, want 3", got)
+	}
+	if got := tb.RefillDuration(); got != 10*time.Second {
+		t.Fatalf("got refill duration %s, want 10s", got)
+	}
+}
+
+func TestSetRefillDurationNowShorterThanElapsed(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 1, SetClock(clock), SetRefillDuration(10*time.Second))
+	defer tb.Close()
+
+	tb.AllowN(10)
+	clock.Advance(9 * time.Second)
+
+	if err := tb.SetRefillDurationNow(time.Second); err != nil {
+		t.Fatalf("set refill duration: %v", err)
+	}
+	// 9s of the old interval are 90% of the new one, not nine new intervals
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("got %d tokens, want 0", got)
+	}
+	clock.Advance(100 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1 at the end of the prorated interval", got)
+	}
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+}
+
+func TestAllowNReserving(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock))
+	defer tb.Close()
+
+	if ok, d := tb.AllowNReserving(2); !ok || d != 0 {
+		t.Fatalf("got %v, %s, want allowed without delay", ok, d)
+	}
+	clock.Advance(200 * time.Millisecond)
+
+	if ok, d := tb.AllowNReserving(2); ok || d != 1800*time.Millisecond {
+		t.Fatalf("got %v, %s, want denied with delay 1.8s", ok, d)
+	}
+	if ok, d := tb.AllowNReserving(3); ok || d != infDuration {
+		t.Fatalf("got %v, %s, want denied forever", ok, d)
+	}
+	clock.Advance(800 * time.Millisecond)
+
+	// nothing is reserved on denial
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1", got)
+	}
+}
+
+func TestRefillNow(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 2, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(5)
+	clock.Advance(999 * time.Millisecond)
+	tb.RefillNow()
+
+	if got := storedTokens(tb); got != 0 {
+		t.Fatalf("refill before the interval elapsed: got %d tokens", got)
+	}
+	clock.Advance(time.Millisecond)
+	tb.RefillNow()
+
+	if got := storedTokens(tb); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+}
+
+func TestAllowNNonPositive(t *testing.T) {
+	tb := NewTokenBucket(5, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	if !tb.AllowN(0) {
+		t.Fatal("zero n must be allowed")
+	}
+	for _, n := range []int{-1, -5, math.MinInt} {
+		if tb.AllowN(n) {
+			t.Fatalf("negative n %d allowed", n)
+		}
+	}
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("non-positive n changed tokens: got %d, want 5", got)
+	}
+	if !tb.AllowN(5) {
+		t.Fatal("bucket must stay full after non-positive requests")
+	}
+}
+
+func TestAllowRequest(t *testing.T) {
+	type batch struct{ items int }
+
+	tb := NewTokenBucket(10, 1,
+		SetTokenN(2),
+		SetCostEstimator(func(req any) int {
+			if b, ok := req.(batch); ok {
+				return b.items
+			}
+			return 0
+		}),
+		SetClock(NewTestClock(time.Unix(0, 0))),
+	)
+	defer tb.Close()
+
+	if !tb.AllowRequest(batch{items: 6}) {
+		t.Fatal("request of 6 items denied")
+	}
+	// unknown request has no estimated cost, weight of one request is used
+	if !tb.AllowRequest("ping") {
+		t.Fatal("request of default weight denied")
+	}
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+	if tb.AllowRequest(batch{items: 11}) {
+		t.Fatal("request over max tokens allowed")
+	}
+	plain := NewTokenBucket(2, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer plain.Close()
+
+	if !plain.AllowRequest(batch{items: 100}) || plain.Tokens() != 1 {
+		t.Fatal("bucket without estimator must use weight of one request")
+	}
+}
+
+func TestZeroRefillRateQuota(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(3, 0, SetClock(clock))
+	defer tb.Close()
+
+	if !tb.AllowN(3) {
+		t.Fatal("quota denied")
+	}
+	clock.Advance(100 * 365 * 24 * time.Hour)
+
+	if tb.Allow() {
+		t.Fatal("zero refill rate bucket refilled")
+	}
+	if got := tb.DelayN(1); got != infDuration {
+		t.Fatalf("got delay %s, want never", got)
+	}
+	if err := tb.Wait(context.Background()); err == nil {
+		t.Fatal("wait on exhausted quota must fail instead of blocking forever")
+	}
+}
+
+func TestAllowMany(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 2, SetClock(clock))
+	defer tb.Close()
+
+	if got := tb.AllowMany(3); got != 3 {
+		t.Fatalf("got %d granted, want 3", got)
+	}
+	if got := tb.AllowMany(10); got != 2 {
+		t.Fatalf("got %d granted, want the remaining 2", got)
+	}
+	if got := tb.AllowMany(1); got != 0 {
+		t.Fatalf("empty bucket granted %d", got)
+	}
+	if got := tb.AllowMany(-1); got != 0 {
+		t.Fatalf("negative request granted %d", got)
+	}
+	if s := tb.Stats(); s.Denied != 1 {
+		t.Fatalf("got %d denied, want only the request to the empty bucket", s.Denied)
+	}
+	clock.Advance(time.Second)
+
+	if got := tb.AllowMany(10); got != 2 {
+		t.Fatalf("got %d granted after refill, want 2", got)
+	}
+}
+
+func TestDrainN(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 1, SetClock(clock))
+	defer tb.Close()
+
+	if got := tb.DrainN(3); got != 3 {
+		t.Fatalf("got %d drained tokens, want 3", got)
+	}
+	if got := tb.DrainN(10); got != 2 {
+		t.Fatalf("got %d drained tokens, want 2 left", got)
+	}
+	if got := tb.DrainN(1); got != 0 {
+		t.Fatalf("got %d drained tokens of empty bucket, want 0", got)
+	}
+	if got := tb.DrainN(-1); got != 0 {
+		t.Fatalf("got %d drained tokens for negative n, want 0", got)
+	}
+	clock.Advance(2 * time.Second)
+
+	// the refill is credited before the tokens are drained
+	if got := tb.DrainN(1); got != 1 {
+		t.Fatalf("got %d drained tokens, want 1", got)
+	}
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1", got)
+	}
+}
+
+func TestNoCopy(t *testing.T) {
+	// go vet copylocks reports copies of values having a field which pointer implements sync.Locker
+	field, ok := reflect.TypeOf((*TokenBucket)(nil)).Elem().FieldByName("noCopy")
+
+	if !ok || !reflect.PointerTo(field.Type).Implements(reflect.TypeOf((*sync.Locker)(nil)).Elem()) {
+		t.Fatal("bucket must have noCopy marker implementing sync.Locker")
+	}
+	if field.Type.Size() != 0 {
+		t.Fatalf("got marker size %d, want 0", field.Type.Size())
+	}
+}
+
+func TestSetReserve(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 1, SetClock(clock), SetReserve(2))
+	defer tb.Close()
+
+	if !tb.AllowN(3) {
+		t.Fatal("tokens over the reserve must be allowed")
+	}
+	if tb.Allow() {
+		t.Fatal("reserved tokens consumed by Allow")
+	}
+	if !tb.AllowReserved(2) || tb.AllowReserved(1) {
+		t.Fatal("AllowReserved must consume the reserve and no more")
+	}
+	clock.Advance(3 * time.Second)
+
+	if !tb.Allow() || tb.Allow() {
+		t.Fatal("only the refilled token over the reserve must be allowed")
+	}
+	clone := tb.Clone()
+	defer clone.Close()
+
+	if clone.reserve != 2 {
+		t.Fatalf("clone: got reserve %d, want 2", clone.reserve)
+	}
+}
+
+func TestFillRatio(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(4, 1, SetClock(clock))
+	defer tb.Close()
+
+	if got := tb.FillRatio(); got != 1 {
+		t.Fatalf("full bucket: got ratio %v, want 1", got)
+	}
+	tb.AllowN(3)
+
+	if got := tb.FillRatio(); got != 0.25 {
+		t.Fatalf("got ratio %v, want 0.25", got)
+	}
+	tb.AllowDebt(3, 2)
+
+	if got := tb.FillRatio(); got != 0 {
+		t.Fatalf("bucket in debt: got ratio %v, want 0", got)
+	}
+	clock.Advance(4 * time.Second)
+
+	// the refill is credited first
+	if got := tb.FillRatio(); got != 0.5 {
+		t.Fatalf("got ratio %v, want 0.5", got)
+	}
+	tb.SetMaxTokensGraceful(1)
+
+	if got := tb.FillRatio(); got != 1 {
+		t.Fatalf("surplus over max tokens: got ratio %v, want 1", got)
+	}
+}
+
+func TestAllowOne(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(3, 1, SetClock(clock), SetTokenN(2))
+	defer tb.Close()
+
+	// one token regardless of the weight for one operation
+	if !tb.AllowOne() || tb.Tokens() != 2 {
+		t.Fatalf("got %d tokens, want 2", tb.Tokens())
+	}
+	if !tb.Allow() || tb.AllowOne() {
+		t.Fatal("AllowOne must deny the empty bucket")
+	}
+	clock.Advance(time.Second)
+
+	if !tb.AllowOne() {
+		t.Fatal("AllowOne must consume the refilled token")
+	}
+}
@@ -0,0 +1,54 @@
+package token_bucket
+
+// Logger receives events of the bucket, e.g. "refill" with credited tokens or "deny" with requested tokens.
+// events are logged after the bucket lock is released, so the logger may use the bucket
+type Logger interface {
+	Log(event string, fields map[string]any)
+}
+
+// LoggerFunc adapts function to Logger
+type LoggerFunc func(event string, fields map[string]any)
+
+// Log implements Logger
+func (fn LoggerFunc) Log(event string, fields map[string]any) {
+	fn(event, fields)
+}
+
+// SetLogger set logger of refill and deny events. default: none, nothing is logged
+func SetLogger(l Logger) Option {
+	return func(tb *TokenBucket) {
+		tb.logger = l
+	}
+}
+
+// logRefill schedules logging of tokens credited by refill.
+// must be called under the lock
+func (tb *TokenBucket) logRefill(credited int64) {
+	if tb.logger == nil {
+		return
+	}
+	l, fields := tb.logger, map[string]any{
+		"bucket":   tb.name,
+		"credited": credited,
+		"tokens":   tb.currTokens,
+	}
+	tb.later(func() {
+		l.Log("refill", fields)
+	})
+}
+
+// logDeny schedules logging of denied 'n' tokens.
+// must be called under the lock
+func (tb *TokenBucket) logDeny(n int) {
+	if tb.logger == nil {
+		return
+	}
+	l, fields := tb.logger, map[string]any{
+		"bucket":    tb.name,
+		"requested": n,
+		"tokens":    tb.currTokens,
+	}
+	tb.later(func() {
+		l.Log("deny", fields)
+	})
+}
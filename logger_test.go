@@ -0,0 +1,44 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetLogger(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	type event struct {
+		name   string
+		fields map[string]any
+	}
+	var (
+		events []event
+		tb     *TokenBucket
+	)
+	tb = NewTokenBucket(2, 1,
+		SetName("api"),
+		SetLogger(LoggerFunc(func(name string, fields map[string]any) {
+			events = append(events, event{name, fields})
+			// events are logged outside of the lock, so the logger may use the bucket
+			tb.Capacity()
+		})),
+		SetClock(clock),
+	)
+	defer tb.Close()
+
+	tb.AllowN(2)
+	tb.Allow()
+	clock.Advance(time.Second)
+	tb.Allow()
+
+	if len(events) != 2 {
+		t.Fatalf("got events %+v, want deny and refill", events)
+	}
+	if e := events[0]; e.name != "deny" || e.fields["requested"] != 1 || e.fields["bucket"] != "api" {
+		t.Fatalf("got %+v, want deny of 1 token", e)
+	}
+	if e := events[1]; e.name != "refill" || e.fields["credited"] != int64(1) {
+		t.Fatalf("got %+v, want refill of 1 token", e)
+	}
+}
@@ -0,0 +1,134 @@
+package token_bucket
+
+import (
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// MultiLimiter
+//
+//	combines several buckets, a request is allowed only if every bucket allows it,
+//	e.g. 10 requests per second and 100 requests per minute
+//
+//	Fields:
+//
+//	[buckets]   combined buckets
+//	[ordered]   unique buckets ordered by address to lock without deadlocks
+type MultiLimiter struct {
+	buckets []*TokenBucket
+	ordered []*TokenBucket
+}
+
+var _ Limiter = (*MultiLimiter)(nil)
+
+// NewMultiLimiter returns new MultiLimiter entity instance
+func NewMultiLimiter(buckets ...*TokenBucket) *MultiLimiter {
+	return &MultiLimiter{
+		buckets: buckets,
+		ordered: lockOrder(buckets),
+	}
+}
+
+// lockOrder returns unique buckets ordered by address.
+// locking buckets in this order from every place prevents deadlocks
+func lockOrder(buckets []*TokenBucket) []*TokenBucket {
+	seen := make(map[*TokenBucket]struct{}, len(buckets))
+	ordered := make([]*TokenBucket, 0, len(buckets))
+
+	for _, tb := range buckets {
+		if _, ok := seen[tb]; ok {
+			continue
+		}
+		seen[tb] = struct{}{}
+		ordered = append(ordered, tb)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return uintptr(unsafe.Pointer(ordered[i])) < uintptr(unsafe.Pointer(ordered[j]))
+	})
+
+	return ordered
+}
+
+// lockAll locks all buckets in order
+func lockAll(ordered []*TokenBucket) {
+	for _, tb := range ordered {
+		tb.lock.Lock()
+	}
+}
+
+// unlockAll unlocks all buckets and then runs the callbacks scheduled under the locks
+func unlockAll(ordered []*TokenBucket) {
+	var pending []func()
+
+	for i := len(ordered) - 1; i >= 0; i-- {
+		pending = append(pending, ordered[i].pending...)
+		ordered[i].pending = nil
+
+		ordered[i].lock.Unlock()
+	}
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+// useWeight passed instead of tokens number consumes weight for one operation of every bucket.
+// public methods deny negative tokens number, so it is never requested by the caller
+const useWeight = math.MinInt
+
+// allowAll consumes 'n' tokens from every bucket or from none of them.
+// bucket weight for one operation is used if 'n' is useWeight.
+// returns index of the first denying bucket or -1.
+// must be called under the locks of all buckets
+func allowAll(buckets []*TokenBucket, n int) int {
+	for _, tb := range buckets {
+		tb.refill()
+	}
+	var stack [4]int64
+
+	taken := stack[:0]
+
+	for i, tb := range buckets {
+		if consumed, ok := tb.takeN(weightOf(tb, n)); ok {
+			taken = append(taken, consumed)
+			continue
+		}
+		// refund exactly the tokens consumed from the buckets which allowed,
+		// they may be less than the weight for clamped oversize requests or dry run debt
+		for j, prev := range buckets[:i] {
+			prev.untake(taken[j])
+		}
+		return i
+	}
+	return -1
+}
+
+// weightOf returns 'n' or the bucket weight for one operation if 'n' is useWeight
+func weightOf(tb *TokenBucket, n int) int {
+	if n == useWeight {
+		return tb.Weight()
+	}
+	return n
+}
+
+// AllowN return 'true' if there are 'n' tokens in every bucket.
+// tokens are consumed from every bucket atomically or not consumed at all, negative 'n' is always denied
+func (ml *MultiLimiter) AllowN(n int) bool {
+	if n < 0 {
+		return false
+	}
+	return ml.allowN(n)
+}
+
+// Allow returns 'true' if every bucket has enough tokens for its weight of one operation
+func (ml *MultiLimiter) Allow() bool {
+	return ml.allowN(useWeight)
+}
+
+// allowN consumes 'n' tokens or the weights if 'n' is useWeight from every bucket atomically
+func (ml *MultiLimiter) allowN(n int) bool {
+	lockAll(ml.ordered)
+	defer unlockAll(ml.ordered)
+
+	return allowAll(ml.buckets, n) < 0
+}
@@ -0,0 +1,128 @@
+package token_bucket
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMultiLimiterRefundDryRunDebt(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	dry := NewTokenBucket(10, 1, SetClock(clock), SetDryRun(true))
+	defer dry.Close()
+
+	empty := NewTokenBucket(1, 1, SetClock(clock))
+	defer empty.Close()
+
+	dry.AllowN(8)
+	empty.AllowN(1)
+
+	// the dry run debt is bounded, so the bucket consumes 12 of 15 tokens before the empty bucket denies
+	if NewMultiLimiter(dry, empty).AllowN(15) {
+		t.Fatal("empty bucket must deny")
+	}
+	if got := dry.Tokens(); got != 2 {
+		t.Fatalf("refund must return exactly the consumed tokens: got %d tokens, want 2", got)
+	}
+}
+
+func TestMultiLimiterRefundClampedOversize(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	clamped := NewTokenBucket(3, 1, SetClock(clock), SetOversizePolicy(OversizeClampToMax))
+	defer clamped.Close()
+
+	empty := NewTokenBucket(1, 1, SetClock(clock))
+	defer empty.Close()
+
+	empty.AllowN(1)
+
+	if NewMultiLimiter(clamped, empty).AllowN(5) {
+		t.Fatal("empty bucket must deny")
+	}
+	if got := clamped.Tokens(); got != 3 {
+		t.Fatalf("got %d tokens, want 3", got)
+	}
+	if got := clamped.Stats().Consumed; got != 0 {
+		t.Fatalf("refunded tokens must not be counted as consumed: got %d", got)
+	}
+}
+
+func TestChildBucketRefund(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	parent := NewTokenBucket(1, 1, SetClock(clock))
+	defer parent.Close()
+
+	cb := NewChildBucket(parent, 10, 1, SetClock(clock), SetDryRun(true))
+	defer cb.Bucket().Close()
+
+	cb.Bucket().AllowN(8)
+	parent.AllowN(1)
+
+	if cb.AllowN(15) {
+		t.Fatal("empty parent must deny")
+	}
+	if got := cb.Bucket().Tokens(); got != 2 {
+		t.Fatalf("child must get back exactly the consumed tokens: got %d, want 2", got)
+	}
+}
+
+func TestMultiLimiterNegativeN(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	a := NewTokenBucket(10, 1, SetClock(clock), SetTokenN(2))
+	defer a.Close()
+
+	b := NewTokenBucket(10, 1, SetClock(clock))
+	defer b.Close()
+
+	ml := NewMultiLimiter(a, b)
+
+	if ml.AllowN(-5) || ml.AllowN(math.MinInt) {
+		t.Fatal("negative tokens number allowed")
+	}
+	if a.Tokens() != 10 || b.Tokens() != 10 {
+		t.Fatal("denied negative request consumed tokens")
+	}
+	// Allow still consumes the weight of every bucket
+	if !ml.Allow() {
+		t.Fatal("request within tokens denied")
+	}
+	if a.Tokens() != 8 || b.Tokens() != 9 {
+		t.Fatalf("got %d and %d tokens, want the weights 2 and 1 consumed", a.Tokens(), b.Tokens())
+	}
+	cb := NewChildBucket(a, 5, 1, SetClock(clock))
+	defer cb.Bucket().Close()
+
+	if cb.AllowN(-1) {
+		t.Fatal("negative tokens number allowed by child")
+	}
+	if got := cb.Bucket().Tokens(); got != 5 {
+		t.Fatalf("got %d child tokens, want 5", got)
+	}
+}
+
+func TestMultiLimiterRefundSurplus(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	burst := NewTokenBucket(10, 1, SetClock(clock), SetBurst(25))
+	defer burst.Close()
+
+	empty := NewTokenBucket(1, 1, SetClock(clock))
+	defer empty.Close()
+
+	empty.AllowN(1)
+
+	if NewMultiLimiter(burst, empty).AllowN(5) {
+		t.Fatal("empty bucket must deny")
+	}
+	// the refund restores the surplus over the capacity instead of clamping it
+	if got := burst.Tokens(); got != 25 {
+		t.Fatalf("got %d tokens, want the 25 tokens of the burst back", got)
+	}
+	if s := burst.Stats(); s.Allowed != 0 || s.Consumed != 0 {
+		t.Fatalf("got stats %+v, want the refunded request not counted", s)
+	}
+}
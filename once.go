@@ -0,0 +1,117 @@
+package token_bucket
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// OnceLimiter
+//
+//	consumes tokens at most once per request ID, so retried requests are not charged twice.
+//	recently seen IDs are kept in LRU bounded by size and TTL
+//
+//	Fields:
+//
+//	[tb]      limiting bucket
+//	[size]    maximum number of remembered IDs
+//	[ttl]     duration the decision for the ID is remembered
+//	[order]   remembered IDs from the most to the least recently seen
+//	[seen]    elements of 'order' by ID
+//	[lock]    mutex for atomic operations
+type OnceLimiter struct {
+	tb    *TokenBucket
+	size  int
+	ttl   time.Duration
+	order *list.List
+	seen  map[string]*list.Element
+	lock  sync.Mutex
+}
+
+// onceEntry
+//
+//	remembered decision for the request ID
+//
+//	Fields:
+//
+//	[id]        request ID
+//	[allowed]   decision of the first request with the ID
+//	[seenT]     time of the first request with the ID
+type onceEntry struct {
+	id      string
+	allowed bool
+	seenT   time.Time
+}
+
+// NewOnceLimiter returns new OnceLimiter entity instance remembering at most 'size' IDs for 'ttl'.
+// the least recently seen ID is forgotten when the size is exceeded, non-positive size means no limit
+func NewOnceLimiter(tb *TokenBucket, size int, ttl time.Duration) *OnceLimiter {
+	return &OnceLimiter{
+		tb:    tb,
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		seen:  map[string]*list.Element{},
+	}
+}
+
+// AllowOnce return 'true' if there are 'n' tokens in the bucket for the request with the ID.
+// repeated request with the same ID within the TTL gets the decision of the first one
+// without consuming tokens again. requests with empty ID are never deduplicated
+func (ol *OnceLimiter) AllowOnce(id string, n int) bool {
+	if id == "" {
+		return ol.tb.AllowN(n)
+	}
+	ol.lock.Lock()
+	defer ol.lock.Unlock()
+
+	nowT := ol.tb.now()
+
+	if elem, ok := ol.seen[id]; ok {
+		entry := elem.Value.(*onceEntry)
+
+		if nowT.Sub(entry.seenT) < ol.ttl {
+			ol.order.MoveToFront(elem)
+			return entry.allowed
+		}
+		ol.remove(elem)
+	}
+	allowed := ol.tb.AllowN(n)
+
+	ol.seen[id] = ol.order.PushFront(&onceEntry{
+		id:      id,
+		allowed: allowed,
+		seenT:   nowT,
+	})
+	ol.evict(nowT)
+
+	return allowed
+}
+
+// Len returns number of remembered IDs
+func (ol *OnceLimiter) Len() int {
+	ol.lock.Lock()
+	defer ol.lock.Unlock()
+
+	return ol.order.Len()
+}
+
+// evict forgets expired IDs and the least recently seen ones over the size.
+// must be called under the lock
+func (ol *OnceLimiter) evict(nowT time.Time) {
+	for elem := ol.order.Back(); elem != nil; elem = ol.order.Back() {
+		entry := elem.Value.(*onceEntry)
+
+		if nowT.Sub(entry.seenT) < ol.ttl && (ol.size <= 0 || ol.order.Len() <= ol.size) {
+			return
+		}
+		ol.remove(elem)
+	}
+}
+
+// remove forgets the ID of the element.
+// must be called under the lock
+func (ol *OnceLimiter) remove(elem *list.Element) {
+	delete(ol.seen, elem.Value.(*onceEntry).id)
+	ol.order.Remove(elem)
+}
@@ -0,0 +1,51 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnceLimiter(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock), SetRefillDuration(time.Hour))
+	defer tb.Close()
+
+	ol := NewOnceLimiter(tb, 2, 30*time.Minute)
+
+	steps := []struct {
+		id   string
+		want bool
+	}{
+		{"a", true},
+		{"a", true}, // retry is not charged
+		{"b", true},
+		{"a", true}, // remembered, "a" becomes the most recently seen
+		{"c", false},
+		{"b", false}, // "b" is forgotten by the size, so it is decided again
+	}
+	for i, step := range steps {
+		if got := ol.AllowOnce(step.id, 1); got != step.want {
+			t.Fatalf("step %d, ID %q: got %t, want %t", i, step.id, got, step.want)
+		}
+	}
+	if got := ol.Len(); got != 2 {
+		t.Fatalf("got %d remembered IDs, want 2", got)
+	}
+	clock.Advance(10 * time.Minute)
+
+	if ol.AllowOnce("c", 1) {
+		t.Fatal("denial must be remembered within the TTL")
+	}
+	clock.Advance(time.Hour)
+
+	// requests without ID are charged every time
+	if !ol.AllowOnce("", 1) || ol.AllowOnce("", 1) {
+		t.Fatal("requests without ID must not be deduplicated")
+	}
+	clock.Advance(time.Hour)
+
+	if !ol.AllowOnce("c", 1) {
+		t.Fatal("expired ID must be decided again")
+	}
+}
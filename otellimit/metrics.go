@@ -0,0 +1,85 @@
+package otellimit
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+const scopeName = "github.com/UshakovN/token-bucket/otellimit" // instrumentation scope
+
+// config
+//
+//	options of Register
+//
+//	For Options:
+//
+//	[name] value of 'bucket' attribute. default: name of the bucket
+//	[attrs] attributes recorded with every measurement. default: none
+type config struct {
+	name  string
+	attrs []attribute.KeyValue
+}
+
+// Option for Register
+type Option func(*config)
+
+// SetName set 'bucket' attribute identifying the bucket,
+// the bucket name is used if it is not set
+func SetName(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
+
+// SetAttributes set attributes recorded with every measurement
+func SetAttributes(attrs ...attribute.KeyValue) Option {
+	return func(c *config) {
+		c.attrs = append(c.attrs, attrs...)
+	}
+}
+
+// Register registers observable instruments reporting the bucket tokens
+// and allowed and denied counters. unregister the returned registration to stop reporting
+func Register(provider metric.MeterProvider, tb *token_bucket.TokenBucket, options ...Option) (metric.Registration, error) {
+	c := &config{
+		name: tb.Name(),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	if c.name != "" {
+		c.attrs = append(c.attrs, attribute.String("bucket", c.name))
+	}
+	meter := provider.Meter(scopeName)
+
+	tokens, err := meter.Int64ObservableGauge("token_bucket.tokens",
+		metric.WithDescription("Current number of tokens in the bucket."))
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := meter.Int64ObservableCounter("token_bucket.allowed",
+		metric.WithDescription("Number of allowed requests or operations."))
+	if err != nil {
+		return nil, err
+	}
+	denied, err := meter.Int64ObservableCounter("token_bucket.denied",
+		metric.WithDescription("Number of denied requests or operations."))
+	if err != nil {
+		return nil, err
+	}
+	attrs := metric.WithAttributes(c.attrs...)
+
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := tb.Stats()
+
+		o.ObserveInt64(tokens, int64(stats.Tokens), attrs)
+		o.ObserveInt64(allowed, stats.Allowed, attrs)
+		o.ObserveInt64(denied, stats.Denied, attrs)
+
+		return nil
+	}, tokens, allowed, denied)
+}
@@ -0,0 +1,109 @@
+package otellimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// fakeProvider records the registered callback, so the test collects the measurements by hand
+type fakeProvider struct {
+	noop.MeterProvider
+	meter *fakeMeter
+}
+
+func (p *fakeProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return p.meter
+}
+
+type fakeMeter struct {
+	noop.Meter
+	callback metric.Callback
+}
+
+type int64Gauge struct {
+	noop.Int64ObservableGauge
+	name string
+}
+
+type int64Counter struct {
+	noop.Int64ObservableCounter
+	name string
+}
+
+func (m *fakeMeter) Int64ObservableGauge(name string, _ ...metric.Int64ObservableGaugeOption) (metric.Int64ObservableGauge, error) {
+	return int64Gauge{name: name}, nil
+}
+
+func (m *fakeMeter) Int64ObservableCounter(name string, _ ...metric.Int64ObservableCounterOption) (metric.Int64ObservableCounter, error) {
+	return int64Counter{name: name}, nil
+}
+
+func (m *fakeMeter) RegisterCallback(fn metric.Callback, _ ...metric.Observable) (metric.Registration, error) {
+	m.callback = fn
+	return noop.Registration{}, nil
+}
+
+// fakeObserver keeps observed values and attributes by instrument name
+type fakeObserver struct {
+	embedded.Observer
+	values map[string]int64
+	attrs  attribute.Set
+}
+
+func (o *fakeObserver) ObserveFloat64(metric.Float64Observable, float64, ...metric.ObserveOption) {}
+
+func (o *fakeObserver) ObserveInt64(obsrv metric.Int64Observable, value int64, opts ...metric.ObserveOption) {
+	switch inst := obsrv.(type) {
+	case int64Gauge:
+		o.values[inst.name] = value
+	case int64Counter:
+		o.values[inst.name] = value
+	}
+	o.attrs = metric.NewObserveConfig(opts).Attributes()
+}
+
+func TestRegister(t *testing.T) {
+	tb := token_bucket.NewTokenBucket(3, 1,
+		token_bucket.SetName("api"),
+		token_bucket.SetClock(token_bucket.NewTestClock(time.Unix(0, 0))),
+	)
+	defer tb.Close()
+
+	provider := &fakeProvider{meter: &fakeMeter{}}
+
+	if _, err := Register(provider, tb, SetAttributes(attribute.String("region", "eu"))); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	tb.AllowN(2)
+	tb.AllowN(2)
+
+	o := &fakeObserver{values: map[string]int64{}}
+
+	if err := provider.meter.callback(context.Background(), o); err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	want := map[string]int64{
+		"token_bucket.tokens":  1,
+		"token_bucket.allowed": 1,
+		"token_bucket.denied":  1,
+	}
+	for name, v := range want {
+		if got := o.values[name]; got != v {
+			t.Fatalf("%s: got %d, want %d", name, got, v)
+		}
+	}
+	if v, _ := o.attrs.Value("bucket"); v.AsString() != "api" {
+		t.Fatalf("got bucket attribute %q, want the bucket name", v.AsString())
+	}
+	if v, _ := o.attrs.Value("region"); v.AsString() != "eu" {
+		t.Fatalf("got region attribute %q, want eu", v.AsString())
+	}
+}
@@ -0,0 +1,27 @@
+package token_bucket
+
+// OversizePolicy of requests for more tokens than 'maxTokens'
+type OversizePolicy int
+
+const (
+	OversizeReject     OversizePolicy = iota // requests over 'maxTokens' are always denied
+	OversizeClampToMax                       // requests over 'maxTokens' consume the whole full bucket
+)
+
+// SetOversizePolicy set handling of requests over 'maxTokens', e.g. a single oversized job which must eventually run.
+// with OversizeClampToMax the request is allowed only when the bucket is full and consumes all of it,
+// so it may starve under steady traffic which never lets the bucket fill completely
+func SetOversizePolicy(policy OversizePolicy) Option {
+	return func(tb *TokenBucket) {
+		tb.oversize = policy
+	}
+}
+
+// clampOversize returns 'n' clamped to 'maxTokens' if OversizeClampToMax is set.
+// must be called under the lock
+func (tb *TokenBucket) clampOversize(n int) int {
+	if tb.oversize == OversizeClampToMax && int64(n) > tb.maxTokens {
+		return int(tb.maxTokens)
+	}
+	return n
+}
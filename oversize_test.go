@@ -0,0 +1,56 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetOversizePolicy(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	reject := NewTokenBucket(3, 1, SetClock(clock))
+	defer reject.Close()
+
+	if reject.AllowN(5) {
+		t.Fatal("oversized request allowed by default")
+	}
+	if err := reject.WaitN(context.Background(), 5); !errors.Is(err, ErrExceedsMaxTokens) {
+		t.Fatalf("got error %v, want ErrExceedsMaxTokens", err)
+	}
+	clamp := NewTokenBucket(3, 1, SetClock(clock), SetOversizePolicy(OversizeClampToMax))
+	defer clamp.Close()
+
+	if !clamp.AllowN(5) {
+		t.Fatal("oversized request must consume the full bucket")
+	}
+	if s := clamp.Stats(); s.Tokens != 0 || s.Consumed != 3 {
+		t.Fatalf("got stats %+v, want the whole bucket of 3 consumed", s)
+	}
+	clock.Advance(2 * time.Second)
+
+	// the clamped request needs the full bucket
+	if clamp.AllowN(5) {
+		t.Fatal("oversized request allowed by bucket which is not full")
+	}
+	r := clamp.ReserveN(5)
+
+	if !r.OK() || r.DelayFrom(clock.Now()) != time.Second {
+		t.Fatalf("got reservation ok %t with delay %s, want 1s until the bucket is full", r.OK(), r.DelayFrom(clock.Now()))
+	}
+	r.Cancel()
+
+	// the cancellation returns the clamped tokens, not the requested ones
+	if got := clamp.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+	clone := clamp.Clone()
+	defer clone.Close()
+
+	clock.Advance(time.Second)
+
+	if !clone.AllowN(5) {
+		t.Fatal("clone must keep the oversize policy")
+	}
+}
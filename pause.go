@@ -0,0 +1,68 @@
+package token_bucket
+
+// Pause stops granting tokens and refilling the bucket, tokens accumulated before are kept.
+// consumption is denied and Wait returns ErrPaused until Resume
+func (tb *TokenBucket) Pause() {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	if tb.paused {
+		return
+	}
+	tb.refill()
+
+	tb.paused = true
+	tb.pausedT = tb.now()
+}
+
+// Resume restarts refilling from the paused point.
+// the paused interval credits nothing: filling times are shifted by its duration,
+// so progress of the interval interrupted by Pause is kept
+func (tb *TokenBucket) Resume() {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	if !tb.paused {
+		return
+	}
+	tb.paused = false
+
+	paused := tb.now().Sub(tb.pausedT)
+	if paused < 0 {
+		paused = 0
+	}
+	tb.lastFillT = tb.lastFillT.Add(paused)
+	tb.refillT = tb.refillT.Add(paused)
+}
+
+// Paused returns 'true' if the bucket is paused
+func (tb *TokenBucket) Paused() bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.paused
+}
+
+// BeginDrain denies all consumption for graceful shutdown, the in-flight work is not affected.
+// unlike Pause it is irreversible and reported by IsDraining,
+// so callers can reply "shutting down" instead of "rate limited". safe to call multiple times
+func (tb *TokenBucket) BeginDrain() {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.draining = true
+}
+
+// IsDraining returns 'true' if BeginDrain was called
+func (tb *TokenBucket) IsDraining() bool {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.draining
+}
+
+// denying returns 'true' if the bucket denies any consumption.
+// must be called under the lock
+func (tb *TokenBucket) denying() bool {
+	return tb.paused || tb.draining
+}
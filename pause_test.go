@@ -0,0 +1,70 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(4)
+	clock.Advance(600 * time.Millisecond)
+	tb.Pause()
+	tb.Pause()
+
+	if !tb.Paused() || tb.Allow() {
+		t.Fatal("paused bucket must deny")
+	}
+	if err := tb.Wait(context.Background()); !errors.Is(err, ErrPaused) {
+		t.Fatalf("got error %v, want ErrPaused", err)
+	}
+	clock.Advance(time.Hour)
+	tb.Resume()
+
+	// the paused hour credits nothing, the interrupted interval continues from 600ms
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1 kept from before the pause", got)
+	}
+	clock.Advance(400 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+}
+
+func TestBeginDrain(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.BeginDrain()
+	tb.BeginDrain()
+
+	if !tb.IsDraining() || tb.Paused() {
+		t.Fatal("draining bucket must be reported apart from pause")
+	}
+	if tb.Allow() || tb.AllowDebt(1, 5) || tb.AllowMany(1) != 0 || tb.AllowReserved(1) {
+		t.Fatal("draining bucket must deny any consumption")
+	}
+	if err := tb.Wait(context.Background()); !errors.Is(err, ErrDraining) {
+		t.Fatalf("got error %v, want ErrDraining", err)
+	}
+	tb.Resume()
+
+	if tb.Allow() {
+		t.Fatal("Resume must not end draining")
+	}
+	clone := tb.Clone()
+	defer clone.Close()
+
+	if !clone.IsDraining() {
+		t.Fatal("clone must keep draining")
+	}
+}
@@ -0,0 +1,160 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrShed returned to the queued request dropped in favor of a newer request
+var ErrShed = errors.New("token_bucket: request shed by newer request")
+
+// DenialPolicy of PolicyLimiter on overload
+type DenialPolicy int
+
+const (
+	PolicyReject     DenialPolicy = iota // deny immediately if there are not enough tokens
+	PolicyQueue                          // wait for tokens if the queue is not full, deny otherwise
+	PolicyShedOldest                     // wait for tokens, the oldest queued request is dropped if the queue is full
+)
+
+// PolicyLimiter
+//
+//	applies the denial policy to requests the bucket can not allow immediately
+//
+//	Fields:
+//
+//	[tb]         limiting bucket
+//	[policy]     behaviour on overload
+//	[maxQueue]   maximum number of requests waiting for tokens
+//	[queue]      requests waiting for tokens in order of arrival
+//	[lock]       mutex for atomic queue operations
+type PolicyLimiter struct {
+	tb       *TokenBucket
+	policy   DenialPolicy
+	maxQueue int
+	queue    []*policyWaiter
+	lock     sync.Mutex
+}
+
+// policyWaiter
+//
+//	request waiting for tokens in PolicyLimiter
+//
+//	Fields:
+//
+//	[cancel]   stops waiting of the request
+//	[shed]     request is dropped in favor of a newer request
+type policyWaiter struct {
+	cancel context.CancelFunc
+	shed   bool
+}
+
+var _ Limiter = (*PolicyLimiter)(nil)
+
+// NewPolicyLimiter returns new PolicyLimiter entity instance.
+// at most 'maxQueue' requests wait for tokens, non-positive 'maxQueue' means PolicyReject for any policy
+func NewPolicyLimiter(tb *TokenBucket, policy DenialPolicy, maxQueue int) *PolicyLimiter {
+	return &PolicyLimiter{
+		tb:       tb,
+		policy:   policy,
+		maxQueue: maxQueue,
+	}
+}
+
+// AcquireN consumes 'n' tokens applying the denial policy if they are not available.
+// PolicyReject returns *ErrRateLimited, PolicyQueue returns ErrQueueFull if 'maxQueue' requests already wait
+// and PolicyShedOldest returns ErrShed to the oldest waiting request admitting the new one instead.
+// waiting requests get errors of WaitN, e.g. ctx.Err()
+func (pl *PolicyLimiter) AcquireN(ctx context.Context, n int) error {
+	if pl.policy == PolicyReject || pl.maxQueue <= 0 {
+		return pl.tb.AllowNErr(n)
+	}
+	if pl.tb.DelayN(n) == 0 && pl.tb.AllowN(n) {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &policyWaiter{
+		cancel: cancel,
+	}
+	if !pl.enqueue(w) {
+		return ErrQueueFull
+	}
+	defer pl.dequeue(w)
+
+	err := pl.tb.WaitN(ctx, n)
+
+	if err != nil && pl.isShed(w) {
+		return ErrShed
+	}
+	return err
+}
+
+// Acquire consumes weight of one request or operation applying the denial policy
+func (pl *PolicyLimiter) Acquire(ctx context.Context) error {
+	return pl.AcquireN(ctx, pl.tb.Weight())
+}
+
+// AllowN return 'true' if 'n' tokens are available in the bucket now and consumes them.
+// it never blocks and never joins the queue for any policy, use AcquireN to wait
+func (pl *PolicyLimiter) AllowN(n int) bool {
+	return pl.tb.AllowN(n)
+}
+
+// Allow return 'true' if weight of one request or operation is available in the bucket now and consumes it.
+// it never blocks and never joins the queue for any policy, use Acquire to wait
+func (pl *PolicyLimiter) Allow() bool {
+	return pl.AllowN(pl.tb.Weight())
+}
+
+// Queued returns number of requests waiting for tokens
+func (pl *PolicyLimiter) Queued() int {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	return len(pl.queue)
+}
+
+// enqueue adds the waiter in the queue, the oldest waiter is shed if the queue is full and the policy allows.
+// returns 'false' if the queue is full
+func (pl *PolicyLimiter) enqueue(w *policyWaiter) bool {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	if len(pl.queue) >= pl.maxQueue {
+		if pl.policy != PolicyShedOldest {
+			return false
+		}
+		oldest := pl.queue[0]
+		oldest.shed = true
+		oldest.cancel()
+
+		pl.queue = pl.queue[1:]
+	}
+	pl.queue = append(pl.queue, w)
+
+	return true
+}
+
+// dequeue removes the waiter from the queue if it is not shed yet
+func (pl *PolicyLimiter) dequeue(w *policyWaiter) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	for i, queued := range pl.queue {
+		if queued == w {
+			pl.queue = append(pl.queue[:i], pl.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// isShed returns 'true' if the waiter is dropped in favor of a newer request
+func (pl *PolicyLimiter) isShed(w *policyWaiter) bool {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	return w.shed
+}
@@ -0,0 +1,49 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolicyLimiterAllowNeverBlocks(t *testing.T) {
+	for _, policy := range []DenialPolicy{PolicyReject, PolicyQueue, PolicyShedOldest} {
+		tb := NewTokenBucket(1, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+		pl := NewPolicyLimiter(tb, policy, 4)
+
+		done := make(chan [2]bool)
+
+		go func() {
+			done <- [2]bool{pl.Allow(), pl.Allow()}
+		}()
+		select {
+		case got := <-done:
+			if !got[0] || got[1] {
+				t.Fatalf("policy %d: got %v, want only the first request allowed", policy, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy %d: Allow must not block on the empty bucket", policy)
+		}
+		if pl.Queued() != 0 {
+			t.Fatalf("policy %d: Allow must not join the queue", policy)
+		}
+		tb.Close()
+	}
+}
+
+func TestPolicyLimiterReject(t *testing.T) {
+	tb := NewTokenBucket(1, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	pl := NewPolicyLimiter(tb, PolicyReject, 4)
+
+	if err := pl.Acquire(context.Background()); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	var limited *ErrRateLimited
+
+	if err := pl.Acquire(context.Background()); !errors.As(err, &limited) {
+		t.Fatalf("got error %v, want *ErrRateLimited", err)
+	}
+}
@@ -0,0 +1,119 @@
+package token_bucket
+
+const defaultReservedFraction = 0.1 // default fraction of capacity reserved for high priority
+
+// PriorityBucket
+//
+//	reserves a fraction of the bucket capacity for high priority requests:
+//	low priority requests consume only the shared pool, high priority requests
+//	consume the shared pool first and then the reserved pool
+//
+//	Fields:
+//
+//	[shared]     pool available for every request
+//	[reserved]   pool available only for high priority requests
+//	[ordered]    pools ordered by address to lock without deadlocks
+//
+//	For Options:
+//
+//	[fraction] fraction of capacity and refill rate in [0, 1] reserved for high priority. default: 0.1
+//	[options] options applied to both pools. default: none
+type PriorityBucket struct {
+	shared   *TokenBucket
+	reserved *TokenBucket
+	ordered  []*TokenBucket
+
+	fraction float64
+	options  []Option
+}
+
+var _ Limiter = (*PriorityBucket)(nil)
+
+// NewPriorityBucket returns new PriorityBucket entity instance.
+// 'maxTokens' and 'refillRate' are split between the shared and the reserved pools,
+// so both pools are refilled proportionally to their size
+func NewPriorityBucket(maxTokens, refillRate int, options ...PriorityOption) *PriorityBucket {
+	pb := &PriorityBucket{
+		fraction: defaultReservedFraction,
+	}
+
+	for _, opt := range options {
+		opt(pb)
+	}
+
+	if pb.fraction < 0 {
+		pb.fraction = 0
+	}
+	if pb.fraction > 1 {
+		pb.fraction = 1
+	}
+	reservedMax := int(float64(maxTokens) * pb.fraction)
+	reservedRate := int(float64(refillRate) * pb.fraction)
+
+	pb.shared = NewTokenBucket(maxTokens-reservedMax, refillRate-reservedRate, pb.options...)
+	pb.reserved = NewTokenBucket(reservedMax, reservedRate, pb.options...)
+	pb.ordered = lockOrder([]*TokenBucket{pb.shared, pb.reserved})
+
+	return pb
+}
+
+// PriorityOption for PriorityBucket entity
+type PriorityOption func(*PriorityBucket)
+
+// SetReservedFraction set fraction of capacity and refill rate in [0, 1] reserved for high priority
+func SetReservedFraction(fraction float64) PriorityOption {
+	return func(pb *PriorityBucket) {
+		pb.fraction = fraction
+	}
+}
+
+// SetPoolOptions set options applied to both pools
+func SetPoolOptions(options ...Option) PriorityOption {
+	return func(pb *PriorityBucket) {
+		pb.options = append(pb.options, options...)
+	}
+}
+
+// AllowPriority return 'true' if there are 'n' tokens for the request priority.
+// high priority request may take tokens from both pools at once
+func (pb *PriorityBucket) AllowPriority(n int, high bool) bool {
+	lockAll(pb.ordered)
+	defer unlockAll(pb.ordered)
+
+	pb.shared.refill()
+	pb.reserved.refill()
+
+	if !high || pb.shared.currTokens >= int64(n) {
+		return pb.shared.take(n)
+	}
+	fromShared := pb.shared.currTokens
+	if fromShared < 0 {
+		fromShared = 0
+	}
+	if !pb.reserved.take(n - int(fromShared)) {
+		return false
+	}
+	pb.shared.currTokens -= fromShared
+
+	return true
+}
+
+// AllowN return 'true' if there are 'n' tokens in the shared pool
+func (pb *PriorityBucket) AllowN(n int) bool {
+	return pb.AllowPriority(n, false)
+}
+
+// Allow returns 'true' if there are tokens for weight of one request in the shared pool
+func (pb *PriorityBucket) Allow() bool {
+	return pb.AllowPriority(pb.shared.Weight(), false)
+}
+
+// Shared returns pool available for every request
+func (pb *PriorityBucket) Shared() *TokenBucket {
+	return pb.shared
+}
+
+// Reserved returns pool available only for high priority requests
+func (pb *PriorityBucket) Reserved() *TokenBucket {
+	return pb.reserved
+}
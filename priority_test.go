@@ -0,0 +1,50 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriorityBucket(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	pb := NewPriorityBucket(10, 10, SetReservedFraction(0.2), SetPoolOptions(SetClock(clock)))
+	defer pb.Shared().Close()
+	defer pb.Reserved().Close()
+
+	if pb.Shared().Capacity() != 8 || pb.Reserved().Capacity() != 2 {
+		t.Fatalf("got pools of %d and %d tokens, want 8 and 2", pb.Shared().Capacity(), pb.Reserved().Capacity())
+	}
+	if !pb.AllowN(7) || pb.AllowN(2) {
+		t.Fatal("low priority must consume only the shared pool")
+	}
+	// high priority takes the last shared token and two reserved ones at once
+	if !pb.AllowPriority(3, true) {
+		t.Fatal("high priority denied with both pools")
+	}
+	if pb.Shared().Tokens() != 0 || pb.Reserved().Tokens() != 0 {
+		t.Fatalf("got %d shared and %d reserved tokens, want 0 and 0", pb.Shared().Tokens(), pb.Reserved().Tokens())
+	}
+	if pb.AllowPriority(1, true) {
+		t.Fatal("high priority allowed with empty pools")
+	}
+	clock.Advance(time.Second)
+
+	if got := pb.Reserved().Tokens(); got != 2 {
+		t.Fatalf("reserved pool must be refilled proportionally: got %d tokens, want 2", got)
+	}
+}
+
+func TestPriorityBucketHighDeniedKeepsShared(t *testing.T) {
+	pb := NewPriorityBucket(10, 10, SetReservedFraction(0.2), SetPoolOptions(SetClock(NewTestClock(time.Unix(0, 0)))))
+
+	pb.AllowN(7)
+
+	// one shared and two reserved tokens are not enough for four
+	if pb.AllowPriority(4, true) {
+		t.Fatal("high priority over both pools allowed")
+	}
+	if got := pb.Shared().Tokens(); got != 1 {
+		t.Fatalf("denied request must not consume the shared pool: got %d tokens, want 1", got)
+	}
+}
@@ -0,0 +1,93 @@
+package promlimit
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+const namespace = "token_bucket" // metrics namespace
+
+var (
+	tokensDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "tokens"),
+		"Current number of tokens in the bucket.",
+		[]string{"bucket"}, nil,
+	)
+	maxTokensDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "max_tokens"),
+		"Maximum number of tokens in the bucket.",
+		[]string{"bucket"}, nil,
+	)
+	allowedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "allowed_total"),
+		"Number of allowed requests or operations.",
+		[]string{"bucket"}, nil,
+	)
+	deniedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "denied_total"),
+		"Number of denied requests or operations.",
+		[]string{"bucket"}, nil,
+	)
+)
+
+// Collector
+//
+//	implement prometheus.Collector for registered buckets
+//
+//	Fields:
+//
+//	[buckets]   registered buckets by name
+//	[lock]      mutex for atomic operations
+type Collector struct {
+	buckets map[string]*token_bucket.TokenBucket
+	lock    sync.RWMutex
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns new Collector entity instance
+func NewCollector() *Collector {
+	return &Collector{
+		buckets: map[string]*token_bucket.TokenBucket{},
+	}
+}
+
+// Register adds the bucket reported with 'bucket' label set to the name
+func (c *Collector) Register(name string, tb *token_bucket.TokenBucket) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.buckets[name] = tb
+}
+
+// Unregister removes the bucket with the name
+func (c *Collector) Unregister(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.buckets, name)
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tokensDesc
+	ch <- maxTokensDesc
+	ch <- allowedDesc
+	ch <- deniedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for name, tb := range c.buckets {
+		ch <- prometheus.MustNewConstMetric(tokensDesc, prometheus.GaugeValue, float64(tb.Tokens()), name)
+		ch <- prometheus.MustNewConstMetric(maxTokensDesc, prometheus.GaugeValue, float64(tb.Capacity()), name)
+		ch <- prometheus.MustNewConstMetric(allowedDesc, prometheus.CounterValue, float64(tb.Allowed()), name)
+		ch <- prometheus.MustNewConstMetric(deniedDesc, prometheus.CounterValue, float64(tb.Denied()), name)
+	}
+}
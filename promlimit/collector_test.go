@@ -0,0 +1,47 @@
+package promlimit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+func TestCollector(t *testing.T) {
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	tb := token_bucket.NewTokenBucket(3, 1, token_bucket.SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(2)
+	tb.AllowN(2)
+
+	c := NewCollector()
+	c.Register("api", tb)
+
+	expected := `
+# HELP token_bucket_allowed_total Number of allowed requests or operations.
+# TYPE token_bucket_allowed_total counter
+token_bucket_allowed_total{bucket="api"} 1
+# HELP token_bucket_denied_total Number of denied requests or operations.
+# TYPE token_bucket_denied_total counter
+token_bucket_denied_total{bucket="api"} 1
+# HELP token_bucket_max_tokens Maximum number of tokens in the bucket.
+# TYPE token_bucket_max_tokens gauge
+token_bucket_max_tokens{bucket="api"} 3
+# HELP token_bucket_tokens Current number of tokens in the bucket.
+# TYPE token_bucket_tokens gauge
+token_bucket_tokens{bucket="api"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+	c.Unregister("api")
+
+	if n := testutil.CollectAndCount(c); n != 0 {
+		t.Fatalf("unregistered bucket is collected: got %d metrics", n)
+	}
+}
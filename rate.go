@@ -0,0 +1,70 @@
+package token_bucket
+
+import (
+	"fmt"
+	"time"
+)
+
+const minNormalizedDur = 10 * time.Millisecond // shortest refill duration chosen by normalization
+
+// SetRate set 'count' tokens refilled every 'per' duration.
+// with 'normalize' the rate is converted to the shortest refill duration not below 10ms
+// keeping the same average rate, e.g. 300 per minute becomes 1 per 200ms,
+// so tokens are refilled smoothly instead of in one large step per 'per'
+func SetRate(count int, per time.Duration, normalize bool) Option {
+	return func(tb *TokenBucket) {
+		tb.refillRate, tb.refillDur = rateOf(count, per, normalize)
+	}
+}
+
+// SetInterval set refilling one token every 'd', e.g. at most one operation per 250ms,
+// same as SetRate(1, d, false). burst is still the bucket 'maxTokens',
+// options applied after it override the rate
+func SetInterval(d time.Duration) Option {
+	return SetRate(1, d, false)
+}
+
+// SetRateNow set 'count' tokens refilled every 'per' duration at runtime, see SetRate.
+// tokens accumulated with the previous rate are credited first and the interrupted interval
+// is settled as in SetRefillDurationNow, so the old time is not credited at the new rate
+func (tb *TokenBucket) SetRateNow(count int, per time.Duration, normalize bool) error {
+	if count < 0 {
+		return fmt.Errorf("token_bucket: refill rate must not be negative, got %d", count)
+	}
+	if per <= 0 {
+		return fmt.Errorf("token_bucket: refill duration must be positive, got %s", per)
+	}
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	rate, dur := rateOf(count, per, normalize)
+
+	tb.refillRate = rate
+	tb.switchRefillDur(dur)
+
+	return nil
+}
+
+// rateOf returns refill rate and duration for 'count' tokens per 'per',
+// normalized to the shortest duration not below 'minNormalizedDur' if 'normalize' is set
+func rateOf(count int, per time.Duration, normalize bool) (int64, time.Duration) {
+	if !normalize || count <= 1 || per <= 0 {
+		return int64(count), per
+	}
+	best := 1
+
+	// the divisor must divide both the count and the duration exactly to keep the rate
+	for d := 1; d*d <= count; d++ {
+		if count%d != 0 {
+			continue
+		}
+		for _, div := range []int{d, count / d} {
+			if div > best && per%time.Duration(div) == 0 && per/time.Duration(div) >= minNormalizedDur {
+				best = div
+			}
+		}
+	}
+	return int64(count / best), per / time.Duration(best)
+}
@@ -0,0 +1,81 @@
+package token_bucket
+
+import (
+	"context"
+	"time"
+)
+
+// RateAdapter
+//
+//	mirrors golang.org/x/time/rate.Limiter methods on top of TokenBucket,
+//	so code using rate.Limiter can switch with minimal changes.
+//	differences from rate.Limiter:
+//	tokens are refilled per refill duration instead of continuously
+//	unless SetContinuousRefill is set, limit is reported as tokens per second
+//	and Reservation.Cancel does not take the time argument
+//
+//	Fields:
+//
+//	[tb]   adapted bucket
+type RateAdapter struct {
+	tb *TokenBucket
+}
+
+// NewRateAdapter returns new RateAdapter entity instance
+func NewRateAdapter(tb *TokenBucket) *RateAdapter {
+	return &RateAdapter{
+		tb: tb,
+	}
+}
+
+// Limit returns refill rate in tokens per second
+func (ra *RateAdapter) Limit() float64 {
+	cfg := ra.tb.Config()
+
+	return float64(cfg.RefillRate) / cfg.RefillDur.Seconds()
+}
+
+// Burst returns maximum number of tokens in the bucket
+func (ra *RateAdapter) Burst() int {
+	return ra.tb.Capacity()
+}
+
+// Tokens returns number of tokens available now
+func (ra *RateAdapter) Tokens() float64 {
+	return float64(ra.tb.Tokens())
+}
+
+// Allow reports whether one token may be consumed now
+func (ra *RateAdapter) Allow() bool {
+	return ra.tb.AllowN(1)
+}
+
+// AllowN reports whether 'n' tokens may be consumed at time 't'
+func (ra *RateAdapter) AllowN(t time.Time, n int) bool {
+	return ra.tb.AllowNAt(t, n)
+}
+
+// Reserve returns Reservation for one token
+func (ra *RateAdapter) Reserve() *Reservation {
+	return ra.tb.ReserveN(1)
+}
+
+// ReserveN returns Reservation for 'n' tokens at time 't'
+func (ra *RateAdapter) ReserveN(t time.Time, n int) *Reservation {
+	ra.tb.lock.Lock()
+	defer ra.tb.unlock()
+
+	r, _ := ra.tb.reserveAtLocked(n, t)
+
+	return r
+}
+
+// Wait blocks until one token is consumed
+func (ra *RateAdapter) Wait(ctx context.Context) error {
+	return ra.tb.WaitN(ctx, 1)
+}
+
+// WaitN blocks until 'n' tokens are consumed
+func (ra *RateAdapter) WaitN(ctx context.Context, n int) error {
+	return ra.tb.WaitN(ctx, n)
+}
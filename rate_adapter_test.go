@@ -0,0 +1,31 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateAdapter(t *testing.T) {
+	startT := time.Unix(0, 0)
+
+	ra := NewRateAdapter(NewTokenBucket(4, 10, SetRefillDuration(2*time.Second), SetClock(NewTestClock(startT))))
+	defer ra.tb.Close()
+
+	if ra.Limit() != 5 || ra.Burst() != 4 {
+		t.Fatalf("got limit %v and burst %d, want 5 per second and 4", ra.Limit(), ra.Burst())
+	}
+	if !ra.AllowN(startT, 3) || !ra.Allow() || ra.Allow() {
+		t.Fatal("burst of 4 tokens")
+	}
+	if ra.Tokens() != 0 {
+		t.Fatalf("got %v tokens, want 0", ra.Tokens())
+	}
+	r := ra.ReserveN(startT.Add(time.Second), 2)
+
+	if !r.OK() || r.DelayFrom(startT.Add(time.Second)) != time.Second {
+		t.Fatalf("reservation: ok %v, delay %s, want delay 1s", r.OK(), r.DelayFrom(startT.Add(time.Second)))
+	}
+	if !ra.AllowN(startT.Add(2*time.Second), 2) {
+		t.Fatal("tokens over the reservation must be allowed after the refill")
+	}
+}
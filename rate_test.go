@@ -0,0 +1,122 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateOf(t *testing.T) {
+	tests := []struct {
+		count     int
+		per       time.Duration
+		normalize bool
+		wantRate  int64
+		wantDur   time.Duration
+	}{
+		{300, time.Minute, false, 300, time.Minute},
+		{300, time.Minute, true, 1, 200 * time.Millisecond},
+		{1000, time.Second, true, 10, 10 * time.Millisecond},
+		{7, time.Second, true, 7, time.Second},
+		{3, 10 * time.Millisecond, true, 3, 10 * time.Millisecond},
+		{1, time.Second, true, 1, time.Second},
+	}
+	for _, tt := range tests {
+		rate, dur := rateOf(tt.count, tt.per, tt.normalize)
+
+		if rate != tt.wantRate || dur != tt.wantDur {
+			t.Fatalf("rateOf(%d, %s, %t): got %d per %s, want %d per %s",
+				tt.count, tt.per, tt.normalize, rate, dur, tt.wantRate, tt.wantDur)
+		}
+	}
+}
+
+func TestSetRate(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(300, 1, SetClock(clock), SetRate(300, time.Minute, true))
+	defer tb.Close()
+
+	tb.AllowN(300)
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("got %d tokens, want 5 refilled smoothly", got)
+	}
+}
+
+func TestSetRateNow(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(10)
+	clock.Advance(2 * time.Second)
+
+	if err := tb.SetRateNow(4, time.Second, false); err != nil {
+		t.Fatal(err)
+	}
+	// the 2 tokens of the previous rate are credited before the change
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 6 {
+		t.Fatalf("got %d tokens, want 6", got)
+	}
+	if err := tb.SetRateNow(-1, time.Second, false); err == nil {
+		t.Fatal("negative rate accepted")
+	}
+	if err := tb.SetRateNow(1, 0, false); err == nil {
+		t.Fatal("zero duration accepted")
+	}
+}
+
+func TestSetRateNowSettlesInterval(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(100, 1, SetClock(clock), SetRefillDuration(time.Hour))
+	defer tb.Close()
+
+	tb.AllowN(100)
+	clock.Advance(59 * time.Minute)
+
+	if err := tb.SetRateNow(1, time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	// 59 minutes of the hour are 59/60 of the first minute, not 59 minutes at the new rate
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("got %d tokens, want 0 as with SetRefillDurationNow", got)
+	}
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1 after the rest of the interrupted interval", got)
+	}
+	clock.Advance(time.Minute)
+
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want 2", got)
+	}
+}
+
+func TestSetInterval(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(3, 5, SetClock(clock), SetInterval(250*time.Millisecond))
+	defer tb.Close()
+
+	tb.AllowN(3)
+	clock.Advance(500 * time.Millisecond)
+
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("got %d tokens, want one per 250ms", got)
+	}
+	// the burst is still the bucket capacity
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("got %d tokens, want 3", got)
+	}
+}
@@ -0,0 +1,136 @@
+package token_bucket
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Decision
+//
+//	recorded consumption decision of the bucket
+//
+//	Fields:
+//
+//	[T]         time of the decision
+//	[N]         requested tokens number
+//	[Allowed]   the tokens are consumed
+type Decision struct {
+	T       time.Time `json:"t"`
+	N       int       `json:"n"`
+	Allowed bool      `json:"allowed"`
+}
+
+// Recorder
+//
+//	limiter logging every decision of the bucket, so throttling can be reproduced offline by Replay.
+//	the log is JSON lines: the bucket snapshot first and then decisions in order they are made
+//
+//	Fields:
+//
+//	[tb]     recorded bucket
+//	[w]      buffered log writer
+//	[enc]    encoder of the log lines
+//	[err]    first error of writing the log
+//	[lock]   mutex keeping the log in order of decisions
+type Recorder struct {
+	tb   *TokenBucket
+	w    *bufio.Writer
+	enc  *json.Encoder
+	err  error
+	lock sync.Mutex
+}
+
+var _ Limiter = (*Recorder)(nil)
+
+// NewRecorder returns new Recorder entity instance logging decisions of the bucket to 'w'.
+// the log is buffered, call Flush to write it out
+func NewRecorder(tb *TokenBucket, w io.Writer) *Recorder {
+	bw := bufio.NewWriter(w)
+
+	rec := &Recorder{
+		tb:  tb,
+		w:   bw,
+		enc: json.NewEncoder(bw),
+	}
+	rec.err = rec.enc.Encode(tb.Snapshot())
+
+	return rec
+}
+
+// AllowN return 'true' if there are 'n' tokens in the bucket and logs the decision
+func (rec *Recorder) AllowN(n int) bool {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	nowT := rec.tb.now()
+	allowed := rec.tb.AllowNAt(nowT, n)
+
+	if rec.err == nil {
+		rec.err = rec.enc.Encode(Decision{
+			T:       nowT,
+			N:       n,
+			Allowed: allowed,
+		})
+	}
+	return allowed
+}
+
+// Allow returns 'true' if there are enough tokens in the bucket and logs the decision
+func (rec *Recorder) Allow() bool {
+	return rec.AllowN(rec.tb.Weight())
+}
+
+// Flush writes out the buffered log, returns the first error of writing the log
+func (rec *Recorder) Flush() error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	if rec.err != nil {
+		return rec.err
+	}
+	return rec.w.Flush()
+}
+
+// Replay reads the log written by Recorder and replays the decisions with AllowNAt through fresh bucket
+// restored from the logged snapshot with the options, returns recorded and replayed decisions.
+// the decisions are identical if the options configure the bucket as the recorded one,
+// except refill jitter, which must use the same seeded source, see SetRandSource
+func Replay(r io.Reader, options ...Option) (recorded, replayed []Decision, err error) {
+	dec := json.NewDecoder(r)
+
+	var s Snapshot
+
+	if err := dec.Decode(&s); err != nil {
+		return nil, nil, fmt.Errorf("token_bucket: decode recorded snapshot: %w", err)
+	}
+	for {
+		var d Decision
+
+		if err := dec.Decode(&d); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("token_bucket: decode recorded decision: %w", err)
+		}
+		recorded = append(recorded, d)
+	}
+	// the clock stays at the snapshot time, so restoring credits no tokens
+	options = append(options, SetClock(NewTestClock(s.LastFillT)))
+
+	tb := NewTokenBucketFromSnapshot(s, options...)
+	defer tb.Close()
+
+	replayed = make([]Decision, len(recorded))
+
+	for i, d := range recorded {
+		replayed[i] = Decision{
+			T:       d.T,
+			N:       d.N,
+			Allowed: tb.AllowNAt(d.T, d.N),
+		}
+	}
+	return recorded, replayed, nil
+}
@@ -0,0 +1,125 @@
+package token_bucket
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorderReplay(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(3, 1, SetClock(clock))
+	defer tb.Close()
+
+	var buf bytes.Buffer
+	rec := NewRecorder(tb, &buf)
+
+	want := []bool{true, true, false, true, false}
+
+	for i, n := range []int{2, 1, 1, 1, 2} {
+		if got := rec.AllowN(n); got != want[i] {
+			t.Fatalf("decision %d: got allowed %t, want %t", i, got, want[i])
+		}
+		clock.Advance(400 * time.Millisecond)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("log written out before flush")
+	}
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	recorded, replayed, err := Replay(&buf)
+
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(recorded) != len(want) {
+		t.Fatalf("got %d recorded decisions, want %d", len(recorded), len(want))
+	}
+	for i, d := range recorded {
+		if d.Allowed != want[i] {
+			t.Fatalf("recorded decision %d: got allowed %t, want %t", i, d.Allowed, want[i])
+		}
+	}
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Fatalf("got replayed %v, want recorded %v", replayed, recorded)
+	}
+}
+
+func TestReplayDifferentOptions(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock))
+	defer tb.Close()
+
+	var buf bytes.Buffer
+	rec := NewRecorder(tb, &buf)
+
+	for i := 0; i < 2; i++ {
+		rec.Allow()
+	}
+	clock.Advance(time.Second)
+
+	if !rec.Allow() {
+		t.Fatal("refilled request denied")
+	}
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	// longer refill duration denies the last decision on replay
+	recorded, replayed, err := Replay(&buf, SetRefillDuration(2*time.Second))
+
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(replayed) != 3 || !replayed[1].Allowed || replayed[2].Allowed {
+		t.Fatalf("got replayed %v, want the refilled decision denied", replayed)
+	}
+	if !recorded[2].Allowed {
+		t.Fatal("recorded refilled decision is not allowed")
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestRecorderFlushError(t *testing.T) {
+	tb := NewTokenBucket(2, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	rec := NewRecorder(tb, failWriter{})
+
+	if !rec.Allow() {
+		t.Fatal("request within tokens denied")
+	}
+	if err := rec.Flush(); err == nil {
+		t.Fatal("got nil flush error, want the write error")
+	}
+}
+
+func TestReplayMalformed(t *testing.T) {
+	if _, _, err := Replay(strings.NewReader("")); err == nil {
+		t.Fatal("got nil error for empty log")
+	}
+	tb := NewTokenBucket(2, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	var buf bytes.Buffer
+	rec := NewRecorder(tb, &buf)
+
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	buf.WriteString("{broken\n")
+
+	if _, _, err := Replay(&buf); err == nil {
+		t.Fatal("got nil error for malformed decision")
+	}
+}
@@ -0,0 +1,204 @@
+package redislimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+const (
+	refillDuration = time.Second // default refill duration
+	defaultTokensN = 1           // default weight for one requests or operation
+)
+
+var (
+	// ErrShortRefillDuration returned when the refill duration is under one microsecond, the resolution of the bucket state
+	ErrShortRefillDuration = errors.New("redislimit: refill duration is less than one microsecond")
+
+	// ErrNonPositiveTokens returned when the requested tokens number is not positive
+	ErrNonPositiveTokens = errors.New("redislimit: requested tokens number is not positive")
+)
+
+// refillScript refill the bucket for every elapsed refill interval and consumes tokens.
+// the bucket state is a hash with current tokens number and the last filling time
+// in microseconds of redis server clock, so all instances share the same time
+//
+//	KEYS[1] bucket key
+//	ARGV[1] maximum number of tokens
+//	ARGV[2] number of tokens added per refill duration
+//	ARGV[3] refill duration in microseconds
+//	ARGV[4] number of tokens to consume
+//
+//	returns 1 if the tokens are consumed, otherwise 0
+var refillScript = redis.NewScript(`
+redis.replicate_commands()
+
+local max = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local dur = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+
+if tokens == nil or last == nil then
+	tokens = max
+	last = now
+end
+
+local intervals = math.floor((now - last) / dur)
+if intervals > 0 then
+	tokens = math.min(tokens + intervals * rate, max)
+	last = last + intervals * dur
+end
+
+local allowed = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'last', last)
+
+if rate > 0 then
+	local full = math.ceil((max - tokens) / rate) + 1
+	redis.call('PEXPIRE', KEYS[1], math.ceil(full * dur / 1000))
+end
+
+return allowed
+`)
+
+// TokenBucket
+//
+//	implement the token bucket algorithm with state stored in redis,
+//	so the limit is shared by all instances using the same key
+//
+//	Fields:
+//
+//	[client]       redis client
+//	[key]          redis key of the bucket state
+//	[maxTokens]    maximum number of tokens in bucket
+//	[refillRate]   number of tokens to be added in bucket per refill duration
+//
+//	For Options:
+//
+//	[tokenN] weight for one request or operation. default: 1
+//	[refillDur] bucket refill duration. default: 1 second
+//	[timeout] timeout of one redis round trip for Allow and AllowN. default: none
+type TokenBucket struct {
+	client     redis.Cmdable
+	key        string
+	maxTokens  int
+	refillRate int
+
+	tokenN    int
+	refillDur time.Duration
+	timeout   time.Duration
+}
+
+var _ token_bucket.Limiter = (*TokenBucket)(nil)
+
+// NewRedisTokenBucket returns new TokenBucket entity instance
+func NewRedisTokenBucket(client redis.Cmdable, key string, maxTokens, refillRate int, options ...Option) *TokenBucket {
+	tb := &TokenBucket{
+		client:     client,
+		key:        key,
+		maxTokens:  maxTokens,
+		refillRate: refillRate,
+
+		tokenN:    defaultTokensN,
+		refillDur: refillDuration,
+	}
+
+	for _, opt := range options {
+		opt(tb)
+	}
+
+	return tb
+}
+
+// Option for TokenBucket entity
+type Option func(*TokenBucket)
+
+// SetRefillDuration set refill duration
+func SetRefillDuration(dur time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.refillDur = dur
+	}
+}
+
+// SetTokenN set weight for one request or operation
+func SetTokenN(n int) Option {
+	return func(tb *TokenBucket) {
+		tb.tokenN = n
+	}
+}
+
+// SetTimeout set timeout of one redis round trip for Allow and AllowN
+func SetTimeout(timeout time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.timeout = timeout
+	}
+}
+
+// validate returns error if the script can not run with the refill duration or 'n' tokens,
+// the script divides by the duration in microseconds and adds negative 'n' to the bucket
+func (tb *TokenBucket) validate(n int) error {
+	if tb.refillDur < time.Microsecond {
+		return ErrShortRefillDuration
+	}
+	if n < 1 {
+		return ErrNonPositiveTokens
+	}
+	return nil
+}
+
+// AllowNCtx return 'true' if there are 'n' tokens in the bucket.
+// one round trip to redis is made per call.
+// returns ErrShortRefillDuration or ErrNonPositiveTokens without the round trip if the request is invalid
+func (tb *TokenBucket) AllowNCtx(ctx context.Context, n int) (bool, error) {
+	if err := tb.validate(n); err != nil {
+		return false, err
+	}
+	allowed, err := refillScript.Run(ctx, tb.client, []string{tb.key},
+		tb.maxTokens,
+		tb.refillRate,
+		tb.refillDur.Microseconds(),
+		n,
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	return allowed == 1, nil
+}
+
+// AllowN return 'true' if there are 'n' tokens in the bucket.
+// returns 'false' if redis is not available
+func (tb *TokenBucket) AllowN(n int) bool {
+	ctx := context.Background()
+
+	if tb.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, tb.timeout)
+		defer cancel()
+	}
+	allowed, err := tb.AllowNCtx(ctx, n)
+	if err != nil {
+		return false
+	}
+	return allowed
+}
+
+// Allow returns 'true' if there are enough tokens in the bucket
+func (tb *TokenBucket) Allow() bool {
+	return tb.AllowN(tb.tokenN)
+}
@@ -0,0 +1,89 @@
+package redislimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Unix(1_700_000_000, 0))
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return mr, client
+}
+
+func TestRedisTokenBucketAllowN(t *testing.T) {
+	mr, client := newTestClient(t)
+	ctx := context.Background()
+
+	tb := NewRedisTokenBucket(client, "bucket", 2, 1)
+
+	for i, want := range []bool{true, true, false} {
+		allowed, err := tb.AllowNCtx(ctx, 1)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if allowed != want {
+			t.Fatalf("request %d: got %v, want %v", i, allowed, want)
+		}
+	}
+	mr.SetTime(time.Unix(1_700_000_001, 0))
+
+	if allowed, err := tb.AllowNCtx(ctx, 1); err != nil || !allowed {
+		t.Fatalf("bucket must be refilled: got %v, %v", allowed, err)
+	}
+}
+
+func TestRedisTokenBucketInvalid(t *testing.T) {
+	mr, client := newTestClient(t)
+	ctx := context.Background()
+
+	tb := NewRedisTokenBucket(client, "bucket", 2, 1)
+
+	for _, n := range []int{0, -5} {
+		if _, err := tb.AllowNCtx(ctx, n); !errors.Is(err, ErrNonPositiveTokens) {
+			t.Fatalf("n %d: got error %v, want ErrNonPositiveTokens", n, err)
+		}
+	}
+	short := NewRedisTokenBucket(client, "short", 2, 1, SetRefillDuration(time.Nanosecond))
+
+	if _, err := short.AllowNCtx(ctx, 1); !errors.Is(err, ErrShortRefillDuration) {
+		t.Fatalf("got error %v, want ErrShortRefillDuration", err)
+	}
+	if mr.Exists("bucket") || mr.Exists("short") {
+		t.Fatal("invalid requests must not reach redis")
+	}
+}
+
+func TestLeaseLimiter(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	ll := NewLeaseLimiter(NewRedisTokenBucket(client, "bucket", 3, 1, SetRefillDuration(time.Hour)), 2, 0)
+
+	for i, want := range []bool{true, true, true, false} {
+		allowed, err := ll.AllowNCtx(ctx, 1)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if allowed != want {
+			t.Fatalf("request %d: got %v, want %v", i, allowed, want)
+		}
+	}
+	if _, err := ll.AllowNCtx(ctx, -1); !errors.Is(err, ErrNonPositiveTokens) {
+		t.Fatalf("got error %v, want ErrNonPositiveTokens", err)
+	}
+	if ll.Leased() != 0 {
+		t.Fatalf("negative request must not add tokens to the lease: got %d", ll.Leased())
+	}
+}
@@ -0,0 +1,208 @@
+package redislimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+// leaseScript refill the bucket like refillScript and takes up to the requested number of tokens.
+//
+//	KEYS[1] bucket key
+//	ARGV[1] maximum number of tokens
+//	ARGV[2] number of tokens added per refill duration
+//	ARGV[3] refill duration in microseconds
+//	ARGV[4] maximum number of tokens to take
+//
+//	returns number of taken tokens
+var leaseScript = redis.NewScript(`
+redis.replicate_commands()
+
+local max = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local dur = tonumber(ARGV[3])
+local want = tonumber(ARGV[4])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+
+if tokens == nil or last == nil then
+	tokens = max
+	last = now
+end
+
+local intervals = math.floor((now - last) / dur)
+if intervals > 0 then
+	tokens = math.min(tokens + intervals * rate, max)
+	last = last + intervals * dur
+end
+
+local taken = math.max(math.min(tokens, want), 0)
+tokens = tokens - taken
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'last', last)
+
+if rate > 0 then
+	local full = math.ceil((max - tokens) / rate) + 1
+	redis.call('PEXPIRE', KEYS[1], math.ceil(full * dur / 1000))
+end
+
+return taken
+`)
+
+// LeaseLimiter
+//
+//	serves Allow calls from local lease of tokens taken from the redis bucket in batches,
+//	so redis is called only when the lease is exhausted or expired.
+//
+//	tokens are never created locally, so all instances together consume no more than the redis bucket allows,
+//	but the consumption is less accurate: tokens taken earlier may be consumed later within the lease TTL
+//	and up to 'size' tokens per instance may be wasted by expired leases, denying requests of other instances
+//	while the tokens are held. smaller size and TTL mean better accuracy and more redis round trips
+//
+//	Fields:
+//
+//	[tb]        redis bucket the tokens are leased from
+//	[size]      number of tokens taken from redis per round trip
+//	[ttl]       duration after which unused leased tokens are dropped
+//	[leased]    number of unused leased tokens
+//	[expireT]   time the lease expires
+//	[clock]     clock of the lease TTL. default: wall clock
+//	[lock]      mutex for atomic operations
+type LeaseLimiter struct {
+	tb      *TokenBucket
+	size    int
+	ttl     time.Duration
+	leased  int
+	expireT time.Time
+	clock   token_bucket.Clock
+	lock    sync.Mutex
+}
+
+// LeaseOption for NewLeaseLimiter
+type LeaseOption func(*LeaseLimiter)
+
+// SetLeaseClock set clock measuring the lease TTL, e.g. token_bucket.TestClock in tests.
+// the redis bucket is still refilled by the redis server clock
+func SetLeaseClock(clock token_bucket.Clock) LeaseOption {
+	return func(ll *LeaseLimiter) {
+		ll.clock = clock
+	}
+}
+
+// wallClock returns current wall clock time
+type wallClock struct{}
+
+// Now returns current time
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+var _ token_bucket.Limiter = (*LeaseLimiter)(nil)
+
+// NewLeaseLimiter returns new LeaseLimiter entity instance leasing 'size' tokens for 'ttl' from the bucket.
+// non-positive size means one token per round trip, non-positive TTL means the lease never expires
+func NewLeaseLimiter(tb *TokenBucket, size int, ttl time.Duration, options ...LeaseOption) *LeaseLimiter {
+	if size <= 0 {
+		size = 1
+	}
+	ll := &LeaseLimiter{
+		tb:    tb,
+		size:  size,
+		ttl:   ttl,
+		clock: wallClock{},
+	}
+	for _, opt := range options {
+		opt(ll)
+	}
+	return ll
+}
+
+// AllowNCtx return 'true' if there are 'n' tokens in the local lease.
+// if there are not, round trip to redis is made to take at least 'size' tokens more.
+// returns ErrShortRefillDuration or ErrNonPositiveTokens if the request is invalid
+func (ll *LeaseLimiter) AllowNCtx(ctx context.Context, n int) (bool, error) {
+	if err := ll.tb.validate(n); err != nil {
+		return false, err
+	}
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	nowT := ll.clock.Now()
+
+	if ll.ttl > 0 && !nowT.Before(ll.expireT) {
+		ll.leased = 0
+	}
+	if ll.leased >= n {
+		ll.leased -= n
+		return true, nil
+	}
+	want := ll.size
+
+	if n-ll.leased > want {
+		want = n - ll.leased
+	}
+	taken, err := leaseScript.Run(ctx, ll.tb.client, []string{ll.tb.key},
+		ll.tb.maxTokens,
+		ll.tb.refillRate,
+		ll.tb.refillDur.Microseconds(),
+		want,
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	if taken > 0 {
+		if ll.leased == 0 {
+			ll.expireT = nowT.Add(ll.ttl)
+		}
+		ll.leased += taken
+	}
+	if ll.leased < n {
+		return false, nil
+	}
+	ll.leased -= n
+
+	return true, nil
+}
+
+// AllowN return 'true' if there are 'n' tokens in the local lease or in the redis bucket.
+// returns 'false' if the lease is exhausted and redis is not available
+func (ll *LeaseLimiter) AllowN(n int) bool {
+	ctx := context.Background()
+
+	if ll.tb.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, ll.tb.timeout)
+		defer cancel()
+	}
+	allowed, err := ll.AllowNCtx(ctx, n)
+	if err != nil {
+		return false
+	}
+	return allowed
+}
+
+// Allow returns 'true' if there are enough tokens in the local lease or in the redis bucket
+func (ll *LeaseLimiter) Allow() bool {
+	return ll.AllowN(ll.tb.tokenN)
+}
+
+// Leased returns number of unused tokens in the local lease
+func (ll *LeaseLimiter) Leased() int {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	if ll.ttl > 0 && !ll.clock.Now().Before(ll.expireT) {
+		return 0
+	}
+	return ll.leased
+}
@@ -0,0 +1,73 @@
+package redislimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	token_bucket "github.com/UshakovN/token-bucket"
+)
+
+func TestLeaseLimiterBatches(t *testing.T) {
+	mr, client := newTestClient(t)
+	clock := token_bucket.NewTestClock(time.Unix(0, 0))
+
+	tb := NewRedisTokenBucket(client, "bucket", 10, 1)
+	ll := NewLeaseLimiter(tb, 4, time.Minute, SetLeaseClock(clock))
+
+	if !ll.Allow() || ll.Leased() != 3 {
+		t.Fatalf("first request must lease 4 tokens: got %d leased", ll.Leased())
+	}
+	calls := mr.CommandCount()
+
+	for i := 0; i < 3; i++ {
+		if !ll.Allow() {
+			t.Fatalf("leased request %d denied", i)
+		}
+	}
+	if got := mr.CommandCount(); got != calls {
+		t.Fatalf("leased requests must not call redis: got %d commands, want %d", got, calls)
+	}
+	// the other instance gets only the tokens left in redis
+	other := NewLeaseLimiter(tb, 10, time.Minute, SetLeaseClock(clock))
+
+	if !other.AllowN(5) || other.Leased() != 1 {
+		t.Fatalf("got %d leased by the other instance, want 1 of the 6 left", other.Leased())
+	}
+	if other.AllowN(2) {
+		t.Fatal("request over the lease and the empty redis bucket allowed")
+	}
+	clock.Advance(time.Minute)
+
+	if got := other.Leased(); got != 0 {
+		t.Fatalf("expired lease: got %d leased, want 0", got)
+	}
+	mr.SetTime(time.Unix(1_700_000_003, 0))
+
+	// 3 tokens refilled in redis cover the request over the lease size
+	if !ll.AllowN(3) || ll.Leased() != 0 {
+		t.Fatalf("got %d leased, want 0 after taking the refilled tokens", ll.Leased())
+	}
+}
+
+func TestLeaseLimiterRedisDown(t *testing.T) {
+	mr, client := newTestClient(t)
+
+	ll := NewLeaseLimiter(NewRedisTokenBucket(client, "bucket", 10, 1), 2, 0)
+
+	if !ll.Allow() {
+		t.Fatal("first request denied")
+	}
+	mr.Close()
+
+	// the lease never expires with zero TTL, so it is served without redis
+	if !ll.Allow() {
+		t.Fatal("leased request must be allowed while redis is down")
+	}
+	if _, err := ll.AllowNCtx(context.Background(), 1); err == nil {
+		t.Fatal("exhausted lease must fail while redis is down")
+	}
+	if ll.Allow() {
+		t.Fatal("request must be denied while redis is down")
+	}
+}
@@ -0,0 +1,306 @@
+package token_bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Registry
+//
+//	named buckets configured declaratively, e.g. by a config file, safe for concurrent use
+//
+//	Fields:
+//
+//	[buckets]   buckets by name
+//	[options]   options applied to every bucket created by config
+//	[lock]      mutex for atomic operations
+type Registry struct {
+	buckets map[string]*TokenBucket
+	options []Option
+	lock    sync.RWMutex
+}
+
+// BucketConfig
+//
+//	declared parameters of the named bucket
+//
+//	Fields:
+//
+//	[Name]         unique name of the bucket
+//	[MaxTokens]    maximum number of tokens in bucket
+//	[RefillRate]   number of tokens to be added in bucket per refill duration
+//	[RefillDur]    bucket refill duration like "1s" or "500ms", default: 1 second
+//	[TokenN]       weight for one request or operation, default: 1
+type BucketConfig struct {
+	Name       string `json:"name" yaml:"name"`
+	MaxTokens  int    `json:"max_tokens" yaml:"max_tokens"`
+	RefillRate int    `json:"refill_rate" yaml:"refill_rate"`
+	RefillDur  string `json:"refill_dur,omitempty" yaml:"refill_dur,omitempty"`
+	TokenN     int    `json:"token_n,omitempty" yaml:"token_n,omitempty"`
+}
+
+// RegistryConfig
+//
+//	declared buckets of Registry, e.g. {"buckets": [{"name": "api", "max_tokens": 10, "refill_rate": 5}]}
+//
+//	Fields:
+//
+//	[Buckets]   declared buckets
+type RegistryConfig struct {
+	Buckets []BucketConfig `json:"buckets" yaml:"buckets"`
+}
+
+// registryConfig
+//
+//	options of NewRegistryFromConfig
+//
+//	For Options:
+//
+//	[decode] decoder of the config document. default: json.Unmarshal
+//	[options] options applied to every bucket. default: none
+type registryConfig struct {
+	decode  func(data []byte, v any) error
+	options []Option
+}
+
+// RegistryOption for NewRegistryFromConfig
+type RegistryOption func(*registryConfig)
+
+// SetConfigDecoder set decoder of the config document, e.g. yaml.Unmarshal for YAML configs
+func SetConfigDecoder(decode func(data []byte, v any) error) RegistryOption {
+	return func(c *registryConfig) {
+		c.decode = decode
+	}
+}
+
+// SetRegistryBucketOptions set options applied to every bucket of the registry
+func SetRegistryBucketOptions(options ...Option) RegistryOption {
+	return func(c *registryConfig) {
+		c.options = append(c.options, options...)
+	}
+}
+
+// NewRegistryFromConfig returns new Registry entity instance with buckets declared by the config document.
+// returns error if the document is malformed, a name is empty or duplicated, or parameters are invalid
+func NewRegistryFromConfig(r io.Reader, options ...RegistryOption) (*Registry, error) {
+	c := &registryConfig{
+		decode: json.Unmarshal,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("token_bucket: read registry config: %w", err)
+	}
+	var config RegistryConfig
+
+	if err := c.decode(data, &config); err != nil {
+		return nil, fmt.Errorf("token_bucket: decode registry config: %w", err)
+	}
+	return NewRegistry(config, c.options...)
+}
+
+// NewRegistry returns new Registry entity instance with the declared buckets
+func NewRegistry(config RegistryConfig, options ...Option) (*Registry, error) {
+	buckets, err := config.newBuckets(options)
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		buckets: buckets,
+		options: options,
+	}, nil
+}
+
+// newBuckets returns new token buckets of the declared buckets by name.
+// returns error if a name is empty or duplicated, or parameters are invalid
+func (config RegistryConfig) newBuckets(options []Option) (map[string]*TokenBucket, error) {
+	if _, err := config.configs(options); err != nil {
+		return nil, err
+	}
+	buckets := make(map[string]*TokenBucket, len(config.Buckets))
+
+	for _, bc := range config.Buckets {
+		tb, err := bc.newBucket(options)
+		if err != nil {
+			closeBuckets(buckets)
+			return nil, fmt.Errorf("token_bucket: invalid registry bucket %q: %w", bc.Name, err)
+		}
+		buckets[bc.Name] = tb
+	}
+
+	return buckets, nil
+}
+
+// configs returns effective configurations of the declared buckets by name without creating them.
+// returns error if a name is empty or duplicated, or parameters are invalid
+func (config RegistryConfig) configs(options []Option) (map[string]Config, error) {
+	configs := make(map[string]Config, len(config.Buckets))
+
+	for _, bc := range config.Buckets {
+		if bc.Name == "" {
+			return nil, fmt.Errorf("token_bucket: registry bucket name must not be empty")
+		}
+		if _, ok := configs[bc.Name]; ok {
+			return nil, fmt.Errorf("token_bucket: duplicate registry bucket %q", bc.Name)
+		}
+		c, err := bc.config(options)
+		if err != nil {
+			return nil, fmt.Errorf("token_bucket: invalid registry bucket %q: %w", bc.Name, err)
+		}
+		configs[bc.Name] = c
+	}
+
+	return configs, nil
+}
+
+// bucketOptions returns options of the declared parameters followed by 'options'
+func (bc BucketConfig) bucketOptions(options []Option) ([]Option, error) {
+	bucketOptions := []Option{SetName(bc.Name)}
+
+	if bc.RefillDur != "" {
+		dur, err := time.ParseDuration(bc.RefillDur)
+		if err != nil {
+			return nil, err
+		}
+		bucketOptions = append(bucketOptions, SetRefillDuration(dur))
+	}
+	if bc.TokenN != 0 {
+		bucketOptions = append(bucketOptions, SetTokenN(bc.TokenN))
+	}
+	return append(bucketOptions, options...), nil
+}
+
+// newBucket returns new token bucket with the declared parameters
+func (bc BucketConfig) newBucket(options []Option) (*TokenBucket, error) {
+	bucketOptions, err := bc.bucketOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return NewTokenBucketChecked(bc.MaxTokens, bc.RefillRate, bucketOptions...)
+}
+
+// config returns effective configuration of the bucket with the declared parameters.
+// the options are applied to a probe which is never started, so nothing is created
+func (bc BucketConfig) config(options []Option) (Config, error) {
+	bucketOptions, err := bc.bucketOptions(options)
+	if err != nil {
+		return Config{}, err
+	}
+	probe := &TokenBucket{
+		maxTokens:  int64(bc.MaxTokens),
+		refillRate: int64(bc.RefillRate),
+		refillDur:  refillDuration,
+	}
+	probe.tokenN.Store(defaultTokensN)
+	for _, opt := range bucketOptions {
+		opt(probe)
+	}
+	probe.refillDur = probe.clampInterval(probe.refillDur)
+
+	if err := probe.validate(); err != nil {
+		return Config{}, err
+	}
+	return Config{
+		MaxTokens:  int(probe.maxTokens),
+		RefillRate: int(probe.refillRate),
+		RefillDur:  probe.refillDur,
+		TokenN:     probe.Weight(),
+	}, nil
+}
+
+// closeBuckets closes the buckets
+func closeBuckets(buckets map[string]*TokenBucket) {
+	for _, tb := range buckets {
+		tb.Close()
+	}
+}
+
+// Get returns bucket with the name and 'true' if it is registered
+func (reg *Registry) Get(name string) (*TokenBucket, bool) {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+
+	tb, ok := reg.buckets[name]
+	return tb, ok
+}
+
+// Range calls 'fn' for every registered bucket until it returns 'false'.
+// iterates snapshot of the buckets, so 'fn' may call the registry
+func (reg *Registry) Range(fn func(name string, tb *TokenBucket) bool) {
+	reg.lock.RLock()
+	snapshot := make(map[string]*TokenBucket, len(reg.buckets))
+
+	for name, tb := range reg.buckets {
+		snapshot[name] = tb
+	}
+	reg.lock.RUnlock()
+
+	for name, tb := range snapshot {
+		if !fn(name, tb) {
+			return
+		}
+	}
+}
+
+// Register adds the bucket with the name, replacing the bucket registered with the same name
+func (reg *Registry) Register(name string, tb *TokenBucket) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	reg.buckets[name] = tb
+}
+
+// Reload applies the config to the registry: rates, capacity and weight of registered buckets are updated
+// in place keeping their current tokens, new buckets are added, and buckets missing in the config
+// are removed and closed if 'removeStale' is set. nothing is changed if the config is invalid
+func (reg *Registry) Reload(config RegistryConfig, removeStale bool) error {
+	configs, err := config.configs(reg.options)
+	if err != nil {
+		return err
+	}
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	added := make(map[string]*TokenBucket)
+
+	for _, bc := range config.Buckets {
+		if _, ok := reg.buckets[bc.Name]; ok {
+			continue
+		}
+		tb, err := bc.newBucket(reg.options)
+		if err != nil {
+			closeBuckets(added)
+			return fmt.Errorf("token_bucket: invalid registry bucket %q: %w", bc.Name, err)
+		}
+		added[bc.Name] = tb
+	}
+	for name, c := range configs {
+		if tb, ok := added[name]; ok {
+			reg.buckets[name] = tb
+			continue
+		}
+		tb := reg.buckets[name]
+
+		tb.SetMaxTokens(c.MaxTokens)
+		tb.SetRefillRate(c.RefillRate)
+		tb.SetWeight(c.TokenN)
+
+		if c.RefillDur != tb.RefillDuration() {
+			_ = tb.SetRefillDurationNow(c.RefillDur)
+		}
+	}
+	if removeStale {
+		for name, tb := range reg.buckets {
+			if _, ok := configs[name]; !ok {
+				delete(reg.buckets, name)
+				tb.Close()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package token_bucket
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func isClosed(tb *TokenBucket) bool {
+	select {
+	case <-tb.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestNewRegistryFromConfig(t *testing.T) {
+	reg, err := NewRegistryFromConfig(strings.NewReader(`{"buckets": [{"name": "api", "max_tokens": 10, "refill_rate": 5, "refill_dur": "500ms", "token_n": 2}]}`))
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	tb, ok := reg.Get("api")
+	if !ok {
+		t.Fatal("bucket 'api' must be registered")
+	}
+	want := Config{MaxTokens: 10, RefillRate: 5, RefillDur: 500 * time.Millisecond, TokenN: 2}
+
+	if got := tb.Config(); got != want {
+		t.Fatalf("got config %+v, want %+v", got, want)
+	}
+	for _, doc := range []string{
+		`{"buckets": [{"name": "", "max_tokens": 1, "refill_rate": 1}]}`,
+		`{"buckets": [{"name": "a", "max_tokens": 1, "refill_rate": 1}, {"name": "a", "max_tokens": 1, "refill_rate": 1}]}`,
+		`{"buckets": [{"name": "a", "max_tokens": 0, "refill_rate": 1}]}`,
+		`{"buckets": [{"name": "a", "max_tokens": 1, "refill_rate": 1, "refill_dur": "soon"}]}`,
+	} {
+		if _, err := NewRegistryFromConfig(strings.NewReader(doc)); err == nil {
+			t.Fatalf("config %s must be rejected", doc)
+		}
+	}
+}
+
+func TestRegistryReload(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	reg, err := NewRegistry(RegistryConfig{Buckets: []BucketConfig{
+		{Name: "api", MaxTokens: 10, RefillRate: 1},
+		{Name: "stale", MaxTokens: 1, RefillRate: 1},
+	}}, SetClock(clock))
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	api, _ := reg.Get("api")
+	stale, _ := reg.Get("stale")
+
+	api.AllowN(4)
+
+	err = reg.Reload(RegistryConfig{Buckets: []BucketConfig{
+		{Name: "api", MaxTokens: 20, RefillRate: 2, TokenN: 3},
+		{Name: "new", MaxTokens: 5, RefillRate: 1},
+	}}, true)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if tb, _ := reg.Get("api"); tb != api {
+		t.Fatal("registered bucket must be updated in place")
+	}
+	want := Config{MaxTokens: 20, RefillRate: 2, RefillDur: time.Second, TokenN: 3}
+
+	if got := api.Config(); got != want {
+		t.Fatalf("got config %+v, want %+v", got, want)
+	}
+	if got := api.Tokens(); got != 6 {
+		t.Fatalf("current tokens must be kept: got %d, want 6", got)
+	}
+	if _, ok := reg.Get("new"); !ok {
+		t.Fatal("new bucket must be added")
+	}
+	if _, ok := reg.Get("stale"); ok || !isClosed(stale) {
+		t.Fatal("stale bucket must be removed and closed")
+	}
+}
+
+func TestRegistryReloadConcurrent(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	reg, err := NewRegistry(RegistryConfig{Buckets: []BucketConfig{
+		{Name: "api", MaxTokens: 1000, RefillRate: 1000},
+	}}, SetClock(clock))
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	api, _ := reg.Get("api")
+	defer api.Close()
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	// the weight is read by the consuming calls while Reload changes it, run with -race
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				api.Allow()
+				api.AllowAt(clock.Now())
+				api.AllowRequest(nil)
+				_ = api.AllowErr()
+				api.AllowInfo()
+				api.Reserve().Cancel()
+
+				runtime.Gosched()
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		err := reg.Reload(RegistryConfig{Buckets: []BucketConfig{
+			{Name: "api", MaxTokens: 1000, RefillRate: 1000, TokenN: 1 + i%3},
+		}}, false)
+		if err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		clock.Advance(time.Second)
+
+		// interleaves the reloads with the readers even on a single CPU
+		runtime.Gosched()
+	}
+	close(done)
+	wg.Wait()
+
+	if got := api.Weight(); got != 2 {
+		t.Fatalf("got weight %d, want 2 of the last reload", got)
+	}
+}
+
+func TestRegistryReloadInvalid(t *testing.T) {
+	reg, err := NewRegistry(RegistryConfig{Buckets: []BucketConfig{
+		{Name: "api", MaxTokens: 10, RefillRate: 1},
+	}})
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	err = reg.Reload(RegistryConfig{Buckets: []BucketConfig{
+		{Name: "api", MaxTokens: 20, RefillRate: 2},
+		{Name: "bad", MaxTokens: -1, RefillRate: 1},
+	}}, true)
+	if err == nil {
+		t.Fatal("invalid config must be rejected")
+	}
+	if tb, _ := reg.Get("api"); tb.Capacity() != 10 {
+		t.Fatal("nothing must be changed by invalid config")
+	}
+	if _, ok := reg.Get("bad"); ok {
+		t.Fatal("invalid bucket must not be added")
+	}
+}
+
+func TestRegistryRange(t *testing.T) {
+	reg, err := NewRegistryFromConfig(strings.NewReader(`{"buckets": [{"name": "a", "max_tokens": 1, "refill_rate": 1}, {"name": "b", "max_tokens": 1, "refill_rate": 1}]}`))
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	visited := map[string]bool{}
+
+	// the callback may change the registry, the snapshot is iterated
+	reg.Range(func(name string, tb *TokenBucket) bool {
+		visited[name] = true
+		reg.Register(name+"-copy", tb.Clone())
+		return true
+	})
+	if len(visited) != 2 || !visited["a"] || !visited["b"] {
+		t.Fatalf("got visited %v, want a and b", visited)
+	}
+	n := 0
+
+	reg.Range(func(string, *TokenBucket) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("got %d visited buckets, want iteration stopped at 1", n)
+	}
+}
+
+func TestNewRegistryFromConfigOptions(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	// decoder of "name max_tokens refill_rate" lines instead of JSON
+	decode := func(data []byte, v any) error {
+		config := v.(*RegistryConfig)
+
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var bc BucketConfig
+
+			if _, err := fmt.Sscan(line, &bc.Name, &bc.MaxTokens, &bc.RefillRate); err != nil {
+				return err
+			}
+			config.Buckets = append(config.Buckets, bc)
+		}
+		return nil
+	}
+	reg, err := NewRegistryFromConfig(strings.NewReader("api 2 1\nslow 1 1"),
+		SetConfigDecoder(decode), SetRegistryBucketOptions(SetClock(clock)))
+	if err != nil {
+		t.Fatalf("new registry: %v", err)
+	}
+	api, ok := reg.Get("api")
+	if !ok {
+		t.Fatal("bucket 'api' must be registered")
+	}
+	defer api.Close()
+
+	if !api.AllowN(2) || api.Allow() {
+		t.Fatal("declared capacity of 2 tokens is not enforced")
+	}
+	clock.Advance(time.Second)
+
+	if !api.Allow() || api.Allow() {
+		t.Fatal("declared rate of 1 token per second is not enforced by the registry clock")
+	}
+	if _, ok := reg.Get("missing"); ok {
+		t.Fatal("undeclared bucket must not be registered")
+	}
+	if _, err := NewRegistryFromConfig(strings.NewReader("api two 1"), SetConfigDecoder(decode)); err == nil {
+		t.Fatal("got nil error for the decoder failure")
+	}
+}
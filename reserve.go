@@ -0,0 +1,248 @@
+package token_bucket
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// infDuration returned by Reservation.Delay when the reservation can not be satisfied
+const infDuration = time.Duration(math.MaxInt64)
+
+// Reservation
+//
+//	holds tokens taken from the bucket in advance
+//
+//	Fields:
+//
+//	[tb]          bucket the tokens were taken from
+//	[ok]          reservation can be satisfied
+//	[tokens]      number of reserved tokens
+//	[timeToAct]   time at which the reserved tokens become available
+//	[canceled]    reservation is canceled
+//	[used]        reservation is confirmed by Use
+type Reservation struct {
+	tb        *TokenBucket
+	ok        bool
+	tokens    int
+	timeToAct time.Time
+	canceled  bool
+	used      bool
+}
+
+// Reserve returns Reservation for weight of one request or operation
+func (tb *TokenBucket) Reserve() *Reservation {
+	return tb.ReserveN(tb.Weight())
+}
+
+// ReserveN takes 'n' tokens from the bucket in advance and returns Reservation
+// which tells how long the caller must wait before the tokens can be used.
+// returned Reservation is not OK if 'n' tokens can never be available or 'n' is negative
+func (tb *TokenBucket) ReserveN(n int) *Reservation {
+	r, _ := tb.reserveN(n)
+	return r
+}
+
+// ReserveNMaxDelay works as ReserveN but grants the reservation only if the tokens are available
+// within 'maxDelay', otherwise returns not OK Reservation and 'false' without reserving anything
+func (tb *TokenBucket) ReserveNMaxDelay(n int, maxDelay time.Duration) (*Reservation, bool) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	r, err := tb.reserveLocked(n)
+	if err != nil {
+		return r, false
+	}
+	if r.DelayFrom(tb.now()) > maxDelay {
+		r.ok = false
+		tb.giveBack(r.tokens)
+
+		return r, false
+	}
+	return r, true
+}
+
+// ReserveNCtx works as ReserveN but cancels the reservation and returns the tokens
+// if the context is done before the reserved tokens become available.
+// on the happy path the caller sleeps Delay() and proceeds, the reservation is kept after that.
+// returns ctx.Err() without reserving if the context is already done
+func (tb *TokenBucket) ReserveNCtx(ctx context.Context, n int) (*Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return &Reservation{tb: tb, tokens: n}, err
+	}
+	r, err := tb.reserveN(n)
+	if err != nil {
+		return r, err
+	}
+	delay := r.Delay()
+
+	if delay > 0 && ctx.Done() != nil {
+		go r.cancelOnDone(ctx, delay)
+	}
+	return r, nil
+}
+
+// cancelOnDone cancels the reservation if the context is done within 'delay'
+func (r *Reservation) cancelOnDone(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		r.Cancel()
+	}
+}
+
+// reserveN returns Reservation for 'n' tokens and the reason if it is not OK
+func (tb *TokenBucket) reserveN(n int) (*Reservation, error) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.reserveLocked(n)
+}
+
+// reserveLocked returns Reservation for 'n' tokens and the reason if it is not OK.
+// must be called under the lock
+func (tb *TokenBucket) reserveLocked(n int) (*Reservation, error) {
+	tb.refill()
+
+	return tb.reserveAtLocked(n, tb.now())
+}
+
+// reserveAtLocked returns Reservation for 'n' tokens as if current time is 'nowT'.
+// negative 'n' is never OK and zero 'n' is OK immediately without any changes.
+// must be called under the lock
+func (tb *TokenBucket) reserveAtLocked(n int, nowT time.Time) (*Reservation, error) {
+	n = tb.clampOversize(n)
+
+	r := &Reservation{
+		tb:     tb,
+		tokens: n,
+	}
+	if n < 0 {
+		r.tokens = 0
+		return r, ErrNegativeTokens
+	}
+	if n == 0 {
+		r.ok = true
+		r.timeToAct = nowT
+
+		return r, nil
+	}
+	if int64(n) > tb.maxTokens {
+		return r, ErrExceedsMaxTokens
+	}
+	if tb.draining {
+		return r, ErrDraining
+	}
+	if tb.paused {
+		return r, ErrPaused
+	}
+	tb.refillAt(nowT)
+
+	if tb.currTokens < int64(n) && tb.refillRate <= 0 {
+		return r, ErrNoRefill
+	}
+	r.ok = true
+	r.timeToAct = nowT.Add(tb.delay(n, nowT))
+
+	// tokens are taken in advance, so the next reservations
+	// are queued behind this one instead of sharing the same refill
+	tb.currTokens -= int64(n)
+	tb.softLimited(tb.currTokens + int64(n))
+
+	if tb.reserveGrace > 0 {
+		tb.reservations = append(tb.reservations, r)
+	}
+	return r, nil
+}
+
+// SetReservationGrace set expiry of reservations which are neither used nor canceled:
+// the tokens of the reservation not confirmed by Use within Delay() plus 'grace'
+// are returned to the bucket on the next refilling. Wait confirms its reservations itself.
+// default: none, reservations never expire
+func SetReservationGrace(grace time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.reserveGrace = grace
+	}
+}
+
+// expireReservations returns tokens of reservations abandoned for the grace period after their time.
+// must be called under the lock
+func (tb *TokenBucket) expireReservations(nowT time.Time) {
+	kept := tb.reservations[:0]
+
+	for _, r := range tb.reservations {
+		if !r.ok || r.canceled || r.used {
+			continue
+		}
+		if nowT.Before(r.timeToAct.Add(tb.reserveGrace)) {
+			kept = append(kept, r)
+			continue
+		}
+		r.canceled = true
+		tb.giveBack(r.tokens)
+	}
+	for i := len(kept); i < len(tb.reservations); i++ {
+		tb.reservations[i] = nil
+	}
+	tb.reservations = kept
+}
+
+// Use confirms that the caller acts on the reservation, so it does not expire.
+// returns 'false' if the reservation is not OK, canceled or already expired
+func (r *Reservation) Use() bool {
+	if !r.ok {
+		return false
+	}
+	r.tb.lock.Lock()
+	defer r.tb.unlock()
+
+	if r.canceled {
+		return false
+	}
+	r.used = true
+
+	return true
+}
+
+// OK returns 'true' if the reserved tokens will be available in the future
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns duration the caller must wait before using the reserved tokens
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.tb.now())
+}
+
+// DelayFrom returns duration from 't' the caller must wait before using the reserved tokens
+func (r *Reservation) DelayFrom(t time.Time) time.Duration {
+	if !r.ok {
+		return infDuration
+	}
+	delay := r.timeToAct.Sub(t)
+
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Cancel returns the reserved tokens back to the bucket.
+// nothing is returned if the reservation time has already passed
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+	r.tb.lock.Lock()
+	defer r.tb.unlock()
+
+	if r.canceled || !r.tb.now().Before(r.timeToAct) {
+		return
+	}
+	r.canceled = true
+
+	r.tb.giveBack(r.tokens)
+}
@@ -0,0 +1,207 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReserveNNegative(t *testing.T) {
+	tb := NewTokenBucket(5, 1)
+
+	if r := tb.ReserveN(-100); r.OK() {
+		t.Fatal("negative reservation is OK")
+	}
+	if _, ok := tb.ReserveNMaxDelay(-100, time.Hour); ok {
+		t.Fatal("negative reservation within max delay is OK")
+	}
+	if err := tb.WaitN(context.Background(), -100); !errors.Is(err, ErrNegativeTokens) {
+		t.Fatalf("negative wait returned %v", err)
+	}
+	if err := tb.AcquireAll(context.Background(), -100); !errors.Is(err, ErrNegativeTokens) {
+		t.Fatalf("negative acquire returned %v", err)
+	}
+	if tb.AllowWithin(time.Hour, -100) || tb.AllowOrWait(-100, time.Hour) {
+		t.Fatal("negative n allowed within wait")
+	}
+	if got := tb.Tokens(); got != 5 {
+		t.Fatalf("tokens changed by negative requests: %d", got)
+	}
+}
+
+func TestReserveNZero(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	tb := NewTokenBucket(5, 1, SetClock(clock))
+	tb.AllowN(5)
+
+	r := tb.ReserveN(0)
+
+	if !r.OK() || r.Delay() != 0 {
+		t.Fatalf("zero reservation: ok %v, delay %s", r.OK(), r.Delay())
+	}
+	r.Cancel()
+
+	if err := tb.WaitN(context.Background(), 0); err != nil {
+		t.Fatalf("zero wait returned %v", err)
+	}
+	if !tb.AllowWithin(0, 0) {
+		t.Fatal("zero n denied within wait")
+	}
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("tokens changed by zero requests: %d", got)
+	}
+}
+
+func TestReserveNDelay(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(2)
+
+	r := tb.ReserveN(1)
+
+	if !r.OK() || r.Delay() != time.Second {
+		t.Fatalf("reservation: ok %v, delay %s, want delay 1s", r.OK(), r.Delay())
+	}
+	if tb.AllowN(1) {
+		t.Fatal("reserved token is allowed to another caller")
+	}
+	clock.Advance(400 * time.Millisecond)
+
+	if got := r.Delay(); got != 600*time.Millisecond {
+		t.Fatalf("delay after 400ms: got %s, want 600ms", got)
+	}
+	if r := tb.ReserveN(3); r.OK() {
+		t.Fatal("reservation over max tokens is OK")
+	}
+}
+
+func TestReserveNCancel(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(2)
+
+	r := tb.ReserveN(2)
+	r.Cancel()
+	clock.Advance(time.Second)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("canceled reservation must return the tokens: got %d tokens, want 1", got)
+	}
+	r = tb.ReserveN(1)
+	clock.Advance(time.Second)
+	r.Cancel()
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("cancel after the reservation time must return nothing: got %d tokens, want 1", got)
+	}
+}
+
+func TestReserveNCtx(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 2, SetRefillDuration(time.Hour), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(2)
+
+	done, cancelDone := context.WithCancel(context.Background())
+	cancelDone()
+
+	if r, err := tb.ReserveNCtx(done, 1); !errors.Is(err, context.Canceled) || r.OK() {
+		t.Fatalf("done context: got ok %v, %v, want context.Canceled without reserving", r.OK(), err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r, err := tb.ReserveNCtx(ctx, 2)
+	if err != nil || r.Delay() != time.Hour {
+		t.Fatalf("got delay %s, %v, want 1h", r.Delay(), err)
+	}
+	cancel()
+
+	// the reservation is canceled by the goroutine watching the context
+	deadline := time.Now().Add(time.Second)
+
+	for !isCanceled(r) {
+		if time.Now().After(deadline) {
+			t.Fatal("reservation is not canceled when the context is done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != 2 {
+		t.Fatalf("canceled reservation must return the tokens: got %d, want 2", got)
+	}
+}
+
+// isCanceled returns 'true' if the reservation is canceled
+func isCanceled(r *Reservation) bool {
+	r.tb.lock.Lock()
+	defer r.tb.unlock()
+
+	return r.canceled
+}
+
+func TestReserveNMaxDelay(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(2)
+
+	if r, ok := tb.ReserveNMaxDelay(2, time.Second); ok || r.OK() {
+		t.Fatal("reservation 2s away granted within 1s")
+	}
+	r, ok := tb.ReserveNMaxDelay(1, time.Second)
+
+	if !ok || r.Delay() != time.Second {
+		t.Fatalf("got ok %v, delay %s, want reservation 1s away", ok, r.Delay())
+	}
+	clock.Advance(2 * time.Second)
+
+	// the rejected reservation took nothing
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1", got)
+	}
+}
+
+func TestSetReservationGrace(t *testing.T) {
+	for _, grace := range []time.Duration{0, 500 * time.Millisecond} {
+		clock := NewTestClock(time.Unix(0, 0))
+
+		tb := NewTokenBucket(2, 1, SetClock(clock), SetReservationGrace(grace))
+		defer tb.Close()
+
+		tb.AllowN(2)
+
+		abandoned := tb.ReserveN(1)
+		used := tb.ReserveN(1)
+
+		if !used.Use() {
+			t.Fatal("reservation must be confirmed by Use")
+		}
+		clock.Advance(2 * time.Second)
+
+		if grace == 0 {
+			if got := tb.Tokens(); got != 0 || !abandoned.Use() {
+				t.Fatalf("reservations must not expire by default: got %d tokens", got)
+			}
+			continue
+		}
+		// the abandoned token is returned 500ms after its time, the used one is kept
+		if got := tb.Tokens(); got != 1 {
+			t.Fatalf("got %d tokens, want 1 returned by the abandoned reservation", got)
+		}
+		if abandoned.Use() {
+			t.Fatal("expired reservation confirmed by Use")
+		}
+	}
+}
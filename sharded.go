@@ -0,0 +1,87 @@
+package token_bucket
+
+import "sync/atomic"
+
+// ShardedBucket
+//
+//	splits one logical limit across several independently locked buckets
+//	selected round-robin, trading accuracy for less lock contention.
+//	the limit is not fair: a caller may be denied by one shard while another still has tokens,
+//	and a single request can not use more tokens than one shard holds
+//
+//	Fields:
+//
+//	[shards]   buckets sharing the limit
+//	[next]     counter selecting the next shard
+//	[tokenN]   weight for one request or operation
+type ShardedBucket struct {
+	shards []*TokenBucket
+	next   atomic.Uint64
+	tokenN int
+}
+
+var _ Limiter = (*ShardedBucket)(nil)
+
+// NewShardedBucket returns new ShardedBucket entity instance.
+// 'maxTokens' and 'refillRate' are split evenly across 'shards' buckets
+func NewShardedBucket(maxTokens, refillRate, shards int, options ...Option) *ShardedBucket {
+	if shards <= 0 {
+		shards = 1
+	}
+	sb := &ShardedBucket{
+		shards: make([]*TokenBucket, shards),
+	}
+
+	for i := range sb.shards {
+		sb.shards[i] = NewTokenBucket(
+			splitEvenly(maxTokens, shards, i),
+			splitEvenly(refillRate, shards, i),
+			options...,
+		)
+	}
+	sb.tokenN = sb.shards[0].Weight()
+
+	return sb
+}
+
+// splitEvenly returns part 'i' of 'total' split into 'parts', remainder goes to the first parts
+func splitEvenly(total, parts, i int) int {
+	part := total / parts
+
+	if i < total%parts {
+		part++
+	}
+	return part
+}
+
+// shard returns the next shard round-robin
+func (sb *ShardedBucket) shard() *TokenBucket {
+	return sb.shards[(sb.next.Add(1)-1)%uint64(len(sb.shards))]
+}
+
+// AllowN return 'true' if there are 'n' tokens in the selected shard
+func (sb *ShardedBucket) AllowN(n int) bool {
+	return sb.shard().AllowN(n)
+}
+
+// Allow returns 'true' if there are enough tokens in the selected shard
+func (sb *ShardedBucket) Allow() bool {
+	return sb.AllowN(sb.tokenN)
+}
+
+// Tokens returns current token number in all shards
+func (sb *ShardedBucket) Tokens() int {
+	n := 0
+
+	for _, tb := range sb.shards {
+		n += tb.Tokens()
+	}
+	return n
+}
+
+// Close stops goroutines of all shards
+func (sb *ShardedBucket) Close() {
+	for _, tb := range sb.shards {
+		tb.Close()
+	}
+}
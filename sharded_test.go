@@ -0,0 +1,114 @@
+package token_bucket
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedBucketSplit(t *testing.T) {
+	sb := NewShardedBucket(10, 7, 3, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer sb.Close()
+
+	for i, want := range []int{4, 3, 3} {
+		if got := sb.shards[i].Capacity(); got != want {
+			t.Fatalf("shard %d: got capacity %d, want %d", i, got, want)
+		}
+	}
+	for i, want := range []int{3, 2, 2} {
+		if got := sb.shards[i].RefillRate(); got != want {
+			t.Fatalf("shard %d: got refill rate %d, want %d", i, got, want)
+		}
+	}
+	if got := sb.Tokens(); got != 10 {
+		t.Fatalf("got %d tokens in all shards, want 10", got)
+	}
+}
+
+func TestShardedBucketConcurrent(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	sb := NewShardedBucket(100, 40, 4, SetRefillDuration(time.Millisecond), SetClock(clock))
+	defer sb.Close()
+
+	const (
+		workers = 8
+		calls   = 2000
+		ticks   = 50
+	)
+	var (
+		wg      sync.WaitGroup
+		allowed = make([]int, workers)
+	)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < ticks; i++ {
+			clock.Advance(time.Millisecond)
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < calls; i++ {
+				if sb.Allow() {
+					allowed[w]++
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	<-done
+
+	total := 0
+	for _, n := range allowed {
+		total += n
+	}
+	// shards never give out more than the logical limit together
+	if limit := 100 + ticks*40; total > limit {
+		t.Fatalf("allowed %d tokens, at most %d are available", total, limit)
+	}
+	if total < 100 {
+		t.Fatalf("allowed %d tokens, the initial 100 must be consumed", total)
+	}
+	if tokens := sb.Tokens(); tokens < 0 || tokens > 100 {
+		t.Fatalf("tokens out of [0, 100]: %d", tokens)
+	}
+}
+
+func BenchmarkShardedBucket(b *testing.B) {
+	b.Run("single", func(b *testing.B) {
+		tb := NewTokenBucket(math.MaxInt32, 1, SetRefillDuration(time.Hour))
+		defer tb.Close()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				tb.Allow()
+			}
+		})
+	})
+	for _, shards := range []int{4, 16} {
+		b.Run(fmt.Sprint("shards-", shards), func(b *testing.B) {
+			sb := NewShardedBucket(math.MaxInt32, shards, shards, SetRefillDuration(time.Hour))
+			defer sb.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					sb.Allow()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package token_bucket
+
+import "time"
+
+// Simulate replays the arrivals through the bucket with AllowAt, one request weight per arrival,
+// and returns numbers of allowed and denied arrivals with decision per arrival.
+// arrivals should be in time order and the bucket should be created at or before the first arrival,
+// e.g. with SetClock(NewTestClock(start)), so the outcome is deterministic without real sleeps.
+// the bucket state and counters are changed by the replay
+func Simulate(tb *TokenBucket, arrivals []time.Time) (allowed, denied int, decisions []bool) {
+	decisions = make([]bool, len(arrivals))
+
+	for i, t := range arrivals {
+		decisions[i] = tb.AllowAt(t)
+
+		if decisions[i] {
+			allowed++
+		} else {
+			denied++
+		}
+	}
+	return allowed, denied, decisions
+}
@@ -0,0 +1,32 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	startT := time.Unix(0, 0)
+
+	tb := NewTokenBucket(2, 1, SetClock(NewTestClock(startT)))
+	defer tb.Close()
+
+	// burst of four arrivals and then one arrival every 500ms
+	arrivals := []time.Time{startT, startT, startT, startT}
+
+	for i := 1; i <= 4; i++ {
+		arrivals = append(arrivals, startT.Add(time.Duration(i)*500*time.Millisecond))
+	}
+	allowed, denied, decisions := Simulate(tb, arrivals)
+
+	if allowed != 4 || denied != 4 {
+		t.Fatalf("got %d allowed and %d denied, want 4 and 4", allowed, denied)
+	}
+	want := []bool{true, true, false, false, false, true, false, true}
+
+	for i := range want {
+		if decisions[i] != want[i] {
+			t.Fatalf("got decisions %v, want %v", decisions, want)
+		}
+	}
+}
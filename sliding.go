@@ -0,0 +1,103 @@
+package token_bucket
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindowLimiter
+//
+//	implement the sliding window log algorithm: a request is allowed only if fewer than 'limit'
+//	tokens were consumed during the trailing window, so unlike TokenBucket it never allows
+//	more than 'limit' in any rolling window. it is precise but keeps time of every consumed token,
+//	so memory grows with 'limit' instead of being constant
+//
+//	Fields:
+//
+//	[limit]    maximum number of tokens consumed in any window
+//	[window]   duration of the trailing window
+//	[log]      times of tokens consumed in the window in time order
+//	[lock]     mutex for atomic operations
+//
+//	For Options:
+//
+//	[tokenN] weight for one request or operation. default: 1
+//	[clock] source of current time. default: wall clock with monotonic reading
+type SlidingWindowLimiter struct {
+	limit  int
+	window time.Duration
+	log    []time.Time
+	lock   sync.Mutex
+
+	tokenN int
+	clock  Clock
+}
+
+var _ Limiter = (*SlidingWindowLimiter)(nil)
+
+// NewSlidingWindowLimiter returns new SlidingWindowLimiter entity instance.
+// options not related to weight and clock are ignored
+func NewSlidingWindowLimiter(limit int, window time.Duration, options ...Option) *SlidingWindowLimiter {
+	cfg := NewTokenBucket(limit, limit, options...)
+
+	sw := &SlidingWindowLimiter{
+		limit:  limit,
+		window: window,
+		tokenN: cfg.Weight(),
+		clock:  cfg.clock,
+	}
+	cfg.Close()
+
+	return sw
+}
+
+// prune removes times of tokens consumed before the window.
+// must be called under the lock
+func (sw *SlidingWindowLimiter) prune(nowT time.Time) {
+	startT := nowT.Add(-sw.window)
+	i := 0
+
+	for i < len(sw.log) && !sw.log[i].After(startT) {
+		i++
+	}
+	if i > 0 {
+		sw.log = append(sw.log[:0], sw.log[i:]...)
+	}
+}
+
+// AllowN return 'true' if fewer than 'limit' tokens including 'n' were consumed in the trailing window.
+// non-positive 'n' is denied
+func (sw *SlidingWindowLimiter) AllowN(n int) bool {
+	if n <= 0 {
+		return false
+	}
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+
+	nowT := sw.clock.Now()
+	sw.prune(nowT)
+
+	// compared without adding 'n', so huge 'n' can not overflow
+	if n > sw.limit-len(sw.log) {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		sw.log = append(sw.log, nowT)
+	}
+	return true
+}
+
+// Allow returns 'true' if there is room in the window for weight of one request or operation
+func (sw *SlidingWindowLimiter) Allow() bool {
+	return sw.AllowN(sw.tokenN)
+}
+
+// Remaining returns number of tokens which can be consumed in the window now
+func (sw *SlidingWindowLimiter) Remaining() int {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+
+	sw.prune(sw.clock.Now())
+
+	return sw.limit - len(sw.log)
+}
@@ -0,0 +1,39 @@
+package token_bucket
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	sw := NewSlidingWindowLimiter(3, time.Second, SetClock(clock))
+
+	if !sw.AllowN(2) {
+		t.Fatal("first request must be allowed")
+	}
+	clock.Advance(500 * time.Millisecond)
+
+	if !sw.Allow() || sw.Allow() {
+		t.Fatal("only one token must be left in the window")
+	}
+	clock.Advance(500 * time.Millisecond)
+
+	if got := sw.Remaining(); got != 2 {
+		t.Fatalf("tokens of the first request must leave the window: got %d remaining, want 2", got)
+	}
+}
+
+func TestSlidingWindowLimiterInvalidN(t *testing.T) {
+	sw := NewSlidingWindowLimiter(3, time.Second, SetClock(NewTestClock(time.Unix(0, 0))))
+
+	for _, n := range []int{math.MinInt, -1, 0, 4, math.MaxInt} {
+		if sw.AllowN(n) {
+			t.Fatalf("n %d must be denied", n)
+		}
+	}
+	if got := sw.Remaining(); got != 3 {
+		t.Fatalf("denied requests must not consume: got %d remaining, want 3", got)
+	}
+}
@@ -0,0 +1,153 @@
+package token_bucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrInvalidSnapshot returned when the decoded state of the bucket has out of range fields
+var ErrInvalidSnapshot = errors.New("token_bucket: invalid snapshot")
+
+// Snapshot
+//
+//	serializable state of TokenBucket
+//
+//	Fields:
+//
+//	[MaxTokens]    maximum number of tokens in bucket
+//	[RefillRate]   number of tokens to be added in bucket per refill duration
+//	[CurrTokens]   current token number in bucket
+//	[PartTokens]   fractional part of token accumulated in continuous refill mode
+//	[LastFillT]    time of the last refilling of the bucket
+//	[RefillDur]    bucket refill duration
+//	[TokenN]       weight for one request or operation
+//	[Continuous]   continuous refill mode
+type Snapshot struct {
+	MaxTokens  int           `json:"max_tokens"`
+	RefillRate int           `json:"refill_rate"`
+	CurrTokens int           `json:"curr_tokens"`
+	PartTokens float64       `json:"part_tokens,omitempty"`
+	LastFillT  time.Time     `json:"last_fill_t"`
+	RefillDur  time.Duration `json:"refill_dur"`
+	TokenN     int           `json:"token_n"`
+	Continuous bool          `json:"continuous,omitempty"`
+}
+
+// Snapshot returns current state of the bucket
+func (tb *TokenBucket) Snapshot() Snapshot {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return Snapshot{
+		MaxTokens:  int(tb.maxTokens),
+		RefillRate: int(tb.refillRate),
+		CurrTokens: int(tb.currTokens),
+		PartTokens: tb.partTokens,
+		LastFillT:  tb.lastFillT,
+		RefillDur:  tb.refillDur,
+		TokenN:     tb.Weight(),
+		Continuous: tb.continuous,
+	}
+}
+
+// NewTokenBucketFromSnapshot returns new TokenBucket entity instance restored from the snapshot.
+// tokens for the time elapsed since the snapshot are credited, capped at max tokens
+func NewTokenBucketFromSnapshot(s Snapshot, options ...Option) *TokenBucket {
+	options = append([]Option{
+		SetRefillDuration(s.RefillDur),
+		SetTokenN(s.TokenN),
+		SetContinuousRefill(s.Continuous),
+	}, options...)
+
+	tb := NewTokenBucket(s.MaxTokens, s.RefillRate, options...)
+
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.restore(int64(s.CurrTokens), s.PartTokens, s.LastFillT)
+
+	return tb
+}
+
+// restore set the bucket tokens and the last filling time and refill the bucket.
+// must be called under the lock
+func (tb *TokenBucket) restore(currTokens int64, partTokens float64, lastFillT time.Time) {
+	tb.currTokens = currTokens
+	tb.partTokens = partTokens
+	tb.lastFillT = lastFillT
+	tb.refillT = tb.nextT()
+	tb.early, tb.earlyN = false, 0
+
+	if tb.currTokens > tb.maxTokens {
+		tb.currTokens = tb.maxTokens
+	}
+	tb.refill()
+}
+
+// MarshalJSON implements json.Marshaler
+func (tb *TokenBucket) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tb.Snapshot())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+// returns ErrInvalidSnapshot if the state has out of range fields, the bucket is not changed then
+func (tb *TokenBucket) UnmarshalJSON(data []byte) error {
+	var s Snapshot
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return tb.apply(s)
+}
+
+// validate returns ErrInvalidSnapshot if the snapshot fields are out of range:
+// non-positive max tokens, refill duration or token weight, negative refill rate and invalid fractional tokens.
+// current tokens are not bounded: reservations and AllowDebt drive the bucket into debt
+// and SetBurst or SetMaxTokensGraceful keep tokens over max tokens
+func (s Snapshot) validate() error {
+	switch {
+	case s.MaxTokens <= 0:
+		return fmt.Errorf("%w: max tokens %d", ErrInvalidSnapshot, s.MaxTokens)
+	case s.RefillRate < 0:
+		return fmt.Errorf("%w: refill rate %d", ErrInvalidSnapshot, s.RefillRate)
+	case s.RefillDur <= 0:
+		return fmt.Errorf("%w: refill duration %s", ErrInvalidSnapshot, s.RefillDur)
+	case s.TokenN < 1:
+		return fmt.Errorf("%w: token weight %d", ErrInvalidSnapshot, s.TokenN)
+	case math.IsNaN(s.PartTokens) || s.PartTokens < 0 || s.PartTokens >= 1:
+		return fmt.Errorf("%w: fractional tokens %v", ErrInvalidSnapshot, s.PartTokens)
+	}
+	return nil
+}
+
+// apply set the bucket configuration and state from the snapshot.
+// returns error of the snapshot validation before the bucket is changed
+func (tb *TokenBucket) apply(s Snapshot) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.maxTokens = int64(s.MaxTokens)
+	tb.refillRate = int64(s.RefillRate)
+	tb.refillDur = tb.clampInterval(s.RefillDur)
+	tb.tokenN.Store(int64(s.TokenN))
+	tb.continuous = s.Continuous
+
+	if tb.clock == nil {
+		tb.clock = realClock{}
+	}
+	if tb.done == nil {
+		tb.done = make(chan struct{})
+	}
+	tb.currTokens = int64(s.CurrTokens)
+	tb.partTokens = s.PartTokens
+	tb.lastFillT = s.LastFillT
+	tb.refillT = tb.nextT()
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package token_bucket
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func validSnapshot() Snapshot {
+	return Snapshot{
+		MaxTokens:  10,
+		RefillRate: 1,
+		CurrTokens: 5,
+		LastFillT:  time.Unix(0, 0),
+		RefillDur:  time.Second,
+		TokenN:     1,
+	}
+}
+
+func invalidSnapshots() map[string]Snapshot {
+	cases := map[string]func(s *Snapshot){
+		"zero max tokens":        func(s *Snapshot) { s.MaxTokens = 0 },
+		"negative refill rate":   func(s *Snapshot) { s.RefillRate = -1 },
+		"zero refill duration":   func(s *Snapshot) { s.RefillDur = 0 },
+		"negative refill dur":    func(s *Snapshot) { s.RefillDur = -time.Second },
+		"zero token weight":      func(s *Snapshot) { s.TokenN = 0 },
+		"NaN fractional tokens":  func(s *Snapshot) { s.PartTokens = math.NaN() },
+		"whole fractional token": func(s *Snapshot) { s.PartTokens = 1 },
+	}
+	snapshots := make(map[string]Snapshot, len(cases))
+
+	for name, mutate := range cases {
+		s := validSnapshot()
+		mutate(&s)
+		snapshots[name] = s
+	}
+	return snapshots
+}
+
+func TestUnmarshalJSONRoundTrip(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(4)
+
+	data, err := json.Marshal(tb)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	restored := NewTokenBucket(1, 1, SetClock(clock))
+	defer restored.Close()
+
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := restored.Tokens(); got != 6 {
+		t.Fatalf("got %d tokens, want 6", got)
+	}
+}
+
+// debtAndSurplusBuckets returns buckets with current tokens the bucket reaches itself out of [-max, max]
+func debtAndSurplusBuckets(clock Clock) map[string]*TokenBucket {
+	debt := NewTokenBucket(10, 1, SetClock(clock))
+
+	for i := 0; i < 3; i++ {
+		debt.ReserveN(10)
+	}
+	allowDebt := NewTokenBucket(2, 1, SetClock(clock))
+	allowDebt.AllowDebt(7, 5)
+
+	graceful := NewTokenBucket(10, 1, SetClock(clock))
+	graceful.SetMaxTokensGraceful(4)
+
+	return map[string]*TokenBucket{
+		"reservation debt": debt,
+		"allowed debt":     allowDebt,
+		"burst surplus":    NewTokenBucket(10, 1, SetClock(clock), SetBurst(25)),
+		"graceful surplus": graceful,
+	}
+}
+
+func TestUnmarshalJSONDebtAndSurplus(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	for name, tb := range debtAndSurplusBuckets(clock) {
+		data, err := json.Marshal(tb)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", name, err)
+		}
+		restored := NewTokenBucket(1, 1, SetClock(clock))
+
+		if err := json.Unmarshal(data, restored); err != nil {
+			t.Fatalf("%s: unmarshal own state: %v", name, err)
+		}
+		if !EqualState(restored, tb) {
+			t.Fatalf("%s: got snapshot %+v, want %+v", name, restored.Snapshot(), tb.Snapshot())
+		}
+		restored.Close()
+		tb.Close()
+	}
+}
+
+func TestUnmarshalJSONInvalid(t *testing.T) {
+	for name, s := range invalidSnapshots() {
+		data, err := json.Marshal(s)
+		if err != nil {
+			// NaN is not encodable, it is covered by UnmarshalBinary
+			continue
+		}
+		tb := NewTokenBucket(3, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+
+		if err := tb.UnmarshalJSON(data); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Fatalf("%s: got error %v, want ErrInvalidSnapshot", name, err)
+		}
+		if tb.Capacity() != 3 || tb.Tokens() != 3 {
+			t.Fatalf("%s: bucket must not be changed by invalid snapshot", name)
+		}
+		tb.Close()
+	}
+}
+
+func TestNewTokenBucketFromSnapshot(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 1, SetRefillDuration(time.Minute), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(8)
+
+	s := tb.Snapshot()
+
+	// restarted process restores the bucket three minutes later
+	clock.Advance(3*time.Minute + 30*time.Second)
+
+	restored := NewTokenBucketFromSnapshot(s, SetClock(clock))
+	defer restored.Close()
+
+	if got := restored.Tokens(); got != 5 {
+		t.Fatalf("elapsed intervals must be credited: got %d tokens, want 5", got)
+	}
+	if got := restored.RefillDuration(); got != time.Minute {
+		t.Fatalf("got refill duration %s, want 1m", got)
+	}
+	clock.Advance(30 * time.Second)
+
+	if got := restored.Tokens(); got != 6 {
+		t.Fatalf("partial interval must be kept: got %d tokens, want 6", got)
+	}
+}
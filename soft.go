@@ -0,0 +1,38 @@
+package token_bucket
+
+// SetSoftLimit set soft limit as ratio of 'maxTokens', e.g. 0.2 for 20% tokens remaining.
+// consumption crossing the soft limit downward calls the callback set by SetOnSoftLimit,
+// as early warning before requests are denied. non-positive ratio disables the soft limit
+func SetSoftLimit(ratio float64) Option {
+	return func(tb *TokenBucket) {
+		tb.softLimit = ratio
+	}
+}
+
+// SetOnSoftLimit set callback called when consumption drops current tokens below the soft limit
+// with current and maximum tokens number. the callback is called once per crossing, not on every
+// consumption below the limit, and after the bucket lock is released, so it may use the bucket
+func SetOnSoftLimit(fn func(current, max int)) Option {
+	return func(tb *TokenBucket) {
+		tb.onSoftLimit = fn
+	}
+}
+
+// softLimited schedules the soft limit callback if consumption moved current tokens
+// from 'before' to below the soft limit.
+// must be called under the lock
+func (tb *TokenBucket) softLimited(before int64) {
+	if tb.onSoftLimit == nil || tb.softLimit <= 0 {
+		return
+	}
+	limit := tb.softLimit * float64(tb.maxTokens)
+
+	if float64(before) < limit || float64(tb.currTokens) >= limit {
+		return
+	}
+	fn, current, capacity := tb.onSoftLimit, int(tb.currTokens), int(tb.maxTokens)
+
+	tb.later(func() {
+		fn(current, capacity)
+	})
+}
@@ -0,0 +1,97 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetSoftLimit(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	type call struct{ current, max int }
+	var calls []call
+
+	tb := NewTokenBucket(10, 1, SetClock(clock), SetSoftLimit(0.3), SetOnSoftLimit(func(current, max int) {
+		calls = append(calls, call{current, max})
+	}))
+	defer tb.Close()
+
+	// consumption above the soft limit does not call the callback
+	if !tb.AllowN(7) {
+		t.Fatal("request within tokens denied")
+	}
+	if len(calls) != 0 {
+		t.Fatalf("got %d callback calls above the soft limit, want 0", len(calls))
+	}
+	// 3 tokens left are not below the soft limit of 3, the next request crosses it
+	if !tb.Allow() {
+		t.Fatal("request within tokens denied")
+	}
+	if len(calls) != 1 || calls[0] != (call{2, 10}) {
+		t.Fatalf("got callback calls %v, want one call with 2 of 10 tokens", calls)
+	}
+	// further consumption below the limit is the same crossing
+	if !tb.Allow() {
+		t.Fatal("request within tokens denied")
+	}
+	if len(calls) != 1 {
+		t.Fatalf("got %d callback calls, want 1 per crossing", len(calls))
+	}
+	clock.Advance(5 * time.Second)
+
+	// the refill moved tokens back above the limit, so the next crossing calls again
+	if r := tb.ReserveN(4); !r.OK() {
+		t.Fatal("reservation within tokens failed")
+	}
+	if len(calls) != 2 || calls[1] != (call{2, 10}) {
+		t.Fatalf("got callback calls %v, want a second call with 2 of 10 tokens", calls)
+	}
+	clone := tb.Clone()
+	defer clone.Close()
+
+	clock.Advance(10 * time.Second)
+
+	if !clone.AllowN(9) {
+		t.Fatal("request within tokens denied by clone")
+	}
+	if len(calls) != 3 || calls[2] != (call{1, 10}) {
+		t.Fatalf("got callback calls %v, want the clone to keep the soft limit", calls)
+	}
+}
+
+func TestSetSoftLimitDisabled(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+	calls := 0
+
+	tb := NewTokenBucket(10, 1, SetClock(clock), SetSoftLimit(0), SetOnSoftLimit(func(int, int) {
+		calls++
+	}))
+	defer tb.Close()
+
+	if !tb.AllowN(10) {
+		t.Fatal("request within tokens denied")
+	}
+	if calls != 0 {
+		t.Fatalf("got %d callback calls with non-positive soft limit, want 0", calls)
+	}
+}
+
+func TestSetOnSoftLimitUsesBucket(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	var tb *TokenBucket
+	got := -1
+
+	tb = NewTokenBucket(4, 1, SetClock(clock), SetSoftLimit(0.5), SetOnSoftLimit(func(int, int) {
+		// the callback runs after the lock is released
+		got = tb.Tokens()
+	}))
+	defer tb.Close()
+
+	if !tb.AllowN(3) {
+		t.Fatal("request within tokens denied")
+	}
+	if got != 1 {
+		t.Fatalf("got %d tokens from the callback, want 1", got)
+	}
+}
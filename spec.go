@@ -0,0 +1,45 @@
+package token_bucket
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRate parses rate spec like "100/s", "500/m", "2/h" or "10/250ms".
+// the number before the slash is refill rate per the duration after it,
+// max tokens equal to the refill rate
+func ParseRate(spec string) (maxTokens, refillRate int, refillDur time.Duration, err error) {
+	count, per, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("token_bucket: invalid rate spec %q: missing '/'", spec)
+	}
+	refillRate, err = strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || refillRate <= 0 {
+		return 0, 0, 0, fmt.Errorf("token_bucket: invalid rate spec %q: count must be positive integer", spec)
+	}
+	per = strings.TrimSpace(per)
+
+	// bare unit like "s" means one unit
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	refillDur, err = time.ParseDuration(per)
+	if err != nil || refillDur <= 0 {
+		return 0, 0, 0, fmt.Errorf("token_bucket: invalid rate spec %q: duration must be positive", spec)
+	}
+	return refillRate, refillRate, refillDur, nil
+}
+
+// NewTokenBucketFromSpec returns new TokenBucket entity instance configured by rate spec.
+// max tokens can be overridden with SetCapacity option
+func NewTokenBucketFromSpec(spec string, options ...Option) (*TokenBucket, error) {
+	maxTokens, refillRate, refillDur, err := ParseRate(spec)
+	if err != nil {
+		return nil, err
+	}
+	options = append([]Option{SetRefillDuration(refillDur)}, options...)
+
+	return NewTokenBucketChecked(maxTokens, refillRate, options...)
+}
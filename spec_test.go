@@ -0,0 +1,49 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	valid := map[string]struct {
+		rate int
+		dur  time.Duration
+	}{
+		"100/s":     {100, time.Second},
+		"60/1m":     {60, time.Minute},
+		"500/m":     {500, time.Minute},
+		" 2 / h ":   {2, time.Hour},
+		"10/250ms":  {10, 250 * time.Millisecond},
+		"1/1h30m0s": {1, 90 * time.Minute},
+	}
+	for spec, want := range valid {
+		maxTokens, rate, dur, err := ParseRate(spec)
+		if err != nil {
+			t.Fatalf("%q: %v", spec, err)
+		}
+		if maxTokens != want.rate || rate != want.rate || dur != want.dur {
+			t.Fatalf("%q: got %d, %d per %s, want %d per %s", spec, maxTokens, rate, dur, want.rate, want.dur)
+		}
+	}
+	for _, spec := range []string{"", "100", "0/s", "-1/s", "x/s", "10/", "10/0s", "10/-1s", "10/parsec"} {
+		if _, _, _, err := ParseRate(spec); err == nil {
+			t.Fatalf("invalid spec %q is accepted", spec)
+		}
+	}
+}
+
+func TestNewTokenBucketFromSpec(t *testing.T) {
+	tb, err := NewTokenBucketFromSpec("3/250ms", SetCapacity(5), SetClock(NewTestClock(time.Unix(0, 0))))
+	if err != nil {
+		t.Fatalf("valid spec: %v", err)
+	}
+	defer tb.Close()
+
+	if tb.Capacity() != 5 || tb.RefillRate() != 3 || tb.RefillDuration() != 250*time.Millisecond {
+		t.Fatalf("got bucket %s", tb)
+	}
+	if _, err := NewTokenBucketFromSpec("3"); err == nil {
+		t.Fatal("invalid spec is accepted")
+	}
+}
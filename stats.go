@@ -0,0 +1,114 @@
+package token_bucket
+
+import "time"
+
+// Stats
+//
+//	counters of the bucket since its creation
+//
+//	Fields:
+//
+//	[Allowed]   number of allowed requests or operations
+//	[Denied]    number of denied requests or operations
+//	[Tokens]    current token number in bucket
+//	[Name]      label of the bucket
+//	[Consumed]  number of tokens consumed by allowed requests or operations
+//	[Rate]      tokens consumed per second over the recent window, see SetRateWindow
+//	[WouldDeny] number of requests or operations allowed only by the dry run mode, see SetDryRun
+//	[RefillLateMax] maximum delay of interval refill behind its scheduled time, see SetLatenessStats
+//	[RefillLateAvg] average delay of interval refills behind their scheduled time, see SetLatenessStats
+//	[DenyStreak] number of consecutive denials since the last allowed request or operation
+//	[DenyStreakMax] maximum number of consecutive denials, long streak means sustained starvation
+type Stats struct {
+	Allowed   int64
+	Denied    int64
+	Tokens    int
+	Name      string
+	Consumed  int64
+	Rate      float64
+	WouldDeny int64
+
+	RefillLateMax time.Duration
+	RefillLateAvg time.Duration
+	DenyStreak    int64
+	DenyStreakMax int64
+}
+
+// Stats returns copy of the bucket counters
+func (tb *TokenBucket) Stats() Stats {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	return Stats{
+		Allowed:   tb.allowedN,
+		Denied:    tb.deniedN,
+		Tokens:    int(tb.currTokens),
+		Name:      tb.name,
+		Consumed:  tb.consumedN,
+		Rate:      tb.effectiveRate(),
+		WouldDeny: tb.wouldDenyN,
+
+		RefillLateMax: tb.lateMax,
+		RefillLateAvg: tb.lateAvg(),
+		DenyStreak:    tb.streakN,
+		DenyStreakMax: tb.streakMax,
+	}
+}
+
+// TotalConsumed returns number of tokens consumed by allowed requests or operations
+// over the bucket lifetime. it is not zeroed by Reset, only by ResetStats
+func (tb *TokenBucket) TotalConsumed() int64 {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return tb.consumedN
+}
+
+// ResetStats zeroes the allowed, denied, would-deny and consumed counters, the denial streaks and the rate window,
+// e.g. for per-interval reporting
+func (tb *TokenBucket) ResetStats() {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.allowedN = 0
+	tb.deniedN = 0
+	tb.consumedN = 0
+	tb.wouldDenyN = 0
+	tb.rateSlots = nil
+	tb.lateMax, tb.lateSum, tb.lateN = 0, 0, 0
+	tb.streakN, tb.streakMax = 0, 0
+}
+
+// SetLatenessStats set recording of interval refill lateness reported by Stats as RefillLateMax and RefillLateAvg.
+// lazy refilling after idle is late by the idle time, so it is meaningful mostly with SetBackgroundRefill,
+// where refills are late only if the process is busy. continuous refill mode has no scheduled refills,
+// so the lateness is always zero in it
+func SetLatenessStats(enabled bool) Option {
+	return func(tb *TokenBucket) {
+		tb.lateStats = enabled
+	}
+}
+
+// late records lateness of interval refill behind its scheduled time.
+// must be called under the lock
+func (tb *TokenBucket) late(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	if d > tb.lateMax {
+		tb.lateMax = d
+	}
+	tb.lateSum += d
+	tb.lateN++
+}
+
+// lateAvg returns average lateness of interval refills.
+// must be called under the lock
+func (tb *TokenBucket) lateAvg() time.Duration {
+	if tb.lateN == 0 {
+		return 0
+	}
+	return tb.lateSum / time.Duration(tb.lateN)
+}
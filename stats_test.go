@@ -0,0 +1,132 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsCounters(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock), SetName("api"))
+	defer tb.Close()
+
+	tb.AllowN(2)
+	tb.Allow()
+	tb.Allow()
+
+	s := tb.Stats()
+
+	if s.Allowed != 1 || s.Denied != 2 || s.Consumed != 2 || s.Name != "api" {
+		t.Fatalf("got stats %+v", s)
+	}
+	if s.DenyStreak != 2 || s.DenyStreakMax != 2 {
+		t.Fatalf("got deny streak %d, max %d, want 2 and 2", s.DenyStreak, s.DenyStreakMax)
+	}
+	tb.ResetStats()
+
+	if s := tb.Stats(); s.Allowed != 0 || s.Denied != 0 || s.DenyStreakMax != 0 {
+		t.Fatalf("counters must be zeroed: got %+v", s)
+	}
+}
+
+func TestLatenessStats(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	off := NewTokenBucket(2, 1, SetClock(clock))
+	defer off.Close()
+
+	on := NewTokenBucket(2, 1, SetClock(clock), SetLatenessStats(true))
+	defer on.Close()
+
+	off.AllowN(2)
+	on.AllowN(2)
+
+	// the first refill is due at 1s, so it is 500ms late
+	clock.Advance(1500 * time.Millisecond)
+	off.Allow()
+	on.Allow()
+
+	if s := off.Stats(); s.RefillLateMax != 0 || s.RefillLateAvg != 0 {
+		t.Fatalf("lateness must not be recorded by default: got %+v", s)
+	}
+	if s := on.Stats(); s.RefillLateMax != 500*time.Millisecond || s.RefillLateAvg != 500*time.Millisecond {
+		t.Fatalf("got lateness max %v, avg %v, want 500ms", s.RefillLateMax, s.RefillLateAvg)
+	}
+	if clone := on.Clone(); !clone.lateStats {
+		t.Fatal("clone must keep lateness stats option")
+	}
+}
+
+func TestTotalConsumed(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(2)
+	tb.AllowMany(10)
+	tb.AllowN(1)
+
+	if got := tb.TotalConsumed(); got != 5 {
+		t.Fatalf("got %d consumed, want 5 without the denied request", got)
+	}
+	tb.Reset()
+	tb.AllowDebt(7, 2)
+
+	if got := tb.TotalConsumed(); got != 12 {
+		t.Fatalf("got %d consumed, want 12 kept over Reset", got)
+	}
+	tb.ResetStats()
+
+	if got := tb.TotalConsumed(); got != 0 {
+		t.Fatalf("got %d consumed, want 0 after ResetStats", got)
+	}
+}
+
+func TestDenyStreak(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock))
+	defer tb.Close()
+
+	tb.Allow()
+
+	for i := 0; i < 3; i++ {
+		tb.Allow()
+	}
+	clock.Advance(time.Second)
+	tb.Allow()
+	tb.Allow()
+
+	// the allowed request ends the streak of 3, the maximum is kept
+	if s := tb.Stats(); s.DenyStreak != 1 || s.DenyStreakMax != 3 {
+		t.Fatalf("got deny streak %d, max %d, want 1 and 3", s.DenyStreak, s.DenyStreakMax)
+	}
+}
+
+func TestStatsTokensRefilled(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(4, 2, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(4)
+
+	if s := tb.Stats(); s.Tokens != 0 || s.Consumed != 4 {
+		t.Fatalf("got stats %+v, want 0 tokens and 4 consumed", s)
+	}
+	clock.Advance(time.Second)
+
+	// the snapshot includes the refill due by the bucket clock
+	s := tb.Stats()
+
+	if s.Tokens != 2 {
+		t.Fatalf("got %d tokens in stats, want 2 after the refill", s.Tokens)
+	}
+	s.Allowed = 100
+
+	if got := tb.Stats().Allowed; got != 1 {
+		t.Fatalf("got %d allowed, want the stats copy not changing the bucket", got)
+	}
+}
@@ -0,0 +1,253 @@
+package token_bucket
+
+import (
+	"fmt"
+	"sync"
+)
+
+const keyedShardsN = 16 // default number of independently locked key shards
+
+// Store
+//
+//	keeps key buckets of KeyedLimiter in memory or in external storage, e.g. Memcached or DynamoDB.
+//	implementations must be safe for concurrent use
+type Store[K comparable] interface {
+	// Do runs 'fn' with the key bucket, the bucket is created by 'create' if the key is missing.
+	// loading, refilling and consuming tokens done by 'fn' must be atomic for the key:
+	// external stores load the bucket state, e.g. with NewTokenBucketFromSnapshot,
+	// hold the key lock or use compare-and-swap while 'fn' runs and save Snapshot of the bucket after it
+	Do(key K, create func() *TokenBucket, fn func(tb *TokenBucket)) error
+
+	// Delete removes the key bucket
+	Delete(key K)
+
+	// Len returns number of key buckets
+	Len() int
+
+	// Evict removes key buckets for which 'idle' returns 'true',
+	// stores expiring keys by themselves may do nothing
+	Evict(idle func(tb *TokenBucket) bool)
+}
+
+// RangeStore is implemented by stores which can enumerate their key buckets, see KeyedLimiter.Range
+type RangeStore[K comparable] interface {
+	// Range calls 'fn' for every key bucket until it returns 'false'
+	Range(fn func(key K, tb *TokenBucket) bool)
+}
+
+// MemoryStore
+//
+//	keeps key buckets in memory, default Store of KeyedLimiter
+//
+//	Fields:
+//
+//	[shards]   key buckets split by key hash to avoid a single lock
+type MemoryStore[K comparable] struct {
+	shards []*memoryShard[K]
+}
+
+var (
+	_ Store[string]      = (*MemoryStore[string])(nil)
+	_ RangeStore[string] = (*MemoryStore[string])(nil)
+)
+
+// memoryShard
+//
+//	part of MemoryStore key buckets
+//
+//	Fields:
+//
+//	[buckets]   token buckets by key
+//	[lock]      mutex for atomic operations
+type memoryShard[K comparable] struct {
+	buckets map[K]*memoryItem
+	lock    sync.Mutex
+}
+
+// memoryItem
+//
+//	key bucket of MemoryStore shard
+//
+//	Fields:
+//
+//	[tb]     key bucket
+//	[refs]   number of running Do calls using the bucket, the bucket is never evicted while it is positive
+type memoryItem struct {
+	tb   *TokenBucket
+	refs int
+}
+
+// NewMemoryStore returns new MemoryStore entity instance
+func NewMemoryStore[K comparable]() *MemoryStore[K] {
+	return NewMemoryStoreShards[K](keyedShardsN)
+}
+
+// NewMemoryStoreShards returns new MemoryStore entity instance with 'n' independently locked shards.
+// default number of shards is used if 'n' is not positive
+func NewMemoryStoreShards[K comparable](n int) *MemoryStore[K] {
+	if n <= 0 {
+		n = keyedShardsN
+	}
+	ms := &MemoryStore[K]{
+		shards: make([]*memoryShard[K], n),
+	}
+
+	for i := range ms.shards {
+		ms.shards[i] = &memoryShard[K]{
+			buckets: map[K]*memoryItem{},
+		}
+	}
+
+	return ms
+}
+
+// shard returns shard for the key
+func (ms *MemoryStore[K]) shard(key K) *memoryShard[K] {
+	return ms.shards[hashKey(key)%uint32(len(ms.shards))]
+}
+
+// hashKey returns hash of the key.
+// strings and integers are hashed without allocations
+func hashKey[K comparable](key K) uint32 {
+	switch k := any(key).(type) {
+	case string:
+		return hashString(k)
+	case int:
+		return hashUint(uint64(k))
+	case int64:
+		return hashUint(uint64(k))
+	case int32:
+		return hashUint(uint64(k))
+	case uint:
+		return hashUint(uint64(k))
+	case uint64:
+		return hashUint(k)
+	case uint32:
+		return hashUint(uint64(k))
+	default:
+		return hashString(fmt.Sprintf("%#v", k))
+	}
+}
+
+// hashString returns 32-bit FNV-1a hash of the string
+func hashString(s string) uint32 {
+	h := uint32(2166136261)
+
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+
+	return h
+}
+
+// hashUint returns 32-bit hash of the integer mixed with splitmix64 finalizer
+func hashUint(x uint64) uint32 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return uint32(x)
+}
+
+// Do runs 'fn' with the key bucket, creating it on first use.
+// the bucket is shared, so operations of 'fn' are atomic by the bucket lock.
+// 'fn' runs without the shard lock, so slow 'fn' does not stall other keys and may use the store,
+// the bucket is referenced while 'fn' uses it, so it is never evicted meanwhile
+func (ms *MemoryStore[K]) Do(key K, create func() *TokenBucket, fn func(tb *TokenBucket)) error {
+	s := ms.shard(key)
+
+	s.lock.Lock()
+
+	item, ok := s.buckets[key]
+	if !ok {
+		item = &memoryItem{tb: create()}
+		s.buckets[key] = item
+	}
+	item.refs++
+
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		item.refs--
+		s.lock.Unlock()
+	}()
+	fn(item.tb)
+
+	return nil
+}
+
+// Delete removes the key bucket
+func (ms *MemoryStore[K]) Delete(key K) {
+	s := ms.shard(key)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.buckets, key)
+}
+
+// Len returns number of key buckets
+func (ms *MemoryStore[K]) Len() int {
+	n := 0
+
+	for _, s := range ms.shards {
+		s.lock.Lock()
+		n += len(s.buckets)
+		s.lock.Unlock()
+	}
+
+	return n
+}
+
+// Evict removes key buckets for which 'idle' returns 'true', buckets used by running Do calls are skipped
+func (ms *MemoryStore[K]) Evict(idle func(tb *TokenBucket) bool) {
+	for _, s := range ms.shards {
+		s.lock.Lock()
+
+		for key, item := range s.buckets {
+			if item.refs == 0 && idle(item.tb) {
+				delete(s.buckets, key)
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+// memoryEntry
+//
+//	key bucket in snapshot of MemoryStore
+//
+//	Fields:
+//
+//	[key]   bucket key
+//	[tb]    key bucket
+type memoryEntry[K comparable] struct {
+	key K
+	tb  *TokenBucket
+}
+
+// Range calls 'fn' for every key bucket until it returns 'false'.
+// keys are snapshotted first locking one shard at a time, then 'fn' is called without locks,
+// so it may use the store. keys added after the snapshot are not visited,
+// removed ones may be visited with their last bucket
+func (ms *MemoryStore[K]) Range(fn func(key K, tb *TokenBucket) bool) {
+	var entries []memoryEntry[K]
+
+	for _, s := range ms.shards {
+		s.lock.Lock()
+
+		for key, item := range s.buckets {
+			entries = append(entries, memoryEntry[K]{key: key, tb: item.tb})
+		}
+		s.lock.Unlock()
+	}
+	for _, e := range entries {
+		if !fn(e.key, e.tb) {
+			return
+		}
+	}
+}
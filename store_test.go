@@ -0,0 +1,171 @@
+package token_bucket
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreReentrantDo(t *testing.T) {
+	ms := NewMemoryStoreShards[string](1)
+	create := func() *TokenBucket { return NewTokenBucket(1, 1) }
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		_ = ms.Do("a", create, func(*TokenBucket) {
+			_ = ms.Do("a", create, func(*TokenBucket) {})
+			_ = ms.Do("b", create, func(*TokenBucket) {})
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do must not deadlock when 'fn' uses the store")
+	}
+	if ms.Len() != 2 {
+		t.Fatalf("got %d buckets, want 2", ms.Len())
+	}
+}
+
+func TestMemoryStoreSlowDoDoesNotStallShard(t *testing.T) {
+	ms := NewMemoryStoreShards[string](1)
+	create := func() *TokenBucket { return NewTokenBucket(1, 1) }
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		_ = ms.Do("slow", create, func(*TokenBucket) {
+			close(entered)
+			<-release
+		})
+	}()
+	<-entered
+
+	done := make(chan struct{})
+
+	go func() {
+		_ = ms.Do("fast", create, func(*TokenBucket) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow 'fn' must not block other keys of the shard")
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestMemoryStoreEvictSkipsRunningDo(t *testing.T) {
+	ms := NewMemoryStoreShards[string](1)
+	create := func() *TokenBucket { return NewTokenBucket(1, 1) }
+
+	var evicted []*TokenBucket
+
+	_ = ms.Do("a", create, func(tb *TokenBucket) {
+		ms.Evict(func(tb *TokenBucket) bool {
+			evicted = append(evicted, tb)
+			return true
+		})
+	})
+	if len(evicted) != 0 || ms.Len() != 1 {
+		t.Fatal("bucket used by running Do must not be evicted")
+	}
+	ms.Evict(func(*TokenBucket) bool { return true })
+
+	if ms.Len() != 0 {
+		t.Fatal("unused bucket must be evicted")
+	}
+}
+
+func TestKeyedLimiterConcurrent(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	kl := NewKeyedLimiter[int](100, 1, SetBucketOptions(SetClock(clock)), SetBucketReuse(true))
+	defer kl.Close()
+
+	var wg sync.WaitGroup
+	allowed := make([]int, 8)
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 1000; i++ {
+				if kl.Allow(i % 4) {
+					allowed[g]++
+				}
+				if i%100 == 0 {
+					kl.sweep()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, n := range allowed {
+		total += n
+	}
+	if total != 400 {
+		t.Fatalf("got %d allowed, want 100 per key", total)
+	}
+}
+
+func TestMemoryStoreShards(t *testing.T) {
+	if got := len(NewMemoryStoreShards[int](0).shards); got != keyedShardsN {
+		t.Fatalf("non-positive shards: got %d shards, want default %d", got, keyedShardsN)
+	}
+	ms := NewMemoryStoreShards[int](8)
+
+	for key := 0; key < 800; key++ {
+		ms.Do(key, func() *TokenBucket { return NewTokenBucket(1, 1) }, func(*TokenBucket) {})
+	}
+	if got := ms.Len(); got != 800 {
+		t.Fatalf("got %d key buckets, want 800", got)
+	}
+	// the keys are spread over all shards, so no single lock protects most of them
+	for i, shard := range ms.shards {
+		if n := len(shard.buckets); n < 50 || n > 150 {
+			t.Fatalf("shard %d holds %d of 800 keys", i, n)
+		}
+	}
+}
+
+func BenchmarkMemoryStoreShards(b *testing.B) {
+	keys := make([]string, 4096)
+
+	for i := range keys {
+		keys[i] = fmt.Sprint("key-", i)
+	}
+	for _, n := range []int{1, 16, 64} {
+		b.Run(fmt.Sprint("shards-", n), func(b *testing.B) {
+			kl := NewKeyedLimiter[string](1<<30, 1, SetShards(n), SetBucketOptions(SetRefillDuration(time.Hour)))
+			defer kl.Close()
+
+			for _, key := range keys {
+				kl.Allow(key)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+
+				for pb.Next() {
+					kl.Allow(keys[i%len(keys)])
+					i += 7
+				}
+			})
+		})
+	}
+}
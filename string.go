@@ -0,0 +1,31 @@
+package token_bucket
+
+import "fmt"
+
+// String returns human-readable state of the bucket, e.g. "TokenBucket(tokens=3/10, refill=5/1s)"
+// or "TokenBucket(name=api, tokens=3/10, refill=5/1s)" if the name is set.
+// the bucket is refilled first, so the tokens number is current
+func (tb *TokenBucket) String() string {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	if tb.name != "" {
+		return fmt.Sprintf("TokenBucket(name=%s, tokens=%d/%d, refill=%d/%s)",
+			tb.name, tb.currTokens, tb.maxTokens, tb.refillRate, tb.refillDur)
+	}
+	return fmt.Sprintf("TokenBucket(tokens=%d/%d, refill=%d/%s)",
+		tb.currTokens, tb.maxTokens, tb.refillRate, tb.refillDur)
+}
+
+// GoString returns state of the bucket for %#v
+func (tb *TokenBucket) GoString() string {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	return fmt.Sprintf("&token_bucket.TokenBucket{name:%q, maxTokens:%d, refillRate:%d, currTokens:%d, refillDur:%s, tokenN:%d}",
+		tb.name, tb.maxTokens, tb.refillRate, tb.currTokens, tb.refillDur, tb.Weight())
+}
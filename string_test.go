@@ -0,0 +1,33 @@
+package token_bucket
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestString(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 5, SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(7)
+
+	if got, want := tb.String(), "TokenBucket(tokens=3/10, refill=5/1s)"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	clock.Advance(time.Second)
+
+	named := NewTokenBucket(10, 5, SetName("api"), SetClock(clock))
+	defer named.Close()
+
+	if got, want := fmt.Sprint(named), "TokenBucket(name=api, tokens=10/10, refill=5/1s)"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	want := `&token_bucket.TokenBucket{name:"", maxTokens:10, refillRate:5, currTokens:8, refillDur:1s, tokenN:1}`
+
+	if got := fmt.Sprintf("%#v", tb); got != want {
+		t.Fatalf("String must refill first: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package token_bucket
+
+import "time"
+
+const subscriberBufferN = 16 // buffered refill events per subscriber
+
+// RefillEvent
+//
+//	refilling of the bucket delivered to subscribers
+//
+//	Fields:
+//
+//	[Added]   number of tokens added to the bucket
+//	[Time]    time of the refilling by the bucket clock
+type RefillEvent struct {
+	Added int
+	Time  time.Time
+}
+
+// Subscribe returns channel receiving event every time tokens are added to the bucket.
+// events are sent without blocking the bucket: the channel buffers 16 events
+// and newer events are dropped while it is full.
+// the channel is closed by Unsubscribe or Close, it is closed immediately for the closed bucket
+func (tb *TokenBucket) Subscribe() <-chan RefillEvent {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	ch := make(chan RefillEvent, subscriberBufferN)
+
+	if tb.closed {
+		close(ch)
+		return ch
+	}
+	tb.subs = append(tb.subs, ch)
+
+	return ch
+}
+
+// Unsubscribe stops sending events to the channel returned by Subscribe and closes it
+func (tb *TokenBucket) Unsubscribe(ch <-chan RefillEvent) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	for i, sub := range tb.subs {
+		if sub == ch {
+			tb.subs = append(tb.subs[:i], tb.subs[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
+
+// closeSubs closes channels of all subscribers
+func (tb *TokenBucket) closeSubs() {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	for _, sub := range tb.subs {
+		close(sub)
+	}
+	tb.subs = nil
+	tb.closed = true
+}
+
+// refilled notifies logger, refill callback and subscribers about tokens added at 'nowT'.
+// must be called under the lock
+func (tb *TokenBucket) refilled(added int64, nowT time.Time) {
+	if added <= 0 {
+		return
+	}
+	tb.logRefill(added)
+
+	if tb.onRefill != nil {
+		fn, current := tb.onRefill, int(tb.currTokens)
+
+		tb.later(func() {
+			fn(int(added), current)
+		})
+	}
+	for _, sub := range tb.subs {
+		select {
+		case sub <- RefillEvent{Added: int(added), Time: nowT}:
+		default:
+		}
+	}
+}
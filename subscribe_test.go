@@ -0,0 +1,102 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(5, 1, SetClock(clock))
+	defer tb.Close()
+
+	ch := tb.Subscribe()
+	tb.AllowN(5)
+
+	clock.Advance(3 * time.Second)
+	tb.Tokens()
+
+	select {
+	case ev := <-ch:
+		if ev.Added != 3 || !ev.Time.Equal(clock.Now()) {
+			t.Fatalf("got event %+v, want 3 tokens added at %v", ev, clock.Now())
+		}
+	default:
+		t.Fatal("refill event not delivered")
+	}
+	tb.Unsubscribe(ch)
+
+	if _, ok := <-ch; ok {
+		t.Fatal("channel not closed by Unsubscribe")
+	}
+}
+
+func TestSubscribeDropsWhenFull(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock))
+
+	ch := tb.Subscribe()
+
+	for i := 0; i < subscriberBufferN+4; i++ {
+		tb.Allow()
+		clock.Advance(time.Second)
+		tb.Tokens()
+	}
+	if got := len(ch); got != subscriberBufferN {
+		t.Fatalf("got %d buffered events, want %d", got, subscriberBufferN)
+	}
+	tb.Close()
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBufferN {
+		t.Fatalf("got %d events before close, want %d", n, subscriberBufferN)
+	}
+	if _, ok := <-tb.Subscribe(); ok {
+		t.Fatal("channel of closed bucket not closed")
+	}
+}
+
+func TestSetOnRefill(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	type refill struct{ added, current int }
+
+	var (
+		refills []refill
+		tb      *TokenBucket
+	)
+	tb = NewTokenBucket(5, 2, SetClock(clock), SetOnRefill(func(added, current int) {
+		refills = append(refills, refill{added, current})
+		// the callback runs outside of the lock, so it may use the bucket
+		tb.Capacity()
+	}))
+	defer tb.Close()
+
+	tb.Tokens()
+	tb.AllowN(5)
+	clock.Advance(time.Second)
+	tb.Tokens()
+	clock.Advance(time.Hour)
+	tb.Tokens()
+
+	want := []refill{{2, 2}, {3, 5}}
+
+	if len(refills) != len(want) || refills[0] != want[0] || refills[1] != want[1] {
+		t.Fatalf("got refills %+v, want %+v without the full bucket", refills, want)
+	}
+	clone := tb.Clone()
+	defer clone.Close()
+
+	clone.AllowN(1)
+	clock.Advance(time.Second)
+	clone.Tokens()
+
+	if len(refills) != 3 {
+		t.Fatalf("clone must keep the callback: got %d refills, want 3", len(refills))
+	}
+}
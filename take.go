@@ -0,0 +1,104 @@
+package token_bucket
+
+import (
+	"context"
+	"time"
+)
+
+// Take blocks until one token is available in the bucket and consumes it
+func (tb *TokenBucket) Take(ctx context.Context) error {
+	return tb.WaitN(ctx, 1)
+}
+
+// TakeN blocks until 'n' tokens are available in the bucket and consumes them, alias of WaitN
+func (tb *TokenBucket) TakeN(ctx context.Context, n int) error {
+	return tb.WaitN(ctx, n)
+}
+
+// AcquireAll blocks until 'n' tokens can be consumed in one shot and consumes them,
+// e.g. before starting a batch of 'n' goroutines together.
+// all 'n' tokens are reserved at once, so unlike 'n' calls of Take other consumers
+// can not interleave and the batch never holds only a part of the tokens.
+// returns ctx.Err() on cancellation with the reserved tokens returned to the bucket
+// and ErrExceedsMaxTokens if 'n' tokens can never fit in the bucket
+func (tb *TokenBucket) AcquireAll(ctx context.Context, n int) error {
+	return tb.WaitN(ctx, n)
+}
+
+// C returns channel emitting a value every time one token is consumed from the bucket,
+// so receiving from it never goes faster than the refill rate.
+// one token is consumed in advance for the next receiver. the channel is closed by Close
+func (tb *TokenBucket) C() <-chan struct{} {
+	tb.tokensOnce.Do(func() {
+		tb.tokensC = make(chan struct{})
+		go tb.emitter()
+	})
+	return tb.tokensC
+}
+
+// emitter sends value to the tokens channel for every consumed token until the bucket is closed
+func (tb *TokenBucket) emitter() {
+	defer close(tb.tokensC)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-tb.done
+		cancel()
+	}()
+
+	for {
+		if err := tb.WaitN(ctx, 1); err != nil {
+			return
+		}
+		select {
+		case tb.tokensC <- struct{}{}:
+		case <-tb.done:
+			return
+		}
+	}
+}
+
+// BackpressureChan returns channel which can be received from while the bucket has spare tokens
+// and blocks while it is saturated, so a producer selecting on it paces itself to the refill rate.
+// unlike C nothing is consumed, the producer still consumes the tokens by Allow.
+// the channel is closed by Close
+func (tb *TokenBucket) BackpressureChan() <-chan struct{} {
+	tb.pressureOnce.Do(func() {
+		tb.pressureC = make(chan struct{})
+		go tb.backpressure()
+	})
+	return tb.pressureC
+}
+
+// backpressure sends value to the backpressure channel while the bucket has spare tokens
+// and sleeps until the next token otherwise until the bucket is closed
+func (tb *TokenBucket) backpressure() {
+	defer close(tb.pressureC)
+
+	for {
+		delay := tb.DelayN(1)
+
+		if delay == infDuration {
+			// paused, draining or empty quota bucket may recover, recheck per refill duration
+			delay = tb.RefillDuration()
+		}
+		if delay == 0 {
+			select {
+			case tb.pressureC <- struct{}{}:
+			case <-tb.done:
+				return
+			}
+			continue
+		}
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-tb.done:
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetRefillDuration(20*time.Millisecond), SetTokenN(2), SetClock(clock))
+	defer tb.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Take consumes exactly one token regardless of the weight
+	if err := tb.Take(ctx); err != nil {
+		t.Fatalf("take: %v", err)
+	}
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1", got)
+	}
+	if err := tb.TakeN(ctx, 2); err != nil {
+		t.Fatalf("take 2 blocking until the refill: %v", err)
+	}
+}
+
+func TestC(t *testing.T) {
+	tb := NewTokenBucket(2, 1, SetRefillDuration(time.Hour))
+
+	c := tb.C()
+
+	if c != tb.C() {
+		t.Fatal("C must return the same channel")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatalf("token %d is not emitted", i)
+		}
+	}
+	select {
+	case <-c:
+		t.Fatal("token over the bucket capacity emitted")
+	case <-time.After(10 * time.Millisecond):
+	}
+	tb.Close()
+
+	if _, ok := <-c; ok {
+		t.Fatal("Close must close the channel")
+	}
+}
+
+func TestAcquireAll(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(3, 1, SetRefillDuration(20*time.Millisecond), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(2)
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- tb.AcquireAll(context.Background(), 3)
+	}()
+	// the batch reserves all 3 tokens at once, so the left token is not available for others
+	for storedTokens(tb) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if tb.Allow() {
+		t.Fatal("token reserved by the batch consumed by other caller")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("acquire all: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	clock.Advance(time.Second)
+
+	if err := tb.AcquireAll(ctx, 3); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if got := tb.Tokens(); got != 3 {
+		t.Fatalf("got %d tokens, want 3 returned on cancellation", got)
+	}
+	if err := tb.AcquireAll(context.Background(), 4); !errors.Is(err, ErrExceedsMaxTokens) {
+		t.Fatalf("got error %v, want ErrExceedsMaxTokens", err)
+	}
+}
+
+func TestBackpressureChan(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(20*time.Millisecond))
+
+	tb.Allow()
+	c := tb.BackpressureChan()
+
+	if c != tb.BackpressureChan() {
+		t.Fatal("BackpressureChan must return the same channel")
+	}
+	select {
+	case <-c:
+		t.Fatal("saturated bucket signaled spare tokens")
+	case <-time.After(5 * time.Millisecond):
+	}
+	clock.Advance(20 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatalf("signal %d of spare token is not sent", i)
+		}
+	}
+	// nothing is consumed by the signals
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1", got)
+	}
+	tb.Close()
+
+	timeout := time.After(time.Second)
+
+	for {
+		select {
+		case _, ok := <-c:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Close must close the channel")
+		}
+	}
+}
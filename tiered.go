@@ -0,0 +1,116 @@
+package token_bucket
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateSpec
+//
+//	limit of one tier of TieredLimiter
+//
+//	Fields:
+//
+//	[Rate]   number of requests or operations allowed per the duration
+//	[Per]    duration of the limit window
+type RateSpec struct {
+	Rate int
+	Per  time.Duration
+}
+
+// TieredLimiter
+//
+//	combines limits of several windows advertised together, e.g. 10 per second and 1000 per hour
+//
+//	Fields:
+//
+//	[tiers]     bucket per rate spec in order of the specs
+//	[ordered]   unique buckets ordered by address to lock without deadlocks
+type TieredLimiter struct {
+	tiers   []*TokenBucket
+	ordered []*TokenBucket
+}
+
+var _ Limiter = (*TieredLimiter)(nil)
+
+// NewTieredLimiter returns new TieredLimiter entity instance with bucket per tier
+// holding 'Rate' tokens and refilled with 'Rate' tokens every 'Per'
+func NewTieredLimiter(tiers ...RateSpec) (*TieredLimiter, error) {
+	buckets := make([]*TokenBucket, 0, len(tiers))
+
+	for i, spec := range tiers {
+		tb, err := NewTokenBucketChecked(spec.Rate, spec.Rate, SetRefillDuration(spec.Per))
+		if err != nil {
+			return nil, fmt.Errorf("token_bucket: invalid tier %d: %w", i, err)
+		}
+		buckets = append(buckets, tb)
+	}
+	return &TieredLimiter{
+		tiers:   buckets,
+		ordered: lockOrder(buckets),
+	}, nil
+}
+
+// AllowN return 'true' if there are 'n' tokens in every tier.
+// tokens are consumed from every tier atomically or not consumed at all, negative 'n' is always denied
+func (tl *TieredLimiter) AllowN(n int) bool {
+	return tl.AllowNErr(n) == nil
+}
+
+// Allow returns 'true' if every tier allows one request or operation
+func (tl *TieredLimiter) Allow() bool {
+	return tl.AllowN(defaultTokensN)
+}
+
+// AllowNErr returns nil if there are 'n' tokens in every tier,
+// otherwise *ErrTierLimited with the first denying tier. returns ErrNegativeTokens for negative 'n'
+func (tl *TieredLimiter) AllowNErr(n int) error {
+	if n < 0 {
+		return ErrNegativeTokens
+	}
+	lockAll(tl.ordered)
+	defer unlockAll(tl.ordered)
+
+	i := allowAll(tl.tiers, n)
+	if i < 0 {
+		return nil
+	}
+	tb := tl.tiers[i]
+
+	return &ErrTierLimited{
+		ErrRateLimited: ErrRateLimited{
+			retryAfter: tb.retryAfter(n, tb.now()),
+		},
+		tier: i,
+	}
+}
+
+// Tier returns bucket of the tier with index 'i' in order of the specs
+func (tl *TieredLimiter) Tier(i int) *TokenBucket {
+	return tl.tiers[i]
+}
+
+// ErrTierLimited
+//
+//	returned when there are not enough tokens in one of TieredLimiter tiers
+//
+//	Fields:
+//
+//	[tier]   index of the denying tier in order of the specs
+type ErrTierLimited struct {
+	ErrRateLimited
+	tier int
+}
+
+// Error implements error
+func (e *ErrTierLimited) Error() string {
+	if e.retryAfter == infDuration {
+		return fmt.Sprintf("token_bucket: rate limited by tier %d", e.tier)
+	}
+	return fmt.Sprintf("token_bucket: rate limited by tier %d, retry after %s", e.tier, e.retryAfter)
+}
+
+// Tier returns index of the denying tier
+func (e *ErrTierLimited) Tier() int {
+	return e.tier
+}
@@ -0,0 +1,48 @@
+package token_bucket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTieredLimiter(t *testing.T) {
+	tl, err := NewTieredLimiter(RateSpec{Rate: 2, Per: time.Hour}, RateSpec{Rate: 3, Per: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("valid tiers: %v", err)
+	}
+	if !tl.AllowN(2) {
+		t.Fatal("request within every tier denied")
+	}
+	var limited *ErrTierLimited
+
+	if err := tl.AllowNErr(1); !errors.As(err, &limited) || limited.Tier() != 0 {
+		t.Fatalf("got %v, want the first tier denying", err)
+	}
+	// the denied request consumed nothing from the second tier
+	if got := tl.Tier(1).Tokens(); got != 1 {
+		t.Fatalf("got %d tokens in the second tier, want 1", got)
+	}
+	if limited.RetryAfter() <= 0 || limited.RetryAfter() > time.Hour {
+		t.Fatalf("got retry after %s, want until the first tier refill", limited.RetryAfter())
+	}
+	if _, err := NewTieredLimiter(RateSpec{Rate: 1, Per: time.Second}, RateSpec{Rate: 0, Per: time.Hour}); err == nil {
+		t.Fatal("invalid tier is accepted")
+	}
+}
+
+func TestTieredLimiterNegativeN(t *testing.T) {
+	tl, err := NewTieredLimiter(RateSpec{Rate: 2, Per: time.Hour}, RateSpec{Rate: 3, Per: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("valid tiers: %v", err)
+	}
+	if tl.AllowN(-1) {
+		t.Fatal("negative tokens number allowed")
+	}
+	if err := tl.AllowNErr(-1); !errors.Is(err, ErrNegativeTokens) {
+		t.Fatalf("got error %v, want ErrNegativeTokens", err)
+	}
+	if tl.Tier(0).Tokens() != 2 || tl.Tier(1).Tokens() != 3 {
+		t.Fatal("denied negative request consumed tokens")
+	}
+}
@@ -0,0 +1,337 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+var (
+	// ErrExceedsMaxTokens returned when requested tokens number can never fit in the bucket
+	ErrExceedsMaxTokens = errors.New("token_bucket: requested tokens exceed max tokens")
+
+	// ErrNegativeTokens returned when negative tokens number is requested
+	ErrNegativeTokens = errors.New("token_bucket: requested tokens number is negative")
+
+	// ErrNoRefill returned when the bucket can not refill the missing tokens
+	ErrNoRefill = errors.New("token_bucket: bucket refill rate is zero")
+
+	// ErrWouldBlock returned when Wait would block longer than the maximum wait
+	ErrWouldBlock = errors.New("token_bucket: wait exceeds max wait")
+
+	// ErrPaused returned when tokens are requested from the paused bucket
+	ErrPaused = errors.New("token_bucket: bucket is paused")
+
+	// ErrDraining returned when tokens are requested from the bucket draining for shutdown
+	ErrDraining = errors.New("token_bucket: bucket is draining")
+)
+
+// AllowNCtx works as AllowN but returns 'false' and ctx.Err() without consuming
+// if the context is already done
+func (tb *TokenBucket) AllowNCtx(ctx context.Context, n int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return tb.AllowN(n), nil
+}
+
+// Wait blocks until there are enough tokens in the bucket and consumes them
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	return tb.WaitN(ctx, tb.Weight())
+}
+
+// WaitN blocks until 'n' tokens are available in the bucket and consumes them.
+// returns ErrNegativeTokens for negative 'n' and nil for zero 'n' without waiting.
+// returns ctx.Err() if the context is done before the tokens are accumulated
+// and context.DeadlineExceeded immediately if the tokens can not be accumulated before the context deadline
+func (tb *TokenBucket) WaitN(ctx context.Context, n int) error {
+	_, err := tb.WaitNTimed(ctx, n)
+	return err
+}
+
+// WaitNTimed works as WaitN and also returns duration actually spent waiting,
+// zero if the tokens were available immediately
+func (tb *TokenBucket) WaitNTimed(ctx context.Context, n int) (time.Duration, error) {
+	waited, err := tb.waitNTimed(ctx, n)
+
+	if tb.traceHook != nil {
+		tb.traceHook(ctx, waited)
+	}
+	return waited, err
+}
+
+// waitNTimed works as WaitNTimed without the trace hook
+func (tb *TokenBucket) waitNTimed(ctx context.Context, n int) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r, turn, err := tb.reserveWait(ctx, n)
+	if err != nil {
+		return 0, err
+	}
+	return waitReserved(ctx, r, turn)
+}
+
+// SetTraceHook set callback called after every Wait with the request context and duration spent waiting,
+// e.g. to add span event of the throttling to the request trace without the bucket importing the tracer.
+// the callback is called without the bucket lock. default: none
+func SetTraceHook(fn func(ctx context.Context, waited time.Duration)) Option {
+	return func(tb *TokenBucket) {
+		tb.traceHook = fn
+	}
+}
+
+// waitReserved blocks until the reserved tokens are available and the previous FIFO waiter returns.
+// returns duration spent waiting measured by the bucket clock, so it is exact with TestClock.
+// the reservation is canceled if the context is done first
+func waitReserved(ctx context.Context, r *Reservation, turn *waitTurn) (time.Duration, error) {
+	delay := r.Delay()
+
+	if delay == 0 && turn == nil {
+		return 0, nil
+	}
+	startT := r.tb.now()
+
+	if delay > 0 {
+		select {
+		case <-r.tb.wake.after(delay):
+		case <-ctx.Done():
+			r.Cancel()
+			turn.abandon()
+			return r.tb.now().Sub(startT), ctx.Err()
+		}
+	}
+	if err := turn.wait(ctx); err != nil {
+		turn.abandon()
+		return r.tb.now().Sub(startT), err
+	}
+	turn.finish()
+
+	return r.tb.now().Sub(startT), nil
+}
+
+// AllowWithin return 'true' if 'n' tokens are in the bucket or will be refilled within 'd'.
+// sleeps until the refill instead of polling the bucket, consumes nothing if returns 'false'.
+// zero 'n' is allowed without any changes, negative 'n' is always denied
+func (tb *TokenBucket) AllowWithin(d time.Duration, n int) bool {
+	if n <= 0 {
+		return n == 0
+	}
+	tb.lock.Lock()
+
+	r, err := tb.reserveLocked(n)
+	if err != nil || r.DelayFrom(tb.now()) > d {
+		// canceled, so the reservation grace does not return the tokens again
+		if r.ok {
+			r.canceled = true
+			tb.giveBack(r.tokens)
+		}
+		tb.countDenied()
+		tb.throttled(n)
+		tb.unlock()
+
+		return false
+	}
+	tb.countAllowed(int64(r.tokens))
+	r.used = true
+
+	delay := r.DelayFrom(tb.now())
+
+	tb.unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// AllowOrWait return 'true' if 'n' tokens are in the bucket or will be refilled within 'maxWait'.
+// instead of denying it reserves the next available tokens and sleeps until them,
+// so overloaded callers back off without retrying AllowN in a loop. works as AllowWithin
+func (tb *TokenBucket) AllowOrWait(n int, maxWait time.Duration) bool {
+	return tb.AllowWithin(maxWait, n)
+}
+
+// AllowBlockingUntil return 'true' if 'n' tokens are in the bucket or will be refilled before the deadline.
+// sleeps until the refill, the deadline in the past means single non-blocking attempt
+func (tb *TokenBucket) AllowBlockingUntil(deadline time.Time, n int) bool {
+	d := time.Until(deadline)
+
+	if d < 0 {
+		d = 0
+	}
+	return tb.AllowWithin(d, n)
+}
+
+// waitTurn
+//
+//	position of the waiter in FIFO order of returning from Wait
+//
+//	Fields:
+//
+//	[prev]   closed when the previous waiter returns, nil for the first waiter
+//	[done]   closed when this waiter returns
+type waitTurn struct {
+	prev chan struct{}
+	done chan struct{}
+}
+
+// reserveWait returns Reservation for 'n' tokens and the waiter turn if FIFO waiting is set.
+// returns context.DeadlineExceeded without reserving if the tokens are available only after the context deadline
+func (tb *TokenBucket) reserveWait(ctx context.Context, n int) (*Reservation, *waitTurn, error) {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	r, err := tb.reserveLocked(n)
+	if err != nil {
+		return r, nil, err
+	}
+	// the waiter either acts on the reservation or cancels it, so it never expires
+	r.used = true
+
+	delay := r.DelayFrom(tb.now())
+
+	if tb.maxWait > 0 && delay > tb.maxWait {
+		r.canceled = true
+		tb.giveBack(r.tokens)
+
+		return r, nil, ErrWouldBlock
+	}
+	if deadline, ok := ctx.Deadline(); ok && delay > time.Until(deadline) {
+		r.canceled = true
+		tb.giveBack(r.tokens)
+
+		return r, nil, context.DeadlineExceeded
+	}
+	if !tb.fifo {
+		return r, nil, nil
+	}
+	turn := &waitTurn{
+		prev: tb.lastTurn,
+		done: make(chan struct{}),
+	}
+	tb.lastTurn = turn.done
+
+	return r, turn, nil
+}
+
+// wait blocks until the previous waiter returns
+func (t *waitTurn) wait(ctx context.Context) error {
+	if t == nil || t.prev == nil {
+		return nil
+	}
+	select {
+	case <-t.prev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finish lets the next waiter return
+func (t *waitTurn) finish() {
+	if t != nil {
+		close(t.done)
+	}
+}
+
+// abandon lets the next waiter return after the previous one
+func (t *waitTurn) abandon() {
+	if t == nil {
+		return
+	}
+	if t.prev == nil {
+		close(t.done)
+		return
+	}
+	go func() {
+		<-t.prev
+		close(t.done)
+	}()
+}
+
+// NextAvailable returns time at which 'n' tokens will be in the bucket without consuming them.
+// returns zero time if 'n' tokens can never be available
+func (tb *TokenBucket) NextAvailable(n int) time.Time {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	nowT := tb.now()
+	wait := tb.retryAfter(n, nowT)
+
+	if wait == infDuration {
+		return time.Time{}
+	}
+	return nowT.Add(wait)
+}
+
+// DelayN returns duration until 'n' tokens will be in the bucket without consuming them.
+// returns zero if the tokens are available now
+// and math.MaxInt64 duration if 'n' tokens can never be available
+func (tb *TokenBucket) DelayN(n int) time.Duration {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	return tb.retryAfter(n, tb.now())
+}
+
+// RetryAfter returns duration until 'n' tokens are in the bucket, zero if they are available now.
+// 'n' over the bucket capacity is capped to the capacity, so the duration is until the bucket is full.
+// returns math.MaxInt64 duration if the tokens can never be available, e.g. the bucket is paused
+func RetryAfter(tb *TokenBucket, n int) time.Duration {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	tb.refill()
+
+	if int64(n) > tb.maxTokens {
+		n = int(tb.maxTokens)
+	}
+	return tb.retryAfter(n, tb.now())
+}
+
+// delay returns duration after which the bucket will have 'n' tokens
+func (tb *TokenBucket) delay(n int, nowT time.Time) time.Duration {
+	deficit := int64(n) - tb.currTokens
+	if deficit <= 0 {
+		return 0
+	}
+	if tb.continuous {
+		missing := float64(deficit) - tb.partTokens
+		wait := math.Ceil(missing / float64(tb.refillRate) * float64(tb.refillDur))
+
+		return time.Duration(wait)
+	}
+	intervals := (deficit-1)/tb.refillRate + 1
+
+	wait := tb.refillT.Sub(nowT) + time.Duration(intervals-1)*tb.refillDur
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// retryAfter returns duration after which the bucket will have 'n' tokens
+// or infinite duration if it never happens
+func (tb *TokenBucket) retryAfter(n int, nowT time.Time) time.Duration {
+	n = tb.clampOversize(n)
+
+	if tb.denying() || int64(n) > tb.maxTokens {
+		return infDuration
+	}
+	if tb.currTokens < int64(n) && tb.refillRate <= 0 {
+		return infDuration
+	}
+	return tb.delay(n, nowT)
+}
+
+// giveBack returns 'n' tokens taken in advance back to the bucket.
+// must be called under the lock
+func (tb *TokenBucket) giveBack(n int) {
+	tb.currTokens = fill(tb.currTokens, int64(n), tb.maxTokens)
+}
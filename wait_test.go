@@ -0,0 +1,481 @@
+package token_bucket
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWaitAvailable(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetClock(clock))
+	defer tb.Close()
+
+	for i := 0; i < 2; i++ {
+		waited, err := tb.WaitNTimed(context.Background(), 1)
+		if err != nil || waited != 0 {
+			t.Fatalf("wait %d: got %v, %v, want no wait", i, waited, err)
+		}
+	}
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("got %d tokens, want 0", got)
+	}
+}
+
+// awaitWaiter blocks until a Wait call of the bucket sleeps, so its start is read from the clock
+func awaitWaiter(tb *TokenBucket) {
+	for {
+		tb.wake.lock.Lock()
+		n := len(tb.wake.entries)
+		tb.wake.lock.Unlock()
+
+		if n > 0 {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestWaitBlocksUntilRefill(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(20*time.Millisecond))
+	defer tb.Close()
+
+	tb.Allow()
+
+	type result struct {
+		waited time.Duration
+		err    error
+	}
+	done := make(chan result)
+
+	go func() {
+		waited, err := tb.WaitNTimed(context.Background(), 1)
+		done <- result{waited, err}
+	}()
+	awaitWaiter(tb)
+	clock.Advance(20 * time.Millisecond)
+
+	// the waited time is read from the bucket clock, not from the wall clock
+	if r := <-done; r.err != nil || r.waited != 20*time.Millisecond {
+		t.Fatalf("got wait %v, %v, want exactly the 20ms delay of the mock clock", r.waited, r.err)
+	}
+}
+
+func TestWaitNTimedCanceled(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(time.Hour))
+	defer tb.Close()
+
+	tb.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		waited time.Duration
+		err    error
+	}
+	done := make(chan result)
+
+	go func() {
+		waited, err := tb.WaitNTimed(ctx, 1)
+		done <- result{waited, err}
+	}()
+	awaitWaiter(tb)
+	clock.Advance(5 * time.Minute)
+	cancel()
+
+	// the partial wait is returned with the context error
+	if r := <-done; !errors.Is(r.err, context.Canceled) || r.waited != 5*time.Minute {
+		t.Fatalf("got wait %v, %v, want 5m and context.Canceled", r.waited, r.err)
+	}
+}
+
+func TestWaitDeadlineTooShort(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(time.Hour))
+	defer tb.Close()
+
+	tb.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := tb.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded without waiting", err)
+	}
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("rejected wait must not keep the tokens: got %d, want 1", got)
+	}
+}
+
+func TestWaitDeadlineLongEnough(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(20*time.Millisecond))
+	defer tb.Close()
+
+	tb.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	done := make(chan error)
+
+	go func() {
+		done <- tb.Wait(ctx)
+	}()
+	// the delay fits the deadline, so the wait blocks instead of failing immediately
+	awaitWaiter(tb)
+	clock.Advance(20 * time.Millisecond)
+
+	if err := <-done; err != nil {
+		t.Fatalf("got error %v, want the wait within the deadline to succeed", err)
+	}
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("got %d tokens, want the refilled token consumed by the wait", got)
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(time.Hour))
+	defer tb.Close()
+
+	tb.Allow()
+
+	if err := tb.Wait(newDoneCtx()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	clock.Advance(time.Hour)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("canceled wait must return the reserved tokens: got %d, want 1", got)
+	}
+}
+
+func TestWaitNInvalid(t *testing.T) {
+	tb := NewTokenBucket(1, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	if err := tb.WaitN(context.Background(), -1); !errors.Is(err, ErrNegativeTokens) {
+		t.Fatalf("got error %v, want ErrNegativeTokens", err)
+	}
+	if err := tb.WaitN(context.Background(), 2); !errors.Is(err, ErrExceedsMaxTokens) {
+		t.Fatalf("got error %v, want ErrExceedsMaxTokens", err)
+	}
+}
+
+func TestNextAvailable(t *testing.T) {
+	startT := time.Unix(0, 0)
+	clock := NewTestClock(startT)
+
+	tb := NewTokenBucket(4, 1, SetClock(clock))
+	defer tb.Close()
+
+	if got := tb.NextAvailable(4); !got.Equal(startT) {
+		t.Fatalf("available tokens: got %s, want now", got)
+	}
+	tb.AllowN(4)
+	clock.Advance(300 * time.Millisecond)
+
+	if got, want := tb.NextAvailable(2), startT.Add(2*time.Second); !got.Equal(want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("NextAvailable must not consume: got %d tokens", got)
+	}
+	if got := tb.NextAvailable(5); !got.IsZero() {
+		t.Fatalf("tokens over max tokens must never be available: got %s", got)
+	}
+}
+
+func TestDelayN(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(4, 2, SetClock(clock))
+	defer tb.Close()
+
+	if got := tb.DelayN(4); got != 0 {
+		t.Fatalf("available tokens: got delay %s, want 0", got)
+	}
+	tb.AllowN(4)
+	clock.Advance(250 * time.Millisecond)
+
+	if got := tb.DelayN(3); got != 1750*time.Millisecond {
+		t.Fatalf("got delay %s, want 1.75s", got)
+	}
+	if got := tb.DelayN(5); got != infDuration {
+		t.Fatalf("tokens over max tokens: got delay %s, want math.MaxInt64", got)
+	}
+	if got := tb.Tokens(); got != 0 {
+		t.Fatalf("DelayN must not consume: got %d tokens", got)
+	}
+}
+
+func TestSetFIFOWait(t *testing.T) {
+	tb := NewTokenBucket(1, 1, SetRefillDuration(2*time.Millisecond), SetFIFOWait(true))
+	defer tb.Close()
+
+	tb.Allow()
+
+	const waiters = 5
+
+	var (
+		order = make(chan int, waiters)
+		turns = make([]chan struct{}, 0, waiters)
+	)
+	for i := 0; i < waiters; i++ {
+		go func(i int) {
+			if err := tb.Wait(context.Background()); err != nil {
+				t.Errorf("waiter %d: %v", i, err)
+			}
+			order <- i
+		}(i)
+
+		// the next waiter starts after this one has taken its turn
+		for {
+			tb.lock.Lock()
+			last := tb.lastTurn
+			tb.unlock()
+
+			if last != nil && (len(turns) == 0 || last != turns[len(turns)-1]) {
+				turns = append(turns, last)
+				break
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	for want := 0; want < waiters; want++ {
+		if got := <-order; got != want {
+			t.Fatalf("waiter %d returned in place %d", got, want)
+		}
+	}
+}
+
+func TestSetMaxWait(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(2, 1, SetRefillDuration(time.Hour), SetMaxWait(time.Minute), SetClock(clock))
+	defer tb.Close()
+
+	tb.AllowN(2)
+
+	if err := tb.Wait(context.Background()); !errors.Is(err, ErrWouldBlock) {
+		t.Fatalf("got error %v, want ErrWouldBlock", err)
+	}
+	clock.Advance(time.Hour - 20*time.Millisecond)
+
+	// the token is 20ms away, within the max wait
+	if err := tb.Wait(context.Background()); err != nil {
+		t.Fatalf("wait within max wait: %v", err)
+	}
+	if err := tb.Wait(context.Background()); !errors.Is(err, ErrWouldBlock) {
+		t.Fatalf("got error %v, want ErrWouldBlock", err)
+	}
+	clock.Advance(time.Hour + 20*time.Millisecond)
+
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("rejected wait must not keep the tokens: got %d, want 1", got)
+	}
+}
+
+func TestAllowNCtx(t *testing.T) {
+	tb := NewTokenBucket(1, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if allowed, err := tb.AllowNCtx(ctx, 1); allowed || !errors.Is(err, context.Canceled) {
+		t.Fatalf("done context: got %v, %v, want context.Canceled", allowed, err)
+	}
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("done context must not consume: got %d tokens", got)
+	}
+	if allowed, err := tb.AllowNCtx(context.Background(), 1); !allowed || err != nil {
+		t.Fatalf("got %v, %v, want allowed", allowed, err)
+	}
+	if allowed, err := tb.AllowNCtx(context.Background(), 1); allowed || err != nil {
+		t.Fatalf("empty bucket: got %v, %v, want denied without error", allowed, err)
+	}
+}
+
+func TestAllowWithin(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetRefillDuration(20*time.Millisecond), SetClock(clock))
+	defer tb.Close()
+
+	tb.Allow()
+
+	if tb.AllowWithin(10*time.Millisecond, 1) {
+		t.Fatal("token 20ms away allowed within 10ms")
+	}
+	startT := time.Now()
+
+	if !tb.AllowWithin(time.Second, 1) {
+		t.Fatal("token 20ms away denied within 1s")
+	}
+	if waited := time.Since(startT); waited < 20*time.Millisecond {
+		t.Fatalf("got wait %s, want sleeping until the refill in 20ms", waited)
+	}
+	clock.Advance(40 * time.Millisecond)
+
+	// the denied attempt consumed nothing, the allowed one took the first refill
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1", got)
+	}
+}
+
+func TestAllowWithinReservationGrace(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(10, 1, SetRefillDuration(time.Hour), SetClock(clock), SetReservationGrace(time.Second))
+	defer tb.Close()
+
+	tb.AllowN(10)
+
+	if tb.AllowWithin(time.Millisecond, 1) {
+		t.Fatal("token an hour away allowed within 1ms")
+	}
+	clock.Advance(time.Hour + 2*time.Second)
+
+	// the denied reservation is returned once, not again when its grace expires
+	if got := tb.Tokens(); got != 1 {
+		t.Fatalf("got %d tokens, want 1 refilled", got)
+	}
+}
+
+func TestAllowBlockingUntil(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetRefillDuration(20*time.Millisecond), SetClock(clock))
+	defer tb.Close()
+
+	tb.Allow()
+
+	// the deadline in the past means a single non-blocking attempt
+	if tb.AllowBlockingUntil(time.Now().Add(-time.Second), 1) {
+		t.Fatal("empty bucket allowed past the deadline")
+	}
+	if !tb.AllowBlockingUntil(time.Now().Add(time.Second), 1) {
+		t.Fatal("token 20ms away denied before the deadline in 1s")
+	}
+}
+
+func TestAllowOrWaitConcurrent(t *testing.T) {
+	tb := NewTokenBucket(1, 1, SetRefillDuration(10*time.Millisecond))
+	defer tb.Close()
+
+	tb.Allow()
+
+	const callers = 4
+
+	results := make(chan bool, callers)
+	startT := time.Now()
+
+	for i := 0; i < callers; i++ {
+		go func() {
+			results <- tb.AllowOrWait(1, time.Second)
+		}()
+	}
+	for i := 0; i < callers; i++ {
+		if !<-results {
+			t.Fatal("caller within max wait denied")
+		}
+	}
+	// every caller reserved its own refill, so the last one waits for the fourth
+	if waited := time.Since(startT); waited < 40*time.Millisecond {
+		t.Fatalf("got %s for 4 callers, want the callers spread over 40ms", waited)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(4, 2, SetClock(clock))
+	defer tb.Close()
+
+	if got := RetryAfter(tb, 1); got != 0 {
+		t.Fatalf("got %s, want 0 for available tokens", got)
+	}
+	tb.AllowN(4)
+	clock.Advance(300 * time.Millisecond)
+
+	if got := RetryAfter(tb, 3); got != 1700*time.Millisecond {
+		t.Fatalf("got %s, want 1.7s until the second refill", got)
+	}
+	// the request over the capacity waits until the bucket is full
+	if got := RetryAfter(tb, 10); got != 1700*time.Millisecond {
+		t.Fatalf("got %s, want 1.7s until the bucket is full", got)
+	}
+	tb.Pause()
+
+	if got := RetryAfter(tb, 1); got != infDuration {
+		t.Fatalf("got %s for paused bucket, want infinite duration", got)
+	}
+}
+
+func TestSetTraceHook(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	type traceKey struct{}
+
+	var (
+		traced []time.Duration
+		ids    []any
+	)
+	hook := SetTraceHook(func(ctx context.Context, waited time.Duration) {
+		traced = append(traced, waited)
+		ids = append(ids, ctx.Value(traceKey{}))
+	})
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(20*time.Millisecond), hook)
+	defer tb.Close()
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "req-1")
+
+	if err := tb.Wait(ctx); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	done := make(chan error)
+
+	go func() {
+		done <- tb.Wait(ctx)
+	}()
+	awaitWaiter(tb)
+	clock.Advance(20 * time.Millisecond)
+
+	if err := <-done; err != nil {
+		t.Fatalf("wait for refill: %v", err)
+	}
+	if len(traced) != 2 || traced[0] != 0 || traced[1] != 20*time.Millisecond {
+		t.Fatalf("got traced waits %v, want 0 and 20ms", traced)
+	}
+	if ids[0] != "req-1" || ids[1] != "req-1" {
+		t.Fatalf("hook must get the request context: got %v", ids)
+	}
+	kl := NewKeyedLimiter[string](1, 1, SetBucketOptions(SetClock(clock), SetRefillDuration(time.Hour), hook))
+	defer kl.Close()
+
+	// the failed wait is traced as well
+	if err := kl.WaitN(ctx, "a", 2); !errors.Is(err, ErrExceedsMaxTokens) {
+		t.Fatalf("got error %v, want ErrExceedsMaxTokens", err)
+	}
+	if err := kl.Wait(ctx, "b"); err != nil {
+		t.Fatalf("keyed wait: %v", err)
+	}
+	if len(traced) != 4 || traced[2] != 0 || traced[3] != 0 {
+		t.Fatalf("got traced waits %v, want keyed waits traced", traced)
+	}
+}
@@ -0,0 +1,108 @@
+package token_bucket
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// waker
+//
+//	wakes waiters of the bucket by single timer armed for the earliest waiter,
+//	so concurrent Wait calls do not arm a runtime timer each
+//
+//	Fields:
+//
+//	[entries]   waiters ordered by wake time
+//	[timer]     timer armed for the earliest waiter, nil until the first waiter
+//	[armedT]    wake time the timer is armed for, zero if it is not armed
+//	[lock]      mutex for atomic operations
+type waker struct {
+	entries wakeHeap
+	timer   *time.Timer
+	armedT  time.Time
+	lock    sync.Mutex
+}
+
+// wakeEntry
+//
+//	waiter of the waker
+//
+//	Fields:
+//
+//	[t]   time after which the waiter is woken
+//	[c]   closed when the waiter is woken
+type wakeEntry struct {
+	t time.Time
+	c chan struct{}
+}
+
+// wakeHeap is min-heap of waiters by wake time, implements heap.Interface
+type wakeHeap []wakeEntry
+
+func (h wakeHeap) Len() int           { return len(h) }
+func (h wakeHeap) Less(i, j int) bool { return h[i].t.Before(h[j].t) }
+func (h wakeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *wakeHeap) Push(x any) {
+	*h = append(*h, x.(wakeEntry))
+}
+
+func (h *wakeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = wakeEntry{}
+	*h = old[:n-1]
+
+	return e
+}
+
+// after returns channel closed after 'd'.
+// the channel of abandoned waiter is closed in time as well, so nothing leaks
+func (w *waker) after(d time.Duration) <-chan struct{} {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	e := wakeEntry{
+		t: time.Now().Add(d),
+		c: make(chan struct{}),
+	}
+	heap.Push(&w.entries, e)
+
+	if w.armedT.IsZero() || e.t.Before(w.armedT) {
+		w.arm(e.t)
+	}
+	return e.c
+}
+
+// arm sets the timer to fire at 't'.
+// must be called under the lock
+func (w *waker) arm(t time.Time) {
+	w.armedT = t
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(time.Until(t), w.fire)
+		return
+	}
+	// the timer may be already firing, extra firing wakes nobody before time
+	w.timer.Stop()
+	w.timer.Reset(time.Until(t))
+}
+
+// fire wakes all waiters whose time has come and arms the timer for the next one
+func (w *waker) fire() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	nowT := time.Now()
+
+	for len(w.entries) > 0 && !w.entries[0].t.After(nowT) {
+		close(heap.Pop(&w.entries).(wakeEntry).c)
+	}
+	w.armedT = time.Time{}
+
+	if len(w.entries) > 0 {
+		w.arm(w.entries[0].t)
+	}
+}
@@ -0,0 +1,107 @@
+package token_bucket
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWakerStress(t *testing.T) {
+	var (
+		w  waker
+		wg sync.WaitGroup
+	)
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		d := time.Duration(rnd.Int63n(int64(20 * time.Millisecond)))
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			startT := time.Now()
+
+			select {
+			case <-w.after(d):
+			case <-time.After(5 * time.Second):
+				t.Errorf("waiter of %s is not woken", d)
+				return
+			}
+			if waited := time.Since(startT); waited < d {
+				t.Errorf("waiter of %s woken early after %s", d, waited)
+			}
+		}()
+	}
+	wg.Wait()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.entries) != 0 {
+		t.Fatalf("got %d waiters left", len(w.entries))
+	}
+}
+
+func TestWaitManyWaiters(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(1, 1, SetClock(clock), SetRefillDuration(100*time.Microsecond))
+	defer tb.Close()
+
+	tb.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	// half of the waiters are canceled, the others must still be woken by the shared timer
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			waitCtx := ctx
+
+			if i%2 == 1 {
+				var cancelWait context.CancelFunc
+
+				waitCtx, cancelWait = context.WithTimeout(ctx, time.Duration(i)*50*time.Microsecond)
+				defer cancelWait()
+			}
+			if err := tb.Wait(waitCtx); err != nil && i%2 == 0 {
+				t.Errorf("waiter %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkWaker(b *testing.B) {
+	b.Run("waker", func(b *testing.B) {
+		var w waker
+
+		b.ReportAllocs()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				<-w.after(time.Microsecond)
+			}
+		})
+	})
+	b.Run("timer", func(b *testing.B) {
+		b.ReportAllocs()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				timer := time.NewTimer(time.Microsecond)
+				<-timer.C
+			}
+		})
+	})
+}
@@ -0,0 +1,60 @@
+package token_bucket
+
+import "time"
+
+const warmupFraction = 0.1 // fraction of 'maxTokens' available at the warmup start
+
+// SetWarmup set duration during which the effective capacity of the new or reset bucket
+// grows linearly from 10% of 'maxTokens' up to 'maxTokens', so a just started downstream
+// is not hit by a full burst. tokens set by SetInitialTokens or SetBurst are clamped
+// to the capacity at the warmup start
+func SetWarmup(d time.Duration) Option {
+	return func(tb *TokenBucket) {
+		tb.warmup = d
+	}
+}
+
+// startWarmup starts the warmup at the last filling time and clamps the tokens to the start capacity.
+// must be called under the lock or before the bucket is shared
+func (tb *TokenBucket) startWarmup() {
+	if tb.warmup <= 0 {
+		return
+	}
+	tb.warmupT = tb.lastFillT
+
+	if c := tb.capacityAt(tb.warmupT); tb.currTokens > c {
+		tb.currTokens = c
+	}
+}
+
+// capacityAt returns effective maximum number of tokens at 'nowT'.
+// must be called under the lock
+func (tb *TokenBucket) capacityAt(nowT time.Time) int64 {
+	if tb.warmup <= 0 {
+		return tb.maxTokens
+	}
+	elapsed := nowT.Sub(tb.warmupT)
+
+	if elapsed >= tb.warmup {
+		return tb.maxTokens
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	fraction := warmupFraction + (1-warmupFraction)*float64(elapsed)/float64(tb.warmup)
+
+	c := int64(float64(tb.maxTokens) * fraction)
+	if c < 1 {
+		c = 1
+	}
+	return c
+}
+
+// EffectiveCapacity returns maximum number of tokens the bucket can hold now,
+// less than Capacity during the warmup
+func (tb *TokenBucket) EffectiveCapacity() int {
+	tb.lock.Lock()
+	defer tb.unlock()
+
+	return int(tb.capacityAt(tb.now()))
+}
@@ -0,0 +1,38 @@
+package token_bucket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetWarmup(t *testing.T) {
+	clock := NewTestClock(time.Unix(0, 0))
+
+	tb := NewTokenBucket(100, 100, SetWarmup(10*time.Second), SetClock(clock))
+	defer tb.Close()
+
+	if got := tb.EffectiveCapacity(); got != 10 {
+		t.Fatalf("got capacity %d at the warmup start, want 10%%", got)
+	}
+	if tb.AllowN(11) || !tb.AllowN(10) {
+		t.Fatal("new bucket must allow only 10% burst")
+	}
+	clock.Advance(5 * time.Second)
+
+	if got := tb.EffectiveCapacity(); got != 55 {
+		t.Fatalf("got capacity %d in the middle of the warmup, want 55", got)
+	}
+	if got := tb.Tokens(); got != 55 {
+		t.Fatalf("refill must be capped at the effective capacity: got %d tokens, want 55", got)
+	}
+	clock.Advance(5 * time.Second)
+
+	if got := tb.EffectiveCapacity(); got != 100 {
+		t.Fatalf("got capacity %d after the warmup, want 100", got)
+	}
+	tb.Reset()
+
+	if got := tb.Tokens(); got != 10 {
+		t.Fatalf("reset must restart the warmup: got %d tokens, want 10", got)
+	}
+}
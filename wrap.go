@@ -0,0 +1,23 @@
+package token_bucket
+
+// Wrap returns function which calls 'fn' only if there are enough tokens in the bucket,
+// otherwise returns *ErrRateLimited without calling 'fn'
+func Wrap(tb *TokenBucket, fn func() error) func() error {
+	return func() error {
+		if err := tb.AllowErr(); err != nil {
+			return err
+		}
+		return fn()
+	}
+}
+
+// WrapN returns function which calls 'fn' only if there are 'n' tokens in the bucket,
+// otherwise returns *ErrRateLimited without calling 'fn'
+func WrapN(tb *TokenBucket, n int, fn func() error) func() error {
+	return func() error {
+		if err := tb.AllowNErr(n); err != nil {
+			return err
+		}
+		return fn()
+	}
+}
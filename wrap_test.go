@@ -0,0 +1,38 @@
+package token_bucket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWrap(t *testing.T) {
+	tb := NewTokenBucket(3, 1, SetClock(NewTestClock(time.Unix(0, 0))))
+	defer tb.Close()
+
+	calls := 0
+	errFn := errors.New("fn failed")
+
+	fn := Wrap(tb, func() error {
+		calls++
+		return errFn
+	})
+	if err := fn(); !errors.Is(err, errFn) {
+		t.Fatalf("error of the allowed call must be returned: got %v", err)
+	}
+	fnN := WrapN(tb, 2, func() error {
+		calls++
+		return nil
+	})
+	if err := fnN(); err != nil {
+		t.Fatalf("allowed call: %v", err)
+	}
+	var limited *ErrRateLimited
+
+	if err := fn(); !errors.As(err, &limited) {
+		t.Fatalf("got %v, want *ErrRateLimited", err)
+	}
+	if calls != 2 {
+		t.Fatalf("throttled function must not be called: got %d calls, want 2", calls)
+	}
+}